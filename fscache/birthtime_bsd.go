@@ -0,0 +1,21 @@
+//go:build darwin || freebsd || netbsd
+
+package fscache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// whether birthTime can report creation times on this platform
+const birthTimeSupported = true
+
+// time a file was created at, if its info carries it
+func birthTime(fi fs.FileInfo) (time.Time, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Birthtimespec.Unix()), true
+}
@@ -0,0 +1,17 @@
+//go:build !(darwin || freebsd || netbsd || windows)
+
+package fscache
+
+import (
+	"io/fs"
+	"time"
+)
+
+// whether birthTime can report creation times on this platform; the standard library does not expose statx on Linux,
+// so SetUseBirthTime refuses to use them
+const birthTimeSupported = false
+
+// time a file was created at, if its info carries it
+func birthTime(fi fs.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
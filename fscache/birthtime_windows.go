@@ -0,0 +1,21 @@
+//go:build windows
+
+package fscache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// whether birthTime can report creation times on this platform
+const birthTimeSupported = true
+
+// time a file was created at, if its info carries it
+func birthTime(fi fs.FileInfo) (time.Time, bool) {
+	attrs, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, attrs.CreationTime.Nanoseconds()), true
+}
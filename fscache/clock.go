@@ -0,0 +1,45 @@
+package fscache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, so that time-based behavior can be tested without waiting, e.g. with a clock advanced by hand.
+type Clock interface {
+	Now() time.Time
+}
+
+// clock telling the system's time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock telling the system's time, used unless another is set.
+var SystemClock Clock = systemClock{}
+
+// FakeClock is a Clock which only moves when told to, e.g. for tests.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock telling time now until advanced.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
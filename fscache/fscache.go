@@ -1,17 +1,42 @@
 package fscache
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type FSCache struct {
-	path string
+	// local directory holding items, if created from a path; empty for caches created with NewWithStorage
+	path    string
+	storage Storage
+	// number of hex digits of the hash of keys naming the subdirectory holding them; 0 for no sharding
+	shardLen int
+	// whether item age is taken from creation time rather than modification time, where available
+	useBirthTime atomic.Bool
+	// Clock to measure item age by, if set by SetClock
+	clock atomic.Value
+	// approximate number of items, maintained upon changes once ApproxLen scanned them first
+	counted   atomic.Bool
+	countOnce sync.Once
+	count     atomic.Int64
 }
 
+// maximum shardLen, i.e. 65536 subdirectories
+const MaxShardLen = 4
+
 type InstanceMap struct {
 	instance map[string]*FSCache
 	mux      sync.Mutex
@@ -43,37 +68,202 @@ func GetInstance(path string) (*FSCache, error) {
 	return inst, nil
 }
 
+// GetShardedInstance is like GetInstance, but the cache spreads items across subdirectories, named after the first
+// shardLen hex digits of the hash of their key, so no single directory holds all items.
+//
+// Items stored without sharding are not visible to a sharded cache on the same path, and vice versa.
+// GetShardedInstance fails if path is already in use with a different sharding.
+func GetShardedInstance(path string, shardLen int) (*FSCache, error) {
+	if shardLen < 1 || shardLen > MaxShardLen {
+		return nil, fmt.Errorf("invalid shardLen=%v; want 1 to %v", shardLen, MaxShardLen)
+	}
+	instanceMap.mux.Lock()
+	defer instanceMap.mux.Unlock()
+	inst, ok := instanceMap.instance[path]
+	if ok {
+		if inst.shardLen != shardLen {
+			return nil, fmt.Errorf("cache '%v' already in use with shardLen=%v", path, inst.shardLen)
+		}
+		return inst, nil
+	}
+
+	inst, err := New(path)
+	if err != nil {
+		return nil, err
+	}
+	inst.shardLen = shardLen
+
+	instanceMap.instance[path] = inst
+	return inst, nil
+}
+
 func New(path string) (*FSCache, error) {
 	// validate that directory
 	tmpf, err := os.CreateTemp(path, "dirvalidation.*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to validate write perms into '%v': creating file failed with %v", path, err)
 	}
-	os.Remove(tmpf.Name())
-	tmpf.Close()
+	// close before removing, and attempt both regardless of failures, so the file never outlives validation
+	closeErr := tmpf.Close()
+	removeErr := os.Remove(tmpf.Name())
+	if closeErr != nil || removeErr != nil {
+		return nil, fmt.Errorf("failed to validate write perms into '%v': cleaning up validation file failed with %v", path, errors.Join(closeErr, removeErr))
+	}
 
 	c := &FSCache{
-		path: path,
+		path:    path,
+		storage: DirStorage(path),
 	}
 	return c, nil
 }
 
-// List item names in cache.
-// Return the list of their names upon success, or a non-nil error upon failure.
-func (fc *FSCache) List() ([]string, error) {
-	entries, err := os.ReadDir(fc.path)
+// NewWithStorage creates a cache keeping its items in storage, and validates that it can write there.
+// Caches so created are not shared like those of GetInstance, and cannot be migrated with Migrate.
+func NewWithStorage(storage Storage) (*FSCache, error) {
+	name := fmt.Sprintf("storagevalidation.%v", time.Now().UnixNano())
+	if err := storage.Create(name, []byte{}, true); err != nil {
+		return nil, fmt.Errorf("failed to validate write perms into storage: creating file failed with %w", err)
+	}
+	if err := storage.Remove(name); err != nil {
+		return nil, fmt.Errorf("failed to validate write perms into storage: cleaning up validation file failed with %w", err)
+	}
+	return &FSCache{storage: storage}, nil
+}
+
+/*
+SetFileMode sets the permissions of files the cache creates from now on, e.g. 0640 to let a replay process of another
+user in the group read them; files are otherwise created with 0600. Subdirectories of sharded caches are created
+searchable by whoever may read files.
+
+SetFileMode fails unless the cache keeps items in a local directory, or if mode has bits other than permissions, lacks
+owner read and write, or grants write to others.
+*/
+func (fc *FSCache) SetFileMode(mode fs.FileMode) error {
+	if mode&^fs.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode %v; want permission bits only", mode)
+	} else if mode&0600 != 0600 {
+		return fmt.Errorf("invalid file mode %v; want owner read and write", mode)
+	} else if mode&0002 != 0 {
+		return fmt.Errorf("invalid file mode %v; want no write for others", mode)
+	}
+	dir, ok := fc.storage.(*dirStorage)
+	if !ok {
+		return fmt.Errorf("cannot set file mode of cache not in a local directory")
+	}
+	dir.mode.Store(uint32(mode))
+	return nil
+}
+
+// NewShardedWithStorage is like NewWithStorage, but the cache spreads items across shards like GetShardedInstance.
+func NewShardedWithStorage(storage Storage, shardLen int) (*FSCache, error) {
+	if shardLen < 1 || shardLen > MaxShardLen {
+		return nil, fmt.Errorf("invalid shardLen=%v; want 1 to %v", shardLen, MaxShardLen)
+	}
+	c, err := NewWithStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	c.shardLen = shardLen
+	return c, nil
+}
+
+// subdirectory holding a key, relative to the cache path
+func (fc *FSCache) shardOf(key string) string {
+	if fc.shardLen == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:fc.shardLen]
+}
+
+// name of the file holding a key, relative to the storage root
+func (fc *FSCache) itemName(key string) string {
+	return path.Join(fc.shardOf(key), key)
+}
+
+// directories holding items, relative to the storage root: the root, or its shard subdirectories if sharded
+func (fc *FSCache) itemDirs() ([]string, error) {
+	if fc.shardLen == 0 {
+		return []string{"."}, nil
+	}
+	entries, err := fc.storage.List(".")
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan path '%v': %v", fc.path, err)
 	}
-	cacheEntries := make([]string, 0)
+	dirs := make([]string, 0, len(entries))
 	for _, entry := range entries {
-		if !entry.IsDir() {
-			cacheEntries = append(cacheEntries, entry.Name())
+		if entry.IsDir() && len(entry.Name()) == fc.shardLen {
+			dirs = append(dirs, entry.Name())
 		}
 	}
+	return dirs, nil
+}
+
+// walk files holding items, across shards
+func (fc *FSCache) walkItems(visit func(dir string, entry fs.DirEntry) error) error {
+	dirs, err := fc.itemDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		entries, err := fc.storage.List(dir)
+		if err != nil {
+			return fmt.Errorf("failed to scan path '%v': %v", path.Join(fc.path, dir), err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				if err := visit(dir, entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// List item names in cache.
+// Return the list of their names upon success, or a non-nil error upon failure.
+func (fc *FSCache) List() ([]string, error) {
+	cacheEntries := make([]string, 0)
+	err := fc.walkItems(func(dir string, entry fs.DirEntry) error {
+		cacheEntries = append(cacheEntries, entry.Name())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return cacheEntries, nil
 }
 
+/*
+ListStream calls fn with the name of each item in cache, in no particular order, while scanning the cache, so large
+caches are listed without holding all names at once; List is simpler for small caches.
+
+If fn returns error, ListStream stops and returns it as is. It returns a non-nil error if scanning fails, too.
+*/
+func (fc *FSCache) ListStream(fn func(name string) error) error {
+	dirs, err := fc.itemDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		var fnerr error
+		err := listFunc(fc.storage, dir, func(entry fs.DirEntry) error {
+			if entry.IsDir() {
+				return nil
+			}
+			fnerr = fn(entry.Name())
+			return fnerr
+		})
+		if fnerr != nil {
+			return fnerr
+		} else if err != nil {
+			return fmt.Errorf("failed to scan path '%v': %w", path.Join(fc.path, dir), err)
+		}
+	}
+	return nil
+}
+
 func (fc *FSCache) Set(key string, value []byte) error {
 	if fc == nil {
 		return fmt.Errorf("uninitialized filesystem cache given")
@@ -81,97 +271,503 @@ func (fc *FSCache) Set(key string, value []byte) error {
 	if value == nil {
 		return nil
 	}
-	f, err := os.CreateTemp(fc.path, key+".*")
-	if err != nil {
-		return fmt.Errorf("failed to create tempfile to cache '%v': %v", key, err)
+	existed := fc.counted.Load() && fc.Exists(key)
+	if err := fc.storage.Create(fc.itemName(key), value, false); err != nil {
+		return fmt.Errorf("failed to cache '%v': %w", key, err)
 	}
-	defer f.Close()
-	_, werr := f.Write(value)
-	if werr != nil {
-		os.Remove(f.Name())
-		return werr
+	if !existed {
+		fc.adjustCount(1)
+	}
+	if err := fc.stamp(key); err != nil {
+		return fmt.Errorf("failed to set time of '%v': %w", key, err)
 	}
-	f.Truncate(int64(len(value)))
-	newpath := path.Join(fc.path, key)
-	os.Rename(f.Name(), newpath)
 	return nil
 }
 
+// Sync returns once an item is flushed to stable storage, so it survives a power failure, if its storage buffers writes
+// like local directories do. Set and SetIfAbsent do not wait for that, as it is costly.
+func (fc *FSCache) Sync(key string) error {
+	syncer, ok := fc.storage.(Syncer)
+	if !ok {
+		return nil
+	}
+	if err := syncer.Sync(fc.itemName(key)); err != nil {
+		return fmt.Errorf("failed to sync '%v': %w", key, err)
+	}
+	return nil
+}
+
+// atomically create an element only if it does not exist yet.
+// Return whether it was created, or a non-nil error upon failure.
+func (fc *FSCache) SetIfAbsent(key string, value []byte) (bool, error) {
+	if fc == nil {
+		return false, fmt.Errorf("uninitialized filesystem cache given")
+	}
+	err := fc.storage.Create(fc.itemName(key), value, true)
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to create '%v': %v", key, err)
+	}
+	fc.adjustCount(1)
+	if err := fc.stamp(key); err != nil {
+		return true, fmt.Errorf("failed to set time of '%v': %v", key, err)
+	}
+	return true, nil
+}
+
+/*
+SetUseBirthTime sets whether GetExpiry and ClearExpired take the age of items from the time their file was created,
+instead of last modified, so touching a file does not extend its lifetime. Setting an item creates its file anew, so its
+age restarts regardless.
+
+SetUseBirthTime(true) fails, leaving ages taken from modification times, unless the platform and storage report creation
+times, i.e. local directories on macOS, FreeBSD, NetBSD and Windows.
+*/
+func (fc *FSCache) SetUseBirthTime(use bool) error {
+	if use && !birthTimeSupported {
+		return fmt.Errorf("cannot use birth time: not reported on this platform")
+	} else if _, ok := fc.storage.(*dirStorage); use && !ok {
+		return fmt.Errorf("cannot use birth time of cache not in a local directory")
+	}
+	fc.useBirthTime.Store(use)
+	return nil
+}
+
+/*
+SetClock sets the clock GetExpiry and ClearExpired measure the age of items by, e.g. a fake one in tests; nil restores
+SystemClock. With another clock, items set in a local directory get their modification time from it too, so their age
+is consistent; other storages keep the times they record, and so do creation times with SetUseBirthTime.
+*/
+func (fc *FSCache) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	fc.clock.Store(&clock)
+}
+
+// current time per the cache's clock
+func (fc *FSCache) now() time.Time {
+	if clock, ok := fc.clock.Load().(*Clock); ok {
+		return (*clock).Now()
+	}
+	return time.Now()
+}
+
+// set the modification time of an item just written to the time per the cache's clock, if other than SystemClock
+func (fc *FSCache) stamp(key string) error {
+	clock, ok := fc.clock.Load().(*Clock)
+	if !ok || *clock == SystemClock {
+		return nil
+	}
+	dir, ok := fc.storage.(*dirStorage)
+	if !ok {
+		return nil
+	}
+	now := (*clock).Now()
+	return os.Chtimes(dir.path(fc.itemName(key)), now, now)
+}
+
+// time an item's age is counted from: its creation time if SetUseBirthTime and available, else its modification time
+func (fc *FSCache) itemTime(fi fs.FileInfo) time.Time {
+	if fc.useBirthTime.Load() {
+		if btime, ok := birthTime(fi); ok {
+			return btime
+		}
+	}
+	return fi.ModTime()
+}
+
 // return a cached element only if it's younger than a given duration
 func (fc *FSCache) GetExpiry(key string, maxAge time.Duration) []byte {
-	p := path.Join(fc.path, key)
-	fstat, err := os.Stat(p)
+	name := fc.itemName(key)
+	fstat, err := fc.storage.Stat(name)
 	if err != nil {
 		return nil
 	}
-	if maxAge.Nanoseconds() > 0 && time.Since(fstat.ModTime()) > maxAge {
+	if maxAge.Nanoseconds() > 0 && fc.now().Sub(fc.itemTime(fstat)) > maxAge {
 		// found, but too old
 		return nil
 	}
-	data, err := os.ReadFile(p)
+	data, err := readFile(fc.storage, name)
 	if err != nil {
 		return nil
 	}
-	return data
+	value, _ := decodeEntry(data)
+	return value
+}
+
+// return whether an element exists, without reading it. Elements failing to be stat'ed count as absent.
+func (fc *FSCache) Exists(key string) bool {
+	_, err := fc.storage.Stat(fc.itemName(key))
+	return err == nil
+}
+
+// return the modification time of an element, and whether it exists, without reading it
+func (fc *FSCache) ModTime(key string) (time.Time, bool) {
+	fstat, err := fc.storage.Stat(fc.itemName(key))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fstat.ModTime(), true
 }
 
 func (fc *FSCache) Get(key string) []byte {
 	return fc.GetExpiry(key, time.Duration(0))
 }
 
+// return a cached element along with its modification time, and whether it exists
+func (fc *FSCache) GetWithMeta(key string) ([]byte, time.Time, bool) {
+	name := fc.itemName(key)
+	fstat, err := fc.storage.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	data, err := readFile(fc.storage, name)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	value, _ := decodeEntry(data)
+	return value, fstat.ModTime(), true
+}
+
+/*
+Entries stored with metadata begin with a header:
+
+	entryMagic | length of metadata (uint32, big endian) | metadata (JSON object of strings)
+
+followed by the value. Entries lacking entryMagic are plain values, as stored by Set.
+*/
+var entryMagic = []byte("\x00fscm1\x00")
+
+// serialize a value along with its metadata
+func encodeEntry(value []byte, meta map[string]string) ([]byte, error) {
+	jmeta, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	entry := make([]byte, 0, len(entryMagic)+4+len(jmeta)+len(value))
+	entry = append(entry, entryMagic...)
+	entry = binary.BigEndian.AppendUint32(entry, uint32(len(jmeta)))
+	entry = append(entry, jmeta...)
+	return append(entry, value...), nil
+}
+
+// length of the metadata of an entry from its header, or false if the header is missing or malformed
+func entryMetaLen(header []byte) (int, bool) {
+	if len(header) < len(entryMagic)+4 || !bytes.Equal(header[:len(entryMagic)], entryMagic) {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(header[len(entryMagic):])), true
+}
+
+// split an entry into its value and metadata; entries without header are returned as value, with nil metadata
+func decodeEntry(data []byte) ([]byte, map[string]string) {
+	metaLen, ok := entryMetaLen(data)
+	start := len(entryMagic) + 4
+	if !ok || len(data)-start < metaLen {
+		return data, nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(data[start:start+metaLen], &meta); err != nil {
+		return data, nil
+	}
+	return data[start+metaLen:], meta
+}
+
+// store a value along with metadata, in the same file.
+// Get and its variants return the value alone; GetMeta and GetWithMetadata return the metadata too.
+func (fc *FSCache) SetWithMetadata(key string, value []byte, meta map[string]string) error {
+	entry, err := encodeEntry(value, meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of '%v': %v", key, err)
+	}
+	return fc.Set(key, entry)
+}
+
+// return a cached value along with its metadata, and whether it exists. Metadata is nil for values stored by Set.
+func (fc *FSCache) GetWithMetadata(key string) ([]byte, map[string]string, bool) {
+	data, err := readFile(fc.storage, fc.itemName(key))
+	if err != nil {
+		return nil, nil, false
+	}
+	value, meta := decodeEntry(data)
+	return value, meta, true
+}
+
+// return the metadata of a cached value, reading only its header, and whether the value exists.
+// Metadata is nil for values stored by Set.
+func (fc *FSCache) GetMeta(key string) (map[string]string, bool) {
+	f, err := fc.storage.Open(fc.itemName(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	header := make([]byte, len(entryMagic)+4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		// too short to have a header
+		return nil, true
+	}
+	metaLen, ok := entryMetaLen(header)
+	if !ok {
+		return nil, true
+	}
+	jmeta := make([]byte, metaLen)
+	if _, err := io.ReadFull(f, jmeta); err != nil {
+		return nil, true
+	}
+	var meta map[string]string
+	if err := json.Unmarshal(jmeta, &meta); err != nil {
+		return nil, true
+	}
+	return meta, true
+}
+
 func (fc *FSCache) Clear() error {
-	direntries, err := os.ReadDir(fc.path)
+	direntries, err := fc.storage.List(".")
 	if err != nil {
 		return fmt.Errorf("failed to Clear() cacheDir '%v': %v", fc.path, err)
 	}
 	var nerr error = nil
 	for _, dirent := range direntries {
-		nerr = os.RemoveAll(path.Join(fc.path, dirent.Name()))
+		nerr = fc.removeAll(dirent)
+	}
+	if fc.counted.Load() {
+		fc.count.Store(int64(fc.Len()))
 	}
 	return nerr
 }
 
+// remove an entry of the storage root, along with its content if it is a directory
+func (fc *FSCache) removeAll(dirent fs.DirEntry) error {
+	if dirent.IsDir() {
+		entries, err := fc.storage.List(dirent.Name())
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fc.storage.Remove(path.Join(dirent.Name(), entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fc.storage.Remove(dirent.Name())
+}
+
 func (fc *FSCache) Unset(key string) bool {
-	p := path.Join(fc.path, key)
-	_, err := os.Stat(p)
+	name := fc.itemName(key)
+	_, err := fc.storage.Stat(name)
 	if err != nil {
 		return false
 	}
-	os.Remove(p)
+	if fc.storage.Remove(name) == nil {
+		fc.adjustCount(-1)
+	}
 	return true
 }
 
 // clear all items older than a given age
 func (fc *FSCache) ClearExpired(age time.Duration) error {
-	direntries, err := os.ReadDir(fc.path)
-	if err != nil {
-		return fmt.Errorf("failed to ClearExpiry(%v) cacheDir '%v': %v", age, fc.path, err)
-	}
-	for _, dirent := range direntries {
-		if !dirent.IsDir() {
-			statInfo, statErr := dirent.Info()
-			if statErr == nil && time.Since(statInfo.ModTime()) > age {
-				expFn := path.Join(fc.path, dirent.Name())
-				remErr := os.Remove(expFn)
-				if remErr != nil {
-					return fmt.Errorf("failed to clear expired '%v': %v", expFn, remErr)
-				}
+	_, err := fc.ClearExpiredKeys(age)
+	return err
+}
+
+// ClearExpiredKeys is like ClearExpired, but also returns the keys of the items it cleared, e.g. to log them. It stops at
+// the first item it fails to clear, returning the keys cleared until then along with the error.
+func (fc *FSCache) ClearExpiredKeys(age time.Duration) ([]string, error) {
+	return fc.ClearExpiredPrefix("", age)
+}
+
+// ClearExpiredPrefix is like ClearExpiredKeys, but only clears items whose key starts with prefix.
+func (fc *FSCache) ClearExpiredPrefix(prefix string, age time.Duration) ([]string, error) {
+	var cleared []string
+	err := fc.walkItems(func(dir string, dirent fs.DirEntry) error {
+		if !strings.HasPrefix(dirent.Name(), prefix) {
+			return nil
+		}
+		statInfo, statErr := dirent.Info()
+		if statErr == nil && fc.now().Sub(fc.itemTime(statInfo)) > age {
+			expFn := path.Join(dir, dirent.Name())
+			remErr := fc.storage.Remove(expFn)
+			if remErr != nil {
+				return fmt.Errorf("failed to clear expired '%v': %v", expFn, remErr)
 			}
+			fc.adjustCount(-1)
+			cleared = append(cleared, dirent.Name())
 		}
+		return nil
+	})
+	if err != nil {
+		return cleared, fmt.Errorf("failed to ClearExpiry(%v) cacheDir '%v': %v", age, fc.path, err)
 	}
-	return nil
+	return cleared, nil
 }
 
 func (fc *FSCache) Len() uint {
-	direntries, err := os.ReadDir(fc.path)
+	var n uint = 0
+	err := fc.walkItems(func(dir string, dirent fs.DirEntry) error {
+		n++
+		return nil
+	})
 	if err != nil {
 		return 0
 	}
-	var n uint = 0
-	for _, dirent := range direntries {
-		if !dirent.IsDir() {
-			n++
+	return n
+}
+
+/*
+Return the approximate number of items in cache, in constant time.
+
+The first call scans the cache like Len; later ones return a count maintained by Set, SetIfAbsent, Unset, Clear,
+ClearExpired and Migrate on this instance. The count misses changes made by other processes or instances, or to the
+directory directly, and may drift if the same key is set concurrently. Use Len where accuracy matters.
+*/
+func (fc *FSCache) ApproxLen() uint {
+	fc.countOnce.Do(func() {
+		fc.count.Store(int64(fc.Len()))
+		fc.counted.Store(true)
+	})
+	if n := fc.count.Load(); n > 0 {
+		return uint(n)
+	}
+	return 0
+}
+
+// account for items added (delta > 0) or removed (delta < 0), if ApproxLen is maintained
+func (fc *FSCache) adjustCount(delta int64) {
+	if fc.counted.Load() {
+		fc.count.Add(delta)
+	}
+}
+
+/*
+Move all items of the cache at oldPath into the cache at newPath, preserving their modification time.
+Items are renamed when both paths are on the same filesystem, and copied then deleted otherwise.
+Items already existing under newPath are left in place at oldPath, and reported in the returned error.
+
+Caches sharded like GetShardedInstance are detected by their shard subdirectories, and migrated alike into newPath,
+which must not hold items of another sharding.
+Afterwards, GetInstance(newPath) or GetShardedInstance(newPath) returns a cache holding the items, and oldPath is
+revalidated when used again.
+Return the number of items moved, and a non-nil error if any item failed to move.
+*/
+func Migrate(oldPath string, newPath string) (int, error) {
+	if path.Clean(oldPath) == path.Clean(newPath) {
+		return 0, fmt.Errorf("cannot migrate cache '%v' onto itself", oldPath)
+	}
+	shardLen, err := detectShardLen(oldPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect sharding of cache to migrate from: %v", err)
+	}
+	if dstShardLen, err := detectShardLen(newPath); err != nil {
+		return 0, fmt.Errorf("failed to detect sharding of cache to migrate into: %v", err)
+	} else if dstShardLen != 0 && dstShardLen != shardLen {
+		return 0, fmt.Errorf("cannot migrate cache with shardLen=%v into '%v' holding shardLen=%v", shardLen, newPath, dstShardLen)
+	}
+	src, err := instanceFor(oldPath, shardLen)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load cache to migrate from: %v", err)
+	}
+	dst, err := instanceFor(newPath, shardLen)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load cache to migrate into: %v", err)
+	}
+	keys, err := src.List()
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	var errs []error
+	for _, key := range keys {
+		to := path.Join(dst.path, dst.itemName(key))
+		err := os.Mkdir(path.Dir(to), 0700)
+		if err == nil || errors.Is(err, fs.ErrExist) {
+			err = moveItem(path.Join(src.path, src.itemName(key)), to)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to migrate '%v': %v", key, err))
+			continue
 		}
+		src.adjustCount(-1)
+		dst.adjustCount(1)
+		moved++
 	}
-	return n
+
+	// forget oldPath, so it gets revalidated if used again, e.g. after being unmounted
+	instanceMap.mux.Lock()
+	delete(instanceMap.instance, oldPath)
+	instanceMap.mux.Unlock()
+
+	return moved, errors.Join(errs...)
+}
+
+// length of the shard subdirectories of the cache directory dir, or 0 if it has none
+func detectShardLen(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	shardLen := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			continue
+		} else if len(name) > MaxShardLen || strings.Trim(name, "0123456789abcdef") != "" {
+			return 0, fmt.Errorf("'%v' holds directory '%v', which is no shard", dir, name)
+		} else if shardLen != 0 && len(name) != shardLen {
+			return 0, fmt.Errorf("'%v' holds shards of different lengths %v and %v", dir, shardLen, len(name))
+		}
+		shardLen = len(name)
+	}
+	return shardLen, nil
+}
+
+// shared cache at path, like GetShardedInstance if shardLen > 0 or else GetInstance
+func instanceFor(path string, shardLen int) (*FSCache, error) {
+	if shardLen > 0 {
+		return GetShardedInstance(path, shardLen)
+	}
+	return GetInstance(path)
+}
+
+// move a file, falling back to copying it when it cannot be renamed, e.g. across filesystems
+func moveItem(from string, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("'%v' already exists", to)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	return copyItem(from, to)
+}
+
+// copy a file into a tempfile next to its destination then rename it, so the destination never holds partial content
+func copyItem(from string, to string) error {
+	fstat, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(path.Dir(to), path.Base(to)+".*")
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(data)
+	cerr := f.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(f.Name())
+		return errors.Join(werr, cerr)
+	}
+	if err := os.Chtimes(f.Name(), fstat.ModTime(), fstat.ModTime()); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), to); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Remove(from)
 }
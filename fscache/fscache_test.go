@@ -2,7 +2,9 @@ package fscache
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path"
@@ -41,6 +43,22 @@ func TestConstructionOnInvalidPath(t *testing.T) {
 	}
 }
 
+func TestConstructionFailureLeavesNoFiles(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	fc, err := New(path.Join(fpath, "missing"))
+	if err == nil || fc != nil {
+		t.Fatalf("New() unexpectedly returned success operating on inexisting path")
+	}
+	allfiles, _ := os.ReadDir(fpath)
+	if len(allfiles) != 0 {
+		t.Fatalf("fscache.New() unexpectedly left files behind after failing: %v", allfiles)
+	}
+}
+
 func TestGetInstanceOnValidPath(t *testing.T) {
 	fpath, derr := os.MkdirTemp("", "test.*")
 	if derr != nil {
@@ -158,6 +176,33 @@ func TestSetValid(t *testing.T) {
 	}
 }
 
+func TestSetIfAbsent(t *testing.T) {
+	fpath, derr := os.MkdirTemp("", "test.*")
+	if derr != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", derr)
+	}
+	defer os.Remove(fpath)
+	fc, _ := GetInstance(fpath)
+	defer fc.Clear()
+
+	created, err := fc.SetIfAbsent("claim", []byte("first"))
+	if err != nil || !created {
+		t.Fatalf("SetIfAbsent() of absent key returns created=%v err=%v", created, err)
+	}
+	created, err = fc.SetIfAbsent("claim", []byte("second"))
+	if err != nil || created {
+		t.Fatalf("SetIfAbsent() of existing key returns created=%v err=%v", created, err)
+	}
+	if data := fc.Get("claim"); !bytes.Equal(data, []byte("first")) {
+		t.Fatalf("SetIfAbsent() overwrote existing value with '%v'", string(data))
+	}
+	fc.Unset("claim")
+	created, _ = fc.SetIfAbsent("claim", []byte("third"))
+	if !created {
+		t.Fatalf("SetIfAbsent() fails to create key after Unset()")
+	}
+}
+
 func TestSetUninitializedError(t *testing.T) {
 	fc := &FSCache{}
 	fc = nil
@@ -316,6 +361,37 @@ func TestGetExpiry(t *testing.T) {
 	}
 }
 
+func TestGetWithMeta(t *testing.T) {
+	fpath, derr := os.MkdirTemp("", "test.*")
+	if derr != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", derr)
+	}
+	defer os.Remove(fpath)
+	fc, _ := GetInstance(fpath)
+	defer fc.Clear()
+	// non-set value
+	data, mtime, ok := fc.GetWithMeta("foobar")
+	if ok || data != nil || !mtime.IsZero() {
+		log.Fatalf("GetWithMeta() of previously-unset value returns ok=%v data='%v' mtime=%v", ok, data, mtime)
+	}
+	// value set to empty
+	tbefore := time.Now().Add(-time.Second)
+	fc.Set("foobar", []byte(""))
+	data, mtime, ok = fc.GetWithMeta("foobar")
+	if !ok || data == nil || len(data) != 0 {
+		log.Fatalf("GetWithMeta() of previously-set '' value returns ok=%v data='%v'", ok, data)
+	}
+	if mtime.Before(tbefore) || mtime.After(time.Now()) {
+		log.Fatalf("GetWithMeta() returns ModTime=%v for value set just now", mtime)
+	}
+	// value set to non-empty
+	fc.Set("foobar", []byte("ciao"))
+	data, _, ok = fc.GetWithMeta("foobar")
+	if !ok || !bytes.Equal(data, []byte("ciao")) {
+		log.Fatalf("GetWithMeta() of previously-set 'ciao' value returns ok=%v data='%v'", ok, data)
+	}
+}
+
 func TestGetPermissionDenied(t *testing.T) {
 	fpath, derr := os.MkdirTemp("", "test.*")
 	if derr != nil {
@@ -425,3 +501,642 @@ func TestClear(t *testing.T) {
 		t.Fatalf("Clear() failed to remove all items, left %v behind", nItemsLeft)
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	oldpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(oldpath)
+	newpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(newpath)
+
+	src, _ := GetInstance(oldpath)
+	src.Set("a", []byte("1"))
+	src.Set("b", []byte("2"))
+	src.Set("taken", []byte("old"))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path.Join(oldpath, "a"), mtime, mtime)
+	dst, _ := GetInstance(newpath)
+	dst.Set("taken", []byte("new"))
+
+	moved, err := Migrate(oldpath, newpath)
+	if moved != 2 {
+		t.Fatalf("Migrate() moved %v items; want 2", moved)
+	}
+	if err == nil {
+		t.Fatalf("Migrate() unexpectedly reported no error for item already existing at destination")
+	}
+	if v := dst.Get("a"); !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("Migrate() fails to move item 'a'; destination has '%v'", string(v))
+	}
+	if _, mt, _ := dst.GetWithMeta("a"); !mt.Equal(mtime) {
+		t.Fatalf("Migrate() fails to preserve modification time; want %v, have %v", mtime, mt)
+	}
+	if v := dst.Get("taken"); !bytes.Equal(v, []byte("new")) {
+		t.Fatalf("Migrate() overwrote existing item 'taken' with '%v'", string(v))
+	}
+	if keys, _ := src.List(); !slices.Equal(keys, []string{"taken"}) {
+		t.Fatalf("Migrate() left unexpected items at source: %v", keys)
+	}
+
+	if _, err := Migrate(newpath, newpath+"/"); err == nil {
+		t.Fatalf("Migrate() unexpectedly accepted migrating a cache onto itself")
+	}
+}
+
+func TestMigrateSharded(t *testing.T) {
+	oldpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(oldpath)
+	newpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(newpath)
+
+	// written by another process, so no shared instance knows oldpath is sharded
+	src, err := NewShardedWithStorage(DirStorage(oldpath), 2)
+	if err != nil {
+		t.Fatalf("NewShardedWithStorage() unexpectedly failed: %v", err)
+	}
+	keys := []string{"a", "b", "c", "d"}
+	for _, key := range keys {
+		src.Set(key, []byte(key))
+	}
+
+	moved, err := Migrate(oldpath, newpath)
+	if err != nil || moved != len(keys) {
+		t.Fatalf("Migrate() of sharded cache = %v, %v; want %v, nil", moved, err, len(keys))
+	}
+	if left, _ := src.List(); len(left) != 0 {
+		t.Fatalf("Migrate() of sharded cache left items %q at source", left)
+	}
+	dst, err := GetShardedInstance(newpath, 2)
+	if err != nil {
+		t.Fatalf("GetShardedInstance() of migrated cache unexpectedly failed: %v", err)
+	}
+	for _, key := range keys {
+		if v := dst.Get(key); string(v) != key {
+			t.Fatalf("Migrate() of sharded cache fails to move item '%v'; destination has '%v'", key, string(v))
+		}
+	}
+
+	other, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(other)
+	unsharded, _ := New(other)
+	unsharded.Set("e", []byte("e"))
+	os.Mkdir(path.Join(other, "abc"), 0700)
+	if _, err := Migrate(newpath, other); err == nil {
+		t.Fatalf("Migrate() unexpectedly merged caches of different sharding")
+	}
+}
+
+func TestCopyItem(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	from, to := path.Join(fpath, "from"), path.Join(fpath, "to")
+	os.WriteFile(from, []byte("content"), 0600)
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(from, mtime, mtime)
+	if err := copyItem(from, to); err != nil {
+		t.Fatalf("copyItem() unexpectedly failed: %v", err)
+	}
+	if data, _ := os.ReadFile(to); !bytes.Equal(data, []byte("content")) {
+		t.Fatalf("copyItem() produced wrong content '%v'", string(data))
+	}
+	if fstat, _ := os.Stat(to); !fstat.ModTime().Equal(mtime) {
+		t.Fatalf("copyItem() fails to preserve modification time; want %v, have %v", mtime, fstat.ModTime())
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 1 {
+		t.Fatalf("copyItem() left %v files; want only the copy", len(entries))
+	}
+}
+
+func TestGetShardedInstance(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	if _, err := GetShardedInstance(fpath, MaxShardLen+1); err == nil {
+		t.Fatalf("GetShardedInstance() unexpectedly accepted shardLen > MaxShardLen")
+	}
+	fc, err := GetShardedInstance(fpath, 2)
+	if err != nil {
+		t.Fatalf("GetShardedInstance() unexpectedly failed on valid path: %v", err)
+	}
+	if _, err := GetShardedInstance(fpath, 1); err == nil {
+		t.Fatalf("GetShardedInstance() unexpectedly accepted path already in use with different shardLen")
+	}
+	if again, _ := GetShardedInstance(fpath, 2); again != fc {
+		t.Fatalf("GetShardedInstance() returned a new instance for path already in use")
+	}
+
+	keys := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		key := fmt.Sprintf("item%v", i)
+		keys = append(keys, key)
+		if err := fc.Set(key, []byte(key)); err != nil {
+			t.Fatalf("Set(%v) unexpectedly failed: %v", key, err)
+		}
+	}
+	created, _ := fc.SetIfAbsent("claimed", []byte("x"))
+	if !created {
+		t.Fatalf("SetIfAbsent() failed to create item in sharded cache")
+	}
+	keys = append(keys, "claimed")
+
+	entries, _ := os.ReadDir(fpath)
+	for _, entry := range entries {
+		if !entry.IsDir() || len(entry.Name()) != 2 {
+			t.Fatalf("sharded cache stored unexpected entry '%v' at its top level", entry.Name())
+		}
+	}
+	if len(entries) < 2 {
+		t.Fatalf("sharded cache stored %v items into %v shard; want them distributed", len(keys), len(entries))
+	}
+
+	listed, err := fc.List()
+	if err != nil {
+		t.Fatalf("List() unexpectedly failed on sharded cache: %v", err)
+	}
+	slices.Sort(keys)
+	slices.Sort(listed)
+	if !slices.Equal(keys, listed) {
+		t.Fatalf("List() on sharded cache returned %v; want %v", listed, keys)
+	}
+	if fc.Len() != uint(len(keys)) {
+		t.Fatalf("Len() on sharded cache = %v; want %v", fc.Len(), len(keys))
+	}
+	if v := fc.Get("item7"); !bytes.Equal(v, []byte("item7")) {
+		t.Fatalf("Get() on sharded cache returned '%v'; want 'item7'", string(v))
+	}
+	if !fc.Unset("item7") || fc.Get("item7") != nil {
+		t.Fatalf("Unset() failed to remove item from sharded cache")
+	}
+	if err := fc.ClearExpired(0); err != nil || fc.Len() != 0 {
+		t.Fatalf("ClearExpired(0) left %v items in sharded cache (err=%v)", fc.Len(), err)
+	}
+}
+
+func TestSetWithMetadata(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	fc, _ := New(fpath)
+
+	meta := map[string]string{"method": "POST", "attempts": "2"}
+	if err := fc.SetWithMetadata("withmeta", []byte("value"), meta); err != nil {
+		t.Fatalf("SetWithMetadata() unexpectedly failed: %v", err)
+	}
+	if v := fc.Get("withmeta"); !bytes.Equal(v, []byte("value")) {
+		t.Fatalf("Get() of entry with metadata returned '%v'; want value alone", string(v))
+	}
+	if v, _, _ := fc.GetWithMeta("withmeta"); !bytes.Equal(v, []byte("value")) {
+		t.Fatalf("GetWithMeta() of entry with metadata returned '%v'; want value alone", string(v))
+	}
+	v, m, ok := fc.GetWithMetadata("withmeta")
+	if !ok || !bytes.Equal(v, []byte("value")) || m["method"] != "POST" || m["attempts"] != "2" {
+		t.Fatalf("GetWithMetadata() returned '%v', %v, %v; want 'value', %v, true", string(v), m, ok, meta)
+	}
+	if m, ok := fc.GetMeta("withmeta"); !ok || len(m) != 2 || m["method"] != "POST" {
+		t.Fatalf("GetMeta() returned %v, %v; want %v, true", m, ok, meta)
+	}
+
+	// legacy entries without header
+	fc.Set("plain", []byte("legacy"))
+	fc.Set("short", []byte("x"))
+	if v, m, ok := fc.GetWithMetadata("plain"); !ok || m != nil || !bytes.Equal(v, []byte("legacy")) {
+		t.Fatalf("GetWithMetadata() of plain entry returned '%v', %v, %v; want 'legacy', nil, true", string(v), m, ok)
+	}
+	if m, ok := fc.GetMeta("short"); !ok || m != nil {
+		t.Fatalf("GetMeta() of plain entry returned %v, %v; want nil, true", m, ok)
+	}
+	if m, ok := fc.GetMeta("missing"); ok || m != nil {
+		t.Fatalf("GetMeta() of missing entry returned %v, %v; want nil, false", m, ok)
+	}
+}
+
+func TestExists(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	fc, _ := New(fpath)
+
+	if fc.Exists("foo") {
+		t.Fatalf("Exists() unexpectedly reports missing key as present")
+	}
+	fc.Set("foo", []byte{})
+	if !fc.Exists("foo") {
+		t.Fatalf("Exists() fails to report empty key as present")
+	}
+	fc.Unset("foo")
+	if fc.Exists("foo") {
+		t.Fatalf("Exists() unexpectedly reports unset key as present")
+	}
+}
+
+func TestModTime(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	fc, _ := New(fpath)
+	clock := NewFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	fc.SetClock(clock)
+
+	if mtime, ok := fc.ModTime("foo"); ok || !mtime.IsZero() {
+		t.Fatalf("ModTime() of missing key = %v, %v; want zero time, false", mtime, ok)
+	}
+	fc.Set("foo", []byte("bar"))
+	if mtime, ok := fc.ModTime("foo"); !ok || !mtime.Equal(clock.Now()) {
+		t.Fatalf("ModTime() of key just set = %v, %v; want %v, true", mtime, ok, clock.Now())
+	}
+}
+
+func TestApproxLen(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	fc, _ := New(fpath)
+
+	fc.Set("a", []byte("1"))
+	fc.Set("b", []byte("2"))
+	if fc.ApproxLen() != 2 {
+		t.Fatalf("ApproxLen() upon first load = %v; want 2", fc.ApproxLen())
+	}
+	fc.Set("c", []byte("3"))
+	fc.Set("a", []byte("overwritten"))
+	fc.SetIfAbsent("d", []byte("4"))
+	fc.SetIfAbsent("d", []byte("4"))
+	if fc.ApproxLen() != 4 {
+		t.Fatalf("ApproxLen() after adding items = %v; want 4", fc.ApproxLen())
+	}
+	fc.Unset("a")
+	fc.Unset("missing")
+	if fc.ApproxLen() != 3 {
+		t.Fatalf("ApproxLen() after removing items = %v; want 3", fc.ApproxLen())
+	}
+	fc.ClearExpired(0)
+	if fc.ApproxLen() != 0 {
+		t.Fatalf("ApproxLen() after clearing expired items = %v; want 0", fc.ApproxLen())
+	}
+	fc.Set("e", []byte("5"))
+	fc.Clear()
+	if fc.ApproxLen() != 0 || fc.Len() != 0 {
+		t.Fatalf("ApproxLen() after Clear() = %v; want 0", fc.ApproxLen())
+	}
+
+	// changes made elsewhere are missed
+	os.WriteFile(path.Join(fpath, "external"), []byte{}, 0600)
+	if fc.ApproxLen() != 0 || fc.Len() != 1 {
+		t.Fatalf("ApproxLen() = %v and Len() = %v after external change; want 0 and 1", fc.ApproxLen(), fc.Len())
+	}
+}
+
+func TestNewWithStorage(t *testing.T) {
+	storage := NewMemStorage()
+	for _, shardLen := range []int{0, 2} {
+		var fc *FSCache
+		var err error
+		if shardLen == 0 {
+			fc, err = NewWithStorage(storage)
+		} else {
+			fc, err = NewShardedWithStorage(storage, shardLen)
+		}
+		if err != nil {
+			t.Fatalf("NewWithStorage() unexpectedly failed with shardLen=%v: %v", shardLen, err)
+		}
+		if entries, _ := storage.List("."); len(entries) != 0 {
+			t.Fatalf("NewWithStorage() left validation files behind: %v", entries)
+		}
+
+		fc.Set("a", []byte("1"))
+		fc.SetWithMetadata("b", []byte("2"), map[string]string{"k": "v"})
+		if created, err := fc.SetIfAbsent("a", []byte("x")); created || err != nil {
+			t.Fatalf("SetIfAbsent() on existing item returned %v, %v; want false, nil", created, err)
+		}
+		if v := fc.Get("a"); !bytes.Equal(v, []byte("1")) {
+			t.Fatalf("Get() with shardLen=%v returned '%v'; want '1'", shardLen, string(v))
+		}
+		if meta, ok := fc.GetMeta("b"); !ok || meta["k"] != "v" {
+			t.Fatalf("GetMeta() with shardLen=%v returned %v, %v; want metadata", shardLen, meta, ok)
+		}
+		if keys, err := fc.List(); err != nil || len(keys) != 2 || fc.Len() != 2 {
+			t.Fatalf("List() with shardLen=%v returned %v, %v; want 2 items", shardLen, keys, err)
+		}
+		if !fc.Unset("a") || fc.Exists("a") {
+			t.Fatalf("Unset() with shardLen=%v failed to remove item", shardLen)
+		}
+		if err := fc.Clear(); err != nil {
+			t.Fatalf("Clear() with shardLen=%v unexpectedly failed: %v", shardLen, err)
+		} else if entries, _ := storage.List("."); len(entries) != 0 {
+			t.Fatalf("Clear() with shardLen=%v left %v in storage", shardLen, entries)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	for _, shardLen := range []int{0, 2} {
+		fc, err := New(fpath)
+		if shardLen > 0 {
+			fc, err = NewShardedWithStorage(DirStorage(fpath), shardLen)
+		}
+		if err != nil {
+			t.Fatalf("Could not create cache with shardLen=%v: %v", shardLen, err)
+		}
+		fc.Set("key", []byte("value"))
+		if err := fc.Sync("key"); err != nil {
+			t.Fatalf("Sync() of item with shardLen=%v unexpectedly failed: %v", shardLen, err)
+		}
+		if err := fc.Sync("missing"); err == nil {
+			t.Fatalf("Sync() of missing item with shardLen=%v unexpectedly succeeded", shardLen)
+		}
+	}
+}
+
+func TestSetFileMode(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	fc, err := New(fpath)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed on valid path: %v", err)
+	}
+	for _, mode := range []fs.FileMode{0400, 0666, 0600 | fs.ModeSetuid, 0600 | fs.ModeDir} {
+		if err := fc.SetFileMode(mode); err == nil {
+			t.Fatalf("SetFileMode() unexpectedly accepted mode %v", mode)
+		}
+	}
+	if err := fc.SetFileMode(0640); err != nil {
+		t.Fatalf("SetFileMode(0640) unexpectedly failed: %v", err)
+	}
+	fc.Set("key", []byte("value"))
+	fc.SetIfAbsent("claim", []byte("value"))
+	for _, key := range []string{"key", "claim"} {
+		if fstat, err := os.Stat(path.Join(fpath, key)); err != nil {
+			t.Fatalf("Could not stat '%v': %v", key, err)
+		} else if fstat.Mode().Perm() != 0640 {
+			t.Fatalf("SetFileMode(0640) left '%v' with mode %v", key, fstat.Mode().Perm())
+		}
+	}
+
+	shardpath := path.Join(fpath, "sharded")
+	if err := os.Mkdir(shardpath, 0700); err != nil {
+		t.Fatalf("Could not create dir to test sharded cache: %v", err)
+	}
+	sharded, err := NewShardedWithStorage(DirStorage(shardpath), 2)
+	if err != nil {
+		t.Fatalf("NewShardedWithStorage() unexpectedly failed: %v", err)
+	}
+	if err := sharded.SetFileMode(0640); err != nil {
+		t.Fatalf("SetFileMode(0640) on sharded cache unexpectedly failed: %v", err)
+	}
+	sharded.Set("key", []byte("value"))
+	shard := path.Join(shardpath, sharded.shardOf("key"))
+	if fstat, err := os.Stat(shard); err != nil {
+		t.Fatalf("Could not stat shard directory: %v", err)
+	} else if fstat.Mode().Perm() != 0750 {
+		t.Fatalf("SetFileMode(0640) left shard directory with mode %v; want 0750", fstat.Mode().Perm())
+	}
+	if fstat, err := os.Stat(path.Join(shard, "key")); err != nil {
+		t.Fatalf("Could not stat sharded item: %v", err)
+	} else if fstat.Mode().Perm() != 0640 {
+		t.Fatalf("SetFileMode(0640) left sharded item with mode %v", fstat.Mode().Perm())
+	}
+
+	if fc, _ := NewWithStorage(NewMemStorage()); fc.SetFileMode(0640) == nil {
+		t.Fatalf("SetFileMode() unexpectedly accepted cache not in a local directory")
+	}
+}
+
+func TestListStream(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	dirCache, err := New(fpath)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed on valid path: %v", err)
+	}
+	memCache, err := NewShardedWithStorage(NewMemStorage(), 1)
+	if err != nil {
+		t.Fatalf("NewShardedWithStorage() unexpectedly failed: %v", err)
+	}
+	errStop := errors.New("stop")
+	for _, fc := range []*FSCache{dirCache, memCache} {
+		numItems := 2*dirStreamBatch + 3
+		for i := 0; i < numItems; i++ {
+			fc.Set(fmt.Sprintf("item%v", i), []byte("value"))
+		}
+		var names []string
+		if err := fc.ListStream(func(name string) error {
+			names = append(names, name)
+			return nil
+		}); err != nil {
+			t.Fatalf("ListStream() unexpectedly failed: %v", err)
+		}
+		listed, _ := fc.List()
+		slices.Sort(names)
+		slices.Sort(listed)
+		if !slices.Equal(names, listed) {
+			t.Fatalf("ListStream() streamed %v names, List() returned %v", len(names), len(listed))
+		}
+
+		visited := 0
+		err := fc.ListStream(func(name string) error {
+			visited++
+			if visited == 10 {
+				return errStop
+			}
+			return nil
+		})
+		if err != errStop || visited != 10 {
+			t.Fatalf("ListStream() with callback failing at 10th item returned err=%v after %v items", err, visited)
+		}
+	}
+}
+
+func TestUseBirthTime(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	if fc, _ := NewWithStorage(NewMemStorage()); fc.SetUseBirthTime(true) == nil {
+		t.Fatalf("SetUseBirthTime() unexpectedly accepted cache not in a local directory")
+	}
+
+	fc, err := New(fpath)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed on valid path: %v", err)
+	}
+	fc.Set("key", []byte("value"))
+	// touch the item as if modified long ago, while it was just created
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path.Join(fpath, "key"), old, old); err != nil {
+		t.Fatalf("Could not set times of item: %v", err)
+	}
+	if fc.GetExpiry("key", time.Hour) != nil {
+		t.Fatalf("GetExpiry() returned item modified before maxAge without UseBirthTime")
+	}
+
+	if err := fc.SetUseBirthTime(true); !birthTimeSupported {
+		if err == nil {
+			t.Fatalf("SetUseBirthTime() unexpectedly succeeded on platform without birth time")
+		}
+		if fc.GetExpiry("key", time.Hour) != nil {
+			t.Fatalf("GetExpiry() after failed SetUseBirthTime() did not keep modification time")
+		}
+		t.Skip("platform does not report birth time")
+	} else if err != nil {
+		t.Fatalf("SetUseBirthTime() unexpectedly failed: %v", err)
+	}
+	if fc.GetExpiry("key", time.Hour) == nil {
+		t.Fatalf("GetExpiry() with UseBirthTime expired item created within maxAge")
+	}
+	if err := fc.ClearExpired(time.Hour); err != nil || !fc.Exists("key") {
+		t.Fatalf("ClearExpired() with UseBirthTime cleared item created within age (err=%v)", err)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	fc, err := New(fpath)
+	if err != nil {
+		t.Fatalf("New() unexpectedly failed on valid path: %v", err)
+	}
+	clock := NewFakeClock(time.Now())
+	fc.SetClock(clock)
+	fc.Set("key", []byte("value"))
+	if fc.GetExpiry("key", time.Hour) == nil {
+		t.Fatalf("GetExpiry() expired item just set")
+	}
+
+	clock.Advance(2 * time.Hour)
+	if fc.GetExpiry("key", time.Hour) != nil {
+		t.Fatalf("GetExpiry() returned item older than maxAge per clock")
+	}
+	if err := fc.ClearExpired(time.Hour); err != nil || fc.Exists("key") {
+		t.Fatalf("ClearExpired() did not clear item older than age per clock (err=%v)", err)
+	}
+
+	// items set meanwhile are as old as the clock says
+	fc.Set("key", []byte("value"))
+	clock.Advance(30 * time.Minute)
+	if fc.GetExpiry("key", time.Hour) == nil {
+		t.Fatalf("GetExpiry() expired item set within maxAge per clock")
+	}
+	clock.Advance(time.Hour)
+	if fc.GetExpiry("key", time.Hour) != nil {
+		t.Fatalf("GetExpiry() returned item set before maxAge per clock")
+	}
+
+	fc.Set("key", []byte("value"))
+	fc.SetClock(nil)
+	if fc.GetExpiry("key", time.Hour) == nil {
+		t.Fatalf("GetExpiry() expired item just set after restoring SystemClock")
+	}
+}
+
+func TestClearExpiredKeys(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	fc, err := NewShardedWithStorage(DirStorage(fpath), 1)
+	if err != nil {
+		t.Fatalf("NewShardedWithStorage() unexpectedly failed: %v", err)
+	}
+	clock := NewFakeClock(time.Now())
+	fc.SetClock(clock)
+	expired := []string{"a_url", "b_url", "c_body"}
+	for _, key := range expired {
+		fc.Set(key, []byte("value"))
+	}
+	clock.Advance(2 * time.Hour)
+	fc.Set("d_url", []byte("value"))
+
+	cleared, err := fc.ClearExpiredKeys(time.Hour)
+	slices.Sort(cleared)
+	if err != nil || !slices.Equal(cleared, expired) {
+		t.Fatalf("ClearExpiredKeys() = %q, %v; want %q", cleared, err, expired)
+	}
+	if keys, _ := fc.List(); !slices.Equal(keys, []string{"d_url"}) {
+		t.Fatalf("ClearExpiredKeys() left items %q; want only the fresh one", keys)
+	}
+	if cleared, err := fc.ClearExpiredKeys(time.Hour); err != nil || len(cleared) != 0 {
+		t.Fatalf("ClearExpiredKeys() without expired items = %q, %v; want none", cleared, err)
+	}
+}
+
+func TestClearExpiredPrefix(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	fc, err := NewShardedWithStorage(DirStorage(fpath), 1)
+	if err != nil {
+		t.Fatalf("NewShardedWithStorage() unexpectedly failed: %v", err)
+	}
+	clock := NewFakeClock(time.Now())
+	fc.SetClock(clock)
+	for _, key := range []string{"dedup_a", "dedup_b", "a_url"} {
+		fc.Set(key, []byte("value"))
+	}
+	clock.Advance(2 * time.Hour)
+	fc.Set("dedup_c", []byte("value"))
+
+	cleared, err := fc.ClearExpiredPrefix("dedup_", time.Hour)
+	slices.Sort(cleared)
+	if want := []string{"dedup_a", "dedup_b"}; err != nil || !slices.Equal(cleared, want) {
+		t.Fatalf("ClearExpiredPrefix() = %q, %v; want %q", cleared, err, want)
+	}
+	keys, _ := fc.List()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a_url", "dedup_c"}) {
+		t.Fatalf("ClearExpiredPrefix() left items %q; want the fresh and unprefixed ones", keys)
+	}
+}
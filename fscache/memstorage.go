@@ -0,0 +1,141 @@
+package fscache
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStorage is a Storage keeping files in memory, e.g. for tests; files are lost along with it.
+type MemStorage struct {
+	mu sync.RWMutex
+	// files and directories by name; directories are created along with their first file
+	files map[string]*memFile
+}
+
+// content and attributes of a file or directory of MemStorage
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemStorage returns an empty MemStorage.
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: map[string]*memFile{}}
+}
+
+func (m *MemStorage) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, err := m.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	var data []byte
+	if file := m.files[name]; file != nil {
+		// files are replaced rather than changed, so the data is safe to read after unlocking
+		data = file.data
+	}
+	return &memOpenFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (m *MemStorage) Create(name string, data []byte, exclusive bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; ok && exclusive {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	now := time.Now()
+	if dir := path.Dir(name); dir != "." && m.files[dir] == nil {
+		m.files[dir] = &memFile{mode: fs.ModeDir | 0700, modTime: now}
+	}
+	m.files[name] = &memFile{data: bytes.Clone(data), mode: 0600, modTime: now}
+	return nil
+}
+
+func (m *MemStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	} else if file.mode.IsDir() && len(m.list(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemStorage) List(dir string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if info, err := m.stat(dir); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+	} else if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: errors.New("not a directory")}
+	}
+	return m.list(dir), nil
+}
+
+func (m *MemStorage) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, err := m.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// describe a file or directory; the root always exists
+func (m *MemStorage) stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return memFileInfo{name: ".", file: &memFile{mode: fs.ModeDir | 0700}}, nil
+	}
+	file, ok := m.files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return memFileInfo{name: path.Base(name), file: file}, nil
+}
+
+// entries of a directory, sorted by name
+func (m *MemStorage) list(dir string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for name, file := range m.files {
+		if path.Dir(name) == dir && name != "." {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: path.Base(name), file: file}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// fs.FileInfo of a file or directory of MemStorage
+type memFileInfo struct {
+	name string
+	file *memFile
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i memFileInfo) Mode() fs.FileMode  { return i.file.mode }
+func (i memFileInfo) ModTime() time.Time { return i.file.modTime }
+func (i memFileInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return nil }
+
+// file of MemStorage opened for reading
+type memOpenFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
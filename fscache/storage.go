@@ -0,0 +1,202 @@
+package fscache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync/atomic"
+)
+
+/*
+Storage is where a cache keeps its items, as files named by slash-separated paths relative to the storage root, like
+io/fs. Items are kept at the root, or in one level of subdirectories if the cache is sharded.
+
+DirStorage keeps files in a local directory, and is used by New and GetInstance; MemStorage keeps them in memory. Other
+implementations let a cache keep its items elsewhere, e.g. on an object store; see NewWithStorage.
+*/
+type Storage interface {
+	// Open opens a file for reading.
+	Open(name string) (fs.File, error)
+	// Create stores a file with the given content, replacing any existing file atomically, so readers see either content
+	// in full. If exclusive is set, Create instead fails with an error wrapping fs.ErrExist if the file exists already.
+	// The parent directory of the file is created if missing, but the root is not.
+	Create(name string, data []byte, exclusive bool) error
+	// Remove removes a file, or an empty directory.
+	Remove(name string) error
+	// List returns the entries of a directory; "." is the root.
+	List(dir string) ([]fs.DirEntry, error)
+	// Stat describes a file.
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// DirStreamer is implemented by storages which can read directories piecemeal, for FSCache.ListStream to not hold all
+// entries of a directory at once.
+type DirStreamer interface {
+	// ListFunc calls fn with each entry of a directory, in no particular order, stopping at the first error fn returns
+	// and returning it.
+	ListFunc(dir string, fn func(fs.DirEntry) error) error
+}
+
+// Syncer is implemented by storages which buffer writes, for FSCache.Sync to flush files to stable storage on demand.
+type Syncer interface {
+	// Sync returns once a file, and its entry in its directory, are on stable storage.
+	Sync(name string) error
+}
+
+// number of directory entries read at once by dirStorage.ListFunc
+const dirStreamBatch = 256
+
+// dirStorage keeps files in a directory of the local filesystem
+type dirStorage struct {
+	root string
+	// permissions of files created, if set by FSCache.SetFileMode; 0 for the default 0600
+	mode atomic.Uint32
+}
+
+// DirStorage returns a Storage keeping files in the local directory root, which must exist.
+func DirStorage(root string) Storage {
+	return &dirStorage{root: root}
+}
+
+func (d *dirStorage) path(name string) string {
+	return path.Join(d.root, name)
+}
+
+func (d *dirStorage) Open(name string) (fs.File, error) {
+	return os.Open(d.path(name))
+}
+
+func (d *dirStorage) Create(name string, data []byte, exclusive bool) error {
+	p := d.path(name)
+	mode := fs.FileMode(d.mode.Load())
+	if dir := path.Dir(name); dir != "." {
+		if err := os.Mkdir(path.Dir(p), 0700); err == nil && mode != 0 {
+			// let whoever may read files traverse their directory, regardless of umask
+			if err := os.Chmod(path.Dir(p), 0700|mode|(mode&0044)>>2); err != nil {
+				return fmt.Errorf("failed to set permissions of directory for '%v': %w", name, err)
+			}
+		} else if err != nil && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("failed to create directory for '%v': %w", name, err)
+		}
+	}
+	if exclusive {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, werr := f.Write(data)
+		if werr == nil && mode != 0 {
+			werr = f.Chmod(mode)
+		}
+		if werr != nil {
+			os.Remove(p)
+			return werr
+		}
+		return nil
+	}
+	// write into a tempfile then rename it, so readers never see partial content
+	f, err := os.CreateTemp(path.Dir(p), path.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(data)
+	if werr == nil && mode != 0 {
+		// before renaming, so the file never shows with other permissions
+		werr = f.Chmod(mode)
+	}
+	cerr := f.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(f.Name())
+		return errors.Join(werr, cerr)
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (d *dirStorage) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d *dirStorage) List(dir string) ([]fs.DirEntry, error) {
+	return os.ReadDir(d.path(dir))
+}
+
+func (d *dirStorage) ListFunc(dir string, fn func(fs.DirEntry) error) error {
+	f, err := os.Open(d.path(dir))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		entries, err := f.ReadDir(dirStreamBatch)
+		for _, entry := range entries {
+			if ferr := fn(entry); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// call fn with each entry of a directory of storage, piecemeal if storage is a DirStreamer
+func listFunc(storage Storage, dir string, fn func(fs.DirEntry) error) error {
+	if streamer, ok := storage.(DirStreamer); ok {
+		return streamer.ListFunc(dir, fn)
+	}
+	entries, err := storage.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dirStorage) Sync(name string) error {
+	p := d.path(name)
+	// the file's content, its entry in its directory, and that of its shard directory if any
+	paths := []string{p, path.Dir(p)}
+	if path.Dir(name) != "." {
+		paths = append(paths, d.root)
+	}
+	for _, fpath := range paths {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to flush '%v': %w", fpath, err)
+		}
+	}
+	return nil
+}
+
+func (d *dirStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+// read a whole file from storage
+func readFile(storage Storage, name string) ([]byte, error) {
+	f, err := storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
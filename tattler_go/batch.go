@@ -0,0 +1,281 @@
+package tattler_go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// BatchItem describes one notification to deliver with SendBatch.
+type BatchItem struct {
+	Recipient     string
+	EventName     string
+	Params        map[string]string
+	Vectors       []string
+	CorrelationId string
+}
+
+// Path, relative to Endpoint, where Tattler server accepts batches of notifications
+const bulkPath string = "bulk"
+
+// bulkDescriptor describes one notification of a bulk request: the scope and event otherwise in the URL path, and the
+// query params and body SendNotification would send.
+type bulkDescriptor struct {
+	Scope  string            `json:"scope"`
+	Event  string            `json:"event"`
+	Query  map[string]string `json:"query"`
+	Params json.RawMessage   `json:"params"`
+}
+
+// bulkResult is the outcome of one notification of a bulk request, as Tattler server reports it.
+type bulkResult struct {
+	// HTTP status code the notification would have been answered with if sent alone
+	StatusCode int `json:"statusCode"`
+	// Response the notification would have been answered with if sent alone
+	Body json.RawMessage `json:"body"`
+}
+
+// notification of a batch, prepared for delivery
+type batchEntry struct {
+	index   int
+	urlstr  string
+	body    []byte
+	task    string
+	vectors []string
+}
+
+/*
+Send a batch of notifications.
+
+Items are sent in a single request to Tattler server's bulk endpoint at `{Endpoint}/bulk`: a JSON array with one
+descriptor per item, answered by a JSON array with the outcome of each item, in the same order. Each item is journaled
+and cleared like by SendNotification. If the server lacks the bulk endpoint (404 or 405), items are delivered one by one
+instead. Batches are sent one by one altogether while paused, or with QuietHours or DedupWindow, which hold back
+notifications individually.
+
+Results are aligned to items; failed items carry their error in NotificationResult.Err.
+
+Returns a non-nil error joining the errors of all failed items, if any failed.
+*/
+func (n *TattlerClientHTTP) SendBatch(items []BatchItem) ([]NotificationResult, error) {
+	if n.IsPaused() || n.QuietHours != nil || n.DedupWindow > 0 {
+		return n.sendBatchSequentially(items)
+	}
+	results := make([]NotificationResult, len(items))
+	errs := make([]error, len(items))
+	if n.DrainBeforeSend {
+		n.drainJournal()
+	}
+	var entries []batchEntry
+	for i, item := range items {
+		urlstr, body, taskname, err := n.prepareNotification(item.Recipient, item.EventName, item.Params, item.Vectors, item.CorrelationId, SendOptions{}, true)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to prepare tattler request: %w", err)
+			continue
+		}
+		entries = append(entries, batchEntry{index: i, urlstr: urlstr, body: body, task: taskname, vectors: item.Vectors})
+	}
+	if len(entries) > 0 {
+		n.deliverBatch(context.Background(), entries, results, errs)
+	}
+	return joinBatchErrors(results, errs)
+}
+
+// send a batch of notifications one by one
+func (n *TattlerClientHTTP) sendBatchSequentially(items []BatchItem) ([]NotificationResult, error) {
+	results := make([]NotificationResult, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		results[i], errs[i] = n.sendNotification(context.Background(), item.Recipient, item.EventName, item.Params, item.Vectors, item.CorrelationId, SendOptions{})
+	}
+	return joinBatchErrors(results, errs)
+}
+
+// record errs into results, and join them naming the items they belong to
+func joinBatchErrors(results []NotificationResult, errs []error) ([]NotificationResult, error) {
+	var joined []error
+	for i, err := range errs {
+		if err != nil {
+			results[i].Err = err
+			joined = append(joined, fmt.Errorf("batch item %v: %w", i, err))
+		}
+	}
+	return results, errors.Join(joined...)
+}
+
+// deliver prepared notifications in one bulk request, or one by one if the server lacks the bulk endpoint, recording
+// their outcomes into results and errs by index, and releasing their claims
+func (n *TattlerClientHTTP) deliverBatch(ctx context.Context, entries []batchEntry, results []NotificationResult, errs []error) {
+	defer func() {
+		for _, entry := range entries {
+			n.releaseClaim(entry.task)
+		}
+	}()
+	bulkurl := fmt.Sprintf("%v/%v", n.Endpoint, bulkPath)
+	descriptors := make([]bulkDescriptor, len(entries))
+	for i, entry := range entries {
+		descriptors[i] = n.bulkDescriptorOf(entry)
+	}
+	// cannot fail, because descriptors are always convertible
+	reqbody, _ := json.Marshal(descriptors)
+	request, client := n.prepareHTTPRequest(bulkurl, reqbody)
+	resp, respbody, elapsed, resperr := n.roundTrip(ctx, request, client)
+	if resperr == nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+		golog.Infof("Tattler %v lacks the bulk endpoint; sending %v notifications one by one", n.Endpoint, len(entries))
+		for _, entry := range entries {
+			result, err := n.deliver(ctx, entry.urlstr, entry.body, entry.task, true)
+			n.settleBatchEntry(entry, result, err, results, errs)
+		}
+		return
+	}
+	var bulkerr error
+	var outcomes []bulkResult
+	if resperr != nil {
+		bulkerr = requestError(bulkurl, resperr)
+	} else if autherr := authErrorFor(bulkurl, resp.StatusCode, resp.Status, resp.Header); autherr != nil {
+		bulkerr = autherr
+	} else if resp.StatusCode != http.StatusOK {
+		bulkerr = &ServerError{URL: bulkurl, StatusCode: resp.StatusCode, Status: resp.Status, Body: respbody, TaskKept: n.persists(), Problem: n.problemFor(resp.Header, respbody)}
+	} else if err := json.Unmarshal(respbody, &outcomes); err != nil || len(outcomes) != len(entries) {
+		bulkerr = &InvalidResponseError{URL: bulkurl, StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Reason: fmt.Sprintf("want a JSON list of %v results", len(entries))}
+	}
+	n.recordHealth(bulkerr)
+	if bulkerr != nil {
+		for _, entry := range entries {
+			result := NotificationResult{Outcome: OutcomeNotSent}
+			if resp != nil {
+				result.StatusCode, result.Body = resp.StatusCode, respbody
+			}
+			if entry.task != "" {
+				result.Outcome = OutcomePersisted
+			}
+			n.settleBatchEntry(entry, result, bulkerr, results, errs)
+		}
+		return
+	}
+	if err := n.checkServerSchema(bulkurl, resp.Header); err != nil {
+		golog.Warnf("%v", err)
+	}
+	golog.Infof("Batch -> %v sent (%v notifications, %v bytes in %v): %v", bulkurl, len(entries), len(reqbody), elapsed.Round(time.Millisecond), resp.StatusCode)
+	// items share the response's headers, except for those describing the response as a whole
+	itemHeader := http.Header{"Content-Type": resp.Header.Values("Content-Type")}
+	for i, entry := range entries {
+		outcome := outcomes[i]
+		result := NotificationResult{Outcome: OutcomeSent, StatusCode: outcome.StatusCode, Body: outcome.Body}
+		err := n.processResponse(outcome.StatusCode, http.StatusText(outcome.StatusCode), itemHeader, entry.urlstr, outcome.Body, entry.task, false)
+		if err != nil && entry.task != "" {
+			result.Outcome = OutcomePersisted
+		}
+		if err == nil {
+			result.CorrelationId = deliveredCorrelationId(n.sentCorrelationId(entry.urlstr, entry.body), outcome.Body)
+			result.DeliveryIds = deliveryIds(outcome.Body)
+			n.archiveSent(entry.urlstr, entry.body, result.CorrelationId)
+		}
+		n.settleBatchEntry(entry, result, err, results, errs)
+	}
+}
+
+// record the outcome of delivering one notification of a batch
+func (n *TattlerClientHTTP) settleBatchEntry(entry batchEntry, result NotificationResult, err error, results []NotificationResult, errs []error) {
+	_, result.DroppedVectors = n.CheckVectors(entry.vectors)
+	if result.CorrelationId == "" {
+		// not delivered, but traceable by the id it was sent with
+		result.CorrelationId = n.sentCorrelationId(entry.urlstr, entry.body)
+	}
+	results[entry.index], errs[entry.index] = result, err
+}
+
+// describe a prepared notification for a bulk request
+func (n *TattlerClientHTTP) bulkDescriptorOf(entry batchEntry) bulkDescriptor {
+	// cannot fail, because urlstr was built by buildRequest
+	requrl, _ := url.Parse(entry.urlstr)
+	query := map[string]string{}
+	for k := range requrl.Query() {
+		query[k] = requrl.Query().Get(k)
+	}
+	pathParts := strings.Split(strings.Trim(requrl.Path, "/"), "/")
+	return bulkDescriptor{Scope: n.Scope, Event: pathParts[len(pathParts)-1], Query: query, Params: entry.body}
+}
+
+// RecipientResult describes the outcome of delivering a notification to one of several recipients.
+type RecipientResult struct {
+	Recipient     string
+	CorrelationId string
+	// Reason why delivery failed; nil upon success
+	Err error
+	// Whether sending was not attempted because the context was done; the notification is journaled if PersistencyDir is set
+	Skipped bool
+	Result  NotificationResult
+}
+
+/*
+Send the same notification to several recipients.
+
+If correlationId is empty, a distinct one is generated for each recipient; otherwise all recipients share it.
+Results are aligned to recipients, and report the correlationId used for each.
+
+Returns a non-nil error joining the errors of all failed recipients, if any failed.
+*/
+func (n *TattlerClientHTTP) SendNotificationMulti(recipients []string, event_name string, params map[string]string, vectors []string, correlationId string) ([]RecipientResult, error) {
+	return n.SendNotificationMultiContext(context.Background(), recipients, event_name, params, vectors, correlationId)
+}
+
+/*
+SendNotificationMultiContext is like SendNotificationMulti, but bounds the whole send by ctx.
+
+Once ctx is done, the send in flight is abandoned, and no further recipient is sent to: their notifications are
+journaled for replay instead, and their results are marked Skipped.
+*/
+func (n *TattlerClientHTTP) SendNotificationMultiContext(ctx context.Context, recipients []string, event_name string, params map[string]string, vectors []string, correlationId string) ([]RecipientResult, error) {
+	results := make([]RecipientResult, len(recipients))
+	var errs []error
+	correlationId = strings.TrimSpace(correlationId)
+	for i, recipient := range recipients {
+		corrid := correlationId
+		var result NotificationResult
+		var err error
+		if corrid == "" {
+			corrid, err = newCorrelationId()
+		}
+		skipped := ctx.Err() != nil
+		if err != nil {
+			// no id to send with
+		} else if skipped {
+			result, err = n.skipNotification(ctx, recipient, event_name, params, vectors, corrid)
+		} else {
+			result, err = n.sendNotification(ctx, recipient, event_name, params, vectors, corrid, SendOptions{})
+		}
+		if err != nil {
+			result.Err = err
+			errs = append(errs, fmt.Errorf("recipient '%v' (correlationId %v): %w", recipient, corrid, err))
+		}
+		results[i] = RecipientResult{
+			Recipient:     recipient,
+			CorrelationId: corrid,
+			Err:           err,
+			Skipped:       skipped,
+			Result:        result,
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// journal a notification without sending it, because ctx is done
+func (n *TattlerClientHTTP) skipNotification(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (NotificationResult, error) {
+	_, _, taskname, err := n.prepareNotification(recipient, event_name, params, vectors, correlationId, SendOptions{}, false)
+	if err != nil {
+		return NotificationResult{}, fmt.Errorf("failed to prepare tattler request: %w", err)
+	}
+	result := NotificationResult{Outcome: OutcomeNotSent}
+	if taskname != "" {
+		result.Outcome = OutcomePersisted
+	}
+	return result, fmt.Errorf("skipped sending: %w", ctx.Err())
+}
@@ -0,0 +1,243 @@
+package tattler_go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendBatch(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var requests []string
+	var descriptors []bulkDescriptor
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/bulk" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&descriptors)
+		var outcomes []bulkResult
+		for _, d := range descriptors {
+			if d.Query["user"] == "bad" {
+				outcomes = append(outcomes, bulkResult{StatusCode: http.StatusBadRequest, Body: json.RawMessage(`{"detail":"bad user"}`)})
+				continue
+			}
+			outcomes = append(outcomes, bulkResult{StatusCode: http.StatusOK, Body: json.RawMessage(`[{"id":"email:49b99061-f5bc-4d58-9f79-fce37106877f","vector":"email"}]`)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(outcomes)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "myscope",
+		PersistencyDir: fpath,
+	}
+	items := []BatchItem{
+		{Recipient: "456", EventName: "ev1", Params: map[string]string{"name": "Ann"}},
+		{Recipient: "bad", EventName: "ev2"},
+		{Recipient: "789", EventName: "ev3", Vectors: []string{"email"}, CorrelationId: "abc"},
+	}
+	results, err := n.SendBatch(items)
+	if err == nil || !strings.Contains(err.Error(), "batch item 1") {
+		t.Fatalf("SendBatch() returned '%v'; want error naming the failed item", err)
+	}
+	if len(requests) != 1 || requests[0] != "/bulk" {
+		t.Fatalf("SendBatch() requested %v; want a single request to the bulk endpoint", requests)
+	}
+	if len(descriptors) != 3 || descriptors[0].Scope != "myscope" || descriptors[0].Event != "ev1" || descriptors[0].Query["user"] != "456" ||
+		string(descriptors[0].Params) != `{"name":"Ann"}` || descriptors[2].Query["vector"] != "email" || descriptors[2].Query["correlationId"] != "abc" {
+		t.Fatalf("SendBatch() sent descriptors %+v; want the items' scope, event, query params and params", descriptors)
+	}
+	if len(results) != len(items) {
+		t.Fatalf("SendBatch() returned %v results for %v items", len(results), len(items))
+	}
+	for i, want_ok := range []bool{true, false, true} {
+		if (results[i].Err == nil) != want_ok {
+			t.Fatalf("SendBatch() result %v has Err=%v, expected success=%v", i, results[i].Err, want_ok)
+		}
+	}
+	if results[1].StatusCode != http.StatusBadRequest || results[1].Outcome != OutcomePersisted {
+		t.Fatalf("SendBatch() result of failed item = %v, %v; want %v and persisted", results[1].StatusCode, results[1].Outcome, http.StatusBadRequest)
+	}
+	if results[2].CorrelationId != "abc" || results[2].DeliveryIds["email"] == "" || results[2].Outcome != OutcomeSent {
+		t.Fatalf("SendBatch() result of delivered item = %+v; want its correlationId and delivery ids", results[2])
+	}
+	// only the failed item is left for replay
+	if entries, _ := os.ReadDir(fpath); len(entries) != 3 {
+		t.Fatalf("SendBatch() left %v files in PersistencyDir; want the failed item's 3 parts", len(entries))
+	}
+}
+
+func TestSendBatchFallback(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path == "/bulk" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("user") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"id":"email:49b99061-f5bc-4d58-9f79-fce37106877f","vector":"email","resultCode":0,"result":"success","detail":"OK"}`))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+	}
+	items := []BatchItem{
+		{Recipient: "456", EventName: "ev1"},
+		{Recipient: "bad", EventName: "ev2"},
+		{Recipient: "789", EventName: "ev3", Vectors: []string{"email"}},
+	}
+	results, err := n.SendBatch(items)
+	if err == nil {
+		t.Fatalf("SendBatch() failed to return error when one item failed")
+	}
+	want := []string{"/bulk", "/notification/myscope/ev1/", "/notification/myscope/ev2/", "/notification/myscope/ev3/"}
+	if !slices.Equal(requests, want) {
+		t.Fatalf("SendBatch() to server lacking bulk endpoint requested %v; want %v", requests, want)
+	}
+	for i, want_ok := range []bool{true, false, true} {
+		if (results[i].Err == nil) != want_ok {
+			t.Fatalf("SendBatch() result %v has Err=%v, expected success=%v", i, results[i].Err, want_ok)
+		}
+	}
+	if results[1].StatusCode != http.StatusBadRequest {
+		t.Fatalf("SendBatch() result has StatusCode=%v, expected %v", results[1].StatusCode, http.StatusBadRequest)
+	}
+	if len(results[0].Body) == 0 {
+		t.Fatalf("SendBatch() result lacks server response body")
+	}
+}
+
+func TestSendNotificationMulti(t *testing.T) {
+	received := map[string]string{}
+	var mux sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qrparams, _ := url.ParseQuery(r.URL.RawQuery)
+		mux.Lock()
+		received[qrparams.Get("user")] = qrparams.Get("correlationId")
+		mux.Unlock()
+		if qrparams.Get("user") == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+	}
+	recipients := []string{"456", "bad", "789"}
+	results, err := n.SendNotificationMulti(recipients, "ev", map[string]string{}, []string{}, "")
+	if err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Fatalf("SendNotificationMulti() failed to return aggregate error naming failed recipient (err=%v)", err)
+	}
+	if len(results) != len(recipients) {
+		t.Fatalf("SendNotificationMulti() returned %v results for %v recipients", len(results), len(recipients))
+	}
+	for i, res := range results {
+		if res.Recipient != recipients[i] {
+			t.Fatalf("SendNotificationMulti() result %v is for '%v' instead of '%v'", i, res.Recipient, recipients[i])
+		}
+		if res.CorrelationId == "" || res.CorrelationId != received[res.Recipient] {
+			t.Fatalf("SendNotificationMulti() result for '%v' reports correlationId '%v' but server got '%v'", res.Recipient, res.CorrelationId, received[res.Recipient])
+		}
+		if (res.Err == nil) != (res.Recipient != "bad") {
+			t.Fatalf("SendNotificationMulti() result for '%v' has unexpected Err=%v", res.Recipient, res.Err)
+		}
+	}
+	if results[0].CorrelationId == results[2].CorrelationId {
+		t.Fatalf("SendNotificationMulti() reused generated correlationId '%v' across recipients", results[0].CorrelationId)
+	}
+
+	results, _ = n.SendNotificationMulti([]string{"456", "789"}, "ev", map[string]string{}, []string{}, "shared")
+	if results[0].CorrelationId != "shared" || results[1].CorrelationId != "shared" {
+		t.Fatalf("SendNotificationMulti() failed to use given correlationId for all recipients: %v", results)
+	}
+}
+
+func TestSendNotificationMultiContextDeadline(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var requested []string
+	var mux sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		mux.Lock()
+		requested = append(requested, user)
+		mux.Unlock()
+		if user == "slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "myscope",
+		PersistencyDir: fpath,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	recipients := []string{"fast", "slow", "later1", "later2"}
+	tstart := time.Now()
+	results, err := n.SendNotificationMultiContext(ctx, recipients, "ev", map[string]string{}, []string{}, "")
+	if time.Since(tstart) > 400*time.Millisecond {
+		t.Fatalf("SendNotificationMultiContext() took %v, exceeding its deadline", time.Since(tstart))
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendNotificationMultiContext() returned '%v'; want error wrapping context.DeadlineExceeded", err)
+	}
+	mux.Lock()
+	if strings.Join(requested, ",") != "fast,slow" {
+		t.Fatalf("SendNotificationMultiContext() requested %v; want only recipients before the deadline", requested)
+	}
+	mux.Unlock()
+
+	if results[0].Err != nil || results[0].Skipped || results[0].Result.Outcome != OutcomeSent {
+		t.Fatalf("SendNotificationMultiContext() result for recipient before deadline = %+v; want sent", results[0])
+	}
+	if results[1].Err == nil || results[1].Skipped || results[1].Result.Outcome != OutcomePersisted {
+		t.Fatalf("SendNotificationMultiContext() result for recipient in flight at deadline = %+v; want failed and persisted", results[1])
+	}
+	for _, res := range results[2:] {
+		if res.Err == nil || !res.Skipped || res.Result.Outcome != OutcomePersisted {
+			t.Fatalf("SendNotificationMultiContext() result for recipient after deadline = %+v; want skipped and persisted", res)
+		}
+	}
+
+	found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true)
+	if err != nil || found != 3 || sent != 3 {
+		t.Fatalf("ReplayOutstandingTasks() after deadline = found %v, sent %v, err %v; want 3, 3, nil", found, sent, err)
+	}
+}
@@ -0,0 +1,82 @@
+package tattler_go
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URL schemes accepted by ParseDSN, and the protocol each stands for
+var dsnSchemes = map[string]string{
+	"tattler":  "http",
+	"tattlers": "https",
+}
+
+/*
+ParseDSN builds a client from a connection string, and validates it like NewClient. For example:
+
+	tattler://mybillingsystem@localhost:11503/notification?mode=staging&timeout=10s&persist=/var/lib/tattler
+
+The scheme is "tattler" for HTTP, or "tattlers" for HTTPS. The user is the Scope. The last segment of the path, if any,
+is the NotificationPathSegment; preceding segments are kept in Endpoint. Query parameters set further attributes:
+- `mode` -- Mode
+- `timeout` -- Timeout, as a duration like "10s"
+- `connectTimeout` -- ConnectTimeout, as a duration like "2s"
+- `persist` -- PersistencyDir
+- `maxConcurrent` -- MaxConcurrent
+
+ParseDSN returns error for malformed strings, unknown schemes or query parameters, and invalid configurations.
+*/
+func ParseDSN(dsn string) (*TattlerClientHTTP, error) {
+	u, err := url.Parse(strings.TrimSpace(dsn))
+	if err != nil {
+		return nil, fmt.Errorf("malformed tattler DSN: %w", err)
+	}
+	proto, ok := dsnSchemes[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("tattler DSN has unknown scheme '%v'; want 'tattler' or 'tattlers'", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("tattler DSN lacks host")
+	}
+	var config TattlerClientHTTP
+	config.Scope = u.User.Username()
+	basePath := strings.Trim(u.Path, "/")
+	if basePath != "" {
+		if i := strings.LastIndex(basePath, "/"); i >= 0 {
+			config.NotificationPathSegment = basePath[i+1:]
+			basePath = basePath[:i]
+		} else {
+			config.NotificationPathSegment = basePath
+			basePath = ""
+		}
+	}
+	config.Endpoint = (&url.URL{Scheme: proto, Host: u.Host, Path: basePath}).String()
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "mode":
+			config.Mode = value
+		case "timeout":
+			if config.Timeout, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("tattler DSN has invalid timeout '%v': %w", value, err)
+			}
+		case "connectTimeout":
+			if config.ConnectTimeout, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("tattler DSN has invalid connectTimeout '%v': %w", value, err)
+			}
+		case "persist":
+			config.PersistencyDir = value
+		case "maxConcurrent":
+			if config.MaxConcurrent, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("tattler DSN has invalid maxConcurrent '%v': %w", value, err)
+			}
+		default:
+			return nil, fmt.Errorf("tattler DSN has unknown parameter '%v'", key)
+		}
+	}
+	return NewClient(config)
+}
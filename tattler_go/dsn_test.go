@@ -0,0 +1,46 @@
+package tattler_go
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	n, err := ParseDSN("tattler://mybillingsystem@localhost:11503/notification?mode=staging&timeout=10s&maxConcurrent=4")
+	if err != nil {
+		t.Fatalf("ParseDSN() unexpectedly failed on valid DSN: %v", err)
+	}
+	if n.Scope != "mybillingsystem" || n.Endpoint != "http://localhost:11503" || n.NotificationPathSegment != "notification" {
+		t.Fatalf("ParseDSN() returned wrong scope '%v', endpoint '%v' or path segment '%v'", n.Scope, n.Endpoint, n.NotificationPathSegment)
+	}
+	if n.Mode != "staging" || n.Timeout != 10*time.Second || n.MaxConcurrent != 4 {
+		t.Fatalf("ParseDSN() returned wrong mode '%v', timeout %v or maxConcurrent %v", n.Mode, n.Timeout, n.MaxConcurrent)
+	}
+
+	n, err = ParseDSN("tattlers://myscope@tattler.example.com/api/v2/notify")
+	if err != nil {
+		t.Fatalf("ParseDSN() unexpectedly failed on valid DSN: %v", err)
+	}
+	if n.Endpoint != "https://tattler.example.com/api/v2" || n.NotificationPathSegment != "notify" || n.Mode != DefaultMode {
+		t.Fatalf("ParseDSN() returned wrong endpoint '%v', path segment '%v' or mode '%v'", n.Endpoint, n.NotificationPathSegment, n.Mode)
+	}
+
+	n, err = ParseDSN("tattler://myscope@localhost:11503")
+	if err != nil || n.NotificationPathSegment != DefaultNotificationPathSegment {
+		t.Fatalf("ParseDSN() without path = path segment '%v', err %v; want default", n.NotificationPathSegment, err)
+	}
+
+	for _, dsn := range []string{
+		"http://myscope@localhost:11503/notification",
+		"tattler://myscope@localhost:11503/notification?timeout=10",
+		"tattler://myscope@localhost:11503/notification?retries=3",
+		"tattler://myscope@localhost:11503/notification?mode=unknown",
+		"tattler://localhost:11503/notification",
+		"tattler:///notification",
+		"tattler://myscope@local host:11503",
+	} {
+		if _, err := ParseDSN(dsn); err == nil {
+			t.Fatalf("ParseDSN() unexpectedly accepted invalid DSN '%v'", dsn)
+		}
+	}
+}
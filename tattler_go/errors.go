@@ -0,0 +1,252 @@
+package tattler_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ErrDeduplicated is returned when a notification is skipped because an identical one was delivered within DedupWindow.
+var ErrDeduplicated = errors.New("identical notification already delivered within DedupWindow")
+
+// ErrTaskNotFound is returned by ReplayTask when no persisted task has the given name, or it is incomplete.
+var ErrTaskNotFound = errors.New("persisted task not found")
+
+// ErrSuppressedQuietHours is wrapped by errors of notifications deferred or dropped because of QuietHours.
+var ErrSuppressedQuietHours = errors.New("notification suppressed during quiet hours")
+
+// ErrNotificationsPaused is wrapped by errors of sends and replays held back while notifications are paused; see
+// TattlerClientHTTP.SetPaused.
+var ErrNotificationsPaused = errors.New("notifications paused")
+
+// ErrRecipientNotAllowed is wrapped by errors of notifications in mode "debug" to recipients outside DebugRecipientAllowlist.
+var ErrRecipientNotAllowed = errors.New("recipient not in DebugRecipientAllowlist")
+
+// ErrNotArchived is returned by LookupSent when no delivery with the given correlationId was recorded.
+var ErrNotArchived = errors.New("no archived delivery")
+
+// ErrClientTimeout is wrapped by errors of requests abandoned because Timeout, or the caller's context deadline, expired
+// before Tattler server responded. A gateway timeout reported by the server (HTTP 504) is a ServerError instead.
+var ErrClientTimeout = errors.New("timed out waiting for tattler server")
+
+// returns an error describing a failed request to urlstr, wrapping ErrClientTimeout too if it timed out on the client side
+func requestError(urlstr string, err error) error {
+	var neterr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &neterr) && neterr.Timeout()) {
+		return fmt.Errorf("failed to request tattler %v: %w: %w", urlstr, ErrClientTimeout, err)
+	}
+	return fmt.Errorf("failed to request tattler %v: %w", urlstr, err)
+}
+
+// AuthError is returned when Tattler server refuses the client's credentials or permissions (HTTP 401 or 403).
+type AuthError struct {
+	// URL requested
+	URL string
+	// HTTP status code, either 401 or 403
+	StatusCode int
+	// HTTP status line, e.g. "401 Unauthorized"
+	Status string
+	// Value of the WWW-Authenticate header sent by the server, if any
+	WWWAuthenticate string
+}
+
+func (e *AuthError) Error() string {
+	if e.WWWAuthenticate != "" {
+		return fmt.Sprintf("tattler req '%v' refused with %v (WWW-Authenticate: %v)", e.URL, e.Status, e.WWWAuthenticate)
+	}
+	return fmt.Sprintf("tattler req '%v' refused with %v", e.URL, e.Status)
+}
+
+// returns an *AuthError if statusCode reports an authentication or authorization failure, else nil
+func authErrorFor(urlstr string, statusCode int, status string, header http.Header) error {
+	if statusCode != http.StatusUnauthorized && statusCode != http.StatusForbidden {
+		return nil
+	}
+	return &AuthError{
+		URL:             urlstr,
+		StatusCode:      statusCode,
+		Status:          status,
+		WWWAuthenticate: header.Get("WWW-Authenticate"),
+	}
+}
+
+// ErrEndpointNotFound is matched by errors of requests the server answered with HTTP 404 or 405, meaning Endpoint,
+// NotificationPathSegment or Scope are likely misconfigured. Notifications failing so at Endpoint are not kept for
+// replay; at one of FailoverEndpoints they are.
+var ErrEndpointNotFound = errors.New("tattler endpoint not found")
+
+// whether statusCode reports a notification path which the server does not serve
+func isEndpointNotFound(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed
+}
+
+// ServerError is returned when Tattler server responds to a request with a failure status, other than those reported by AuthError.
+type ServerError struct {
+	// URL requested
+	URL string
+	// HTTP status code
+	StatusCode int
+	// HTTP status line, e.g. "502 Bad Gateway"
+	Status string
+	// Body of the server's response
+	Body []byte
+	// Whether the notification's task was kept in PersistencyDir for replay
+	TaskKept bool
+	// Problem document in the server's response, if any and the client accepts them; see TattlerClientHTTP.Accept
+	Problem *Problem
+}
+
+func (e *ServerError) Error() string {
+	var extraPersistMsg string
+	if e.TaskKept {
+		extraPersistMsg = " (keeping persistent task)"
+	}
+	if e.Problem != nil {
+		return fmt.Sprintf("tattler req '%v' failed with %v: %v%v", e.URL, e.Status, e.Problem, extraPersistMsg)
+	}
+	return fmt.Sprintf("tattler req '%v' failed with %v%v", e.URL, e.Status, extraPersistMsg)
+}
+
+// Unwrap returns the problem document carried by the error, if any.
+func (e *ServerError) Unwrap() error {
+	if e.Problem == nil {
+		return nil
+	}
+	return e.Problem
+}
+
+// Is reports ServerErrors for HTTP 404 and 405 as ErrEndpointNotFound.
+func (e *ServerError) Is(target error) bool {
+	return target == ErrEndpointNotFound && isEndpointNotFound(e.StatusCode)
+}
+
+// SchemaMismatchError is returned by Ping when Tattler server announces a request schema other than the client's ClientSchema.
+// Sends do not fail for it, but log it as a warning.
+type SchemaMismatchError struct {
+	// URL requested
+	URL string
+	// Schema version announced by the client
+	ClientSchema string
+	// Schema version announced by the server
+	ServerSchema string
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("tattler at '%v' speaks request schema %v, but client speaks %v", e.URL, e.ServerSchema, e.ClientSchema)
+}
+
+// ErrCorrelationMismatch is matched by errors of responses echoing a correlationId other than the one sent; see
+// TattlerClientHTTP.VerifyCorrelationEcho.
+var ErrCorrelationMismatch = errors.New("response echoes another correlationId")
+
+// CorrelationMismatchError is returned when VerifyCorrelationEcho is set and Tattler server's response echoes a
+// correlationId other than the one sent, suggesting it answers another request. The notification's task is kept.
+type CorrelationMismatchError struct {
+	// URL requested
+	URL string
+	// correlationId sent with the request
+	Sent string
+	// correlationId echoed in the response's CorrelationIdHeader
+	Echoed string
+}
+
+func (e *CorrelationMismatchError) Error() string {
+	return fmt.Sprintf("tattler req '%v' sent with correlationId %v got response for %v", e.URL, e.Sent, e.Echoed)
+}
+
+// Is reports CorrelationMismatchErrors as ErrCorrelationMismatch.
+func (e *CorrelationMismatchError) Is(target error) bool {
+	return target == ErrCorrelationMismatch
+}
+
+// ErrInvalidResponse is matched by errors of responses which StrictResponseValidation rejects.
+var ErrInvalidResponse = errors.New("invalid tattler response")
+
+// InvalidResponseError is returned when StrictResponseValidation is set and a response deemed successful is not a JSON
+// result, suggesting it came from something other than Tattler server, e.g. a misconfigured proxy. The notification's
+// task is kept, and the request is failed over like upon connection failures.
+type InvalidResponseError struct {
+	// URL requested
+	URL string
+	// HTTP status code
+	StatusCode int
+	// Content-Type of the response
+	ContentType string
+	// What is wrong with the response
+	Reason string
+}
+
+func (e *InvalidResponseError) Error() string {
+	return fmt.Sprintf("tattler req '%v' got invalid response with status %v and Content-Type '%v': %v", e.URL, e.StatusCode, e.ContentType, e.Reason)
+}
+
+// Is reports InvalidResponseErrors as ErrInvalidResponse.
+func (e *InvalidResponseError) Is(target error) bool {
+	return target == ErrInvalidResponse
+}
+
+// RenderError is returned by SendSync when Tattler server fails to render a notification, e.g. for a broken template or
+// missing params, as opposed to failing to deliver it.
+type RenderError struct {
+	// Vector whose content failed to render; empty if the server rejected the notification as a whole (HTTP 422)
+	Vector string
+	// Explanation given by the server
+	Detail string
+	// Underlying *ServerError, if the server rejected the notification as a whole
+	Err error
+}
+
+func (e *RenderError) Error() string {
+	if e.Vector != "" {
+		return fmt.Sprintf("tattler failed to render notification for vector %v: %v", e.Vector, e.Detail)
+	}
+	return fmt.Sprintf("tattler failed to render notification: %v", e.Detail)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// Problem is an RFC 7807 problem document returned by Tattler server to describe a failure.
+type Problem struct {
+	// URI identifying the problem type; "about:blank" if omitted
+	Type string `json:"type"`
+	// Short summary of the problem type
+	Title string `json:"title"`
+	// HTTP status code, as stated by the server
+	Status int `json:"status"`
+	// Explanation specific to this occurrence of the problem
+	Detail string `json:"detail"`
+	// URI identifying this occurrence of the problem
+	Instance string `json:"instance"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return fmt.Sprintf("%v (%v)", p.Title, p.Detail)
+	}
+	return p.Title
+}
+
+// StatusCode returns the HTTP status code carried by err, or 0 if err did not originate from a server response (e.g. it's a connection failure).
+func StatusCode(err error) int {
+	var autherr *AuthError
+	if errors.As(err, &autherr) {
+		return autherr.StatusCode
+	}
+	var srverr *ServerError
+	if errors.As(err, &srverr) {
+		return srverr.StatusCode
+	}
+	return 0
+}
+
+// whether a failed delivery is worth attempting at FailoverEndpoints: the request failed in transport, or the server
+// failed with 5xx. Failures before sending, e.g. of RequestInterceptor, would fail alike there.
+func failsOver(err error) bool {
+	var urlerr *url.Error
+	return errors.As(err, &urlerr) || StatusCode(err) >= 500
+}
@@ -0,0 +1,97 @@
+package tattler_go
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+Event describes a notification event to send with Send, e.g. defined once and reused across the codebase:
+
+	invoice := NewEvent("new_invoice_created").Require("amount").Vector(VectorEmail)
+	result, err := client.Send(ctx, "123", invoice.Param("amount", "10.2"))
+
+Each method returns a copy of the Event with the change applied, so a shared definition is never modified by callers.
+*/
+type Event struct {
+	name          string
+	params        map[string]string
+	vectors       []string
+	correlationId string
+	required      []string
+}
+
+// NewEvent starts the definition of an event with the given name.
+func NewEvent(name string) *Event {
+	return &Event{name: name}
+}
+
+func (e *Event) clone() *Event {
+	c := *e
+	c.params = make(map[string]string, len(e.params))
+	for k, v := range e.params {
+		c.params[k] = v
+	}
+	c.vectors = append([]string(nil), e.vectors...)
+	c.required = append([]string(nil), e.required...)
+	return &c
+}
+
+// Name returns the name of the event.
+func (e *Event) Name() string {
+	return e.name
+}
+
+// Param sets a parameter of the event, overriding any earlier value.
+func (e *Event) Param(name string, value string) *Event {
+	c := e.clone()
+	c.params[name] = value
+	return c
+}
+
+// Vector adds a vector to deliver the event to. Events with no vectors are delivered to all vectors.
+func (e *Event) Vector(vname string) *Event {
+	c := e.clone()
+	c.vectors = append(c.vectors, vname)
+	return c
+}
+
+// CorrelationID sets the correlationId to send the event with; one is generated upon each send if none is set.
+func (e *Event) CorrelationID(correlationId string) *Event {
+	c := e.clone()
+	c.correlationId = correlationId
+	return c
+}
+
+// Require declares parameters that must be set before the event can be sent.
+func (e *Event) Require(names ...string) *Event {
+	c := e.clone()
+	c.required = append(c.required, names...)
+	return c
+}
+
+// Validate returns error if the event has no name, or lacks any parameter declared with Require.
+func (e *Event) Validate() error {
+	if strings.TrimSpace(e.name) == "" {
+		return fmt.Errorf("event has no name")
+	}
+	var missing []string
+	for _, pname := range e.required {
+		if _, ok := e.params[pname]; !ok {
+			missing = append(missing, pname)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("event %v lacks required params %q", e.name, missing)
+	}
+	return validateParamsEncoding(e.params)
+}
+
+// Send validates an event, and sends it to recipient like SendNotificationOptions.
+func (n *TattlerClientHTTP) Send(ctx context.Context, recipient string, event *Event) (NotificationResult, error) {
+	if err := event.Validate(); err != nil {
+		return NotificationResult{}, err
+	}
+	return n.sendNotification(ctx, recipient, event.name, event.params, event.vectors, event.correlationId, SendOptions{})
+}
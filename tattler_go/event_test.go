@@ -0,0 +1,43 @@
+package tattler_go
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvent(t *testing.T) {
+	invoice := NewEvent("new_invoice_created").Require("amount").Vector(VectorEmail)
+	if err := invoice.Validate(); err == nil {
+		t.Fatalf("Event.Validate() failed to return error when required param is missing")
+	}
+	filled := invoice.Param("amount", "10.2").CorrelationID("corr1")
+	if err := filled.Validate(); err != nil {
+		t.Fatalf("Event.Validate() unexpectedly failed with all required params: %v", err)
+	}
+	if len(invoice.params) != 0 {
+		t.Fatalf("Event.Param() modified the event it was called on: %v", invoice.params)
+	}
+	if err := NewEvent(" ").Validate(); err == nil {
+		t.Fatalf("Event.Validate() failed to return error for event without name")
+	}
+
+	var path, vector, correlationId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, vector, correlationId = r.URL.Path, r.URL.Query().Get("vector"), r.URL.Query().Get("correlationId")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "myscope"}
+	if _, err := n.Send(context.Background(), "123", invoice); err == nil || path != "" {
+		t.Fatalf("Send() of event lacking required params returned '%v' and requested '%v'; want error and no request", err, path)
+	}
+	if _, err := n.Send(context.Background(), "123", filled); err != nil {
+		t.Fatalf("Send() of valid event unexpectedly failed: %v", err)
+	}
+	if path != "/notification/myscope/new_invoice_created/" || vector != VectorEmail || correlationId != "corr1" {
+		t.Fatalf("Send() requested path '%v' vector '%v' correlationId '%v'", path, vector, correlationId)
+	}
+}
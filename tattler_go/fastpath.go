@@ -0,0 +1,73 @@
+package tattler_go
+
+import (
+	"net/url"
+	"strings"
+)
+
+// prefix of notification URLs, precomputed for a sealed client along with the settings it derives from
+type fastBase struct {
+	endpoint string
+	pathSeg  string
+	scope    string
+	inHeader bool
+	prefix   string
+}
+
+func (c *TattlerClientHTTP) newFastBase() fastBase {
+	return fastBase{
+		endpoint: c.Endpoint,
+		pathSeg:  c.NotificationPathSegment,
+		scope:    c.Scope,
+		inHeader: c.ScopeInHeader,
+		prefix:   c.notificationBase(),
+	}
+}
+
+// whether all of opts are defaults; SendOptions is not comparable, for its Labels
+func (opts *SendOptions) isDefault() bool {
+	return opts.DebugOverrideAddress == "" && !opts.SkipPersistency && opts.RecipientType == RecipientUserID &&
+		!opts.ServerCorrelationId && opts.TemplateOverride == nil && opts.Priority == PriorityNormal &&
+		opts.RecipientLocation == nil && opts.From == "" && opts.ReplyTo == "" && !opts.NotIdempotent &&
+		len(opts.Labels) == 0 && !opts.sync && !opts.durable
+}
+
+/*
+Build the URL of a plain notification with as few allocations as possible, or return false if the request is not plain.
+
+Plain requests are those of sealed clients without extra query parameters, canary, vector requirements, recipient
+allowlist or correlationId only in body, for no vectors and with default SendOptions; they carry only correlationId, mode
+and user. The result must equal mkGeneralRequestURL's, whose url.Values.Encode sorts keys, hence their order here.
+*/
+func (c *TattlerClientHTTP) fastRequestURL(recipient string, event_name string, vectors []string, correlationId string, opts SendOptions) (string, bool) {
+	if !c.sealed || len(vectors) > 0 || len(c.ExtraQueryParams) > 0 || len(c.ExtraQueryValues) > 0 || len(c.DebugRecipientAllowlist) > 0 || c.CorrelationIdPlacement == CorrelationIdInBody || c.CanaryFraction > 0 || c.RequireVectors || !opts.isDefault() {
+		return "", false
+	}
+	base := &c.fastBase
+	if base.endpoint != c.Endpoint || base.pathSeg != c.NotificationPathSegment || base.scope != c.Scope || base.inHeader != c.ScopeInHeader {
+		// changed since sealing
+		return "", false
+	}
+	correlationId = strings.TrimSpace(correlationId)
+	if correlationId == "" {
+		var err error
+		if correlationId, err = newCorrelationId(); err != nil {
+			// let mkGeneralRequestURL report it
+			return "", false
+		}
+	}
+	var b strings.Builder
+	b.Grow(len(base.prefix) + len(event_name) + len(correlationId) + len(c.Mode) + len(recipient) + 32)
+	b.WriteString(base.prefix)
+	b.WriteString(event_name)
+	if !c.NoTrailingSlash {
+		b.WriteByte('/')
+	}
+	b.WriteString("?correlationId=")
+	b.WriteString(url.QueryEscape(correlationId))
+	b.WriteString("&mode=")
+	b.WriteString(url.QueryEscape(c.Mode))
+	b.WriteString("&user=")
+	b.WriteString(url.QueryEscape(recipient))
+	return b.String(), true
+}
@@ -0,0 +1,62 @@
+package tattler_go
+
+import (
+	"testing"
+)
+
+func TestFastRequestURL(t *testing.T) {
+	for _, config := range []TattlerClientHTTP{
+		{Endpoint: api_base_test, Scope: "myscope"},
+		{Endpoint: api_base_test + "/", Scope: "myscope", Mode: "production", NotificationPathSegment: "notify", NoTrailingSlash: true},
+	} {
+		n, err := NewClient(config)
+		if err != nil {
+			t.Fatalf("NewClient() unexpectedly failed: %v", err)
+		}
+		for _, recipient := range []string{"636", "user name&x=1", "ü@example.com"} {
+			fast, ok := n.fastRequestURL(recipient, "ev", nil, " correl Id/1 ", SendOptions{})
+			if !ok {
+				t.Fatalf("fastRequestURL() declined plain request of sealed client")
+			}
+			general, err := n.mkGeneralRequestURL(recipient, "ev", nil, " correl Id/1 ", SendOptions{})
+			if err != nil || fast != general {
+				t.Fatalf("fastRequestURL() = '%v'; want '%v' (err=%v)", fast, general, err)
+			}
+		}
+	}
+
+	n, _ := NewClient(TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"})
+	if _, ok := n.fastRequestURL("636", "ev", []string{"email"}, "", SendOptions{}); ok {
+		t.Fatalf("fastRequestURL() unexpectedly handled request with vectors")
+	}
+	if _, ok := n.fastRequestURL("636", "ev", nil, "", SendOptions{RecipientType: RecipientEmailAddress}); ok {
+		t.Fatalf("fastRequestURL() unexpectedly handled request with SendOptions")
+	}
+	if _, ok := n.fastRequestURL("636", "ev", nil, "", SendOptions{Labels: map[string]string{"campaign": "x"}}); ok {
+		t.Fatalf("fastRequestURL() unexpectedly handled request with Labels")
+	}
+	n.Scope = "otherscope"
+	if _, ok := n.fastRequestURL("636", "ev", nil, "", SendOptions{}); ok {
+		t.Fatalf("fastRequestURL() unexpectedly handled request after Scope changed")
+	}
+	unsealed := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"}
+	if _, ok := unsealed.fastRequestURL("636", "ev", nil, "", SendOptions{}); ok {
+		t.Fatalf("fastRequestURL() unexpectedly handled request of unsealed client")
+	}
+}
+
+func BenchmarkRequestURL(b *testing.B) {
+	n, _ := NewClient(TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"})
+	b.Run("general", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			n.mkGeneralRequestURL("636", "ev", nil, "correlId", SendOptions{})
+		}
+	})
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			n.mkTattlerRequestURL("636", "ev", nil, "correlId", SendOptions{})
+		}
+	})
+}
@@ -1,6 +1,6 @@
 module tattler_go
 
-go 1.22
+go 1.24
 
 replace github.com/tattler-community/tattler-client-go/fscache => ../fscache
 
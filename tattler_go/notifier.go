@@ -0,0 +1,31 @@
+package tattler_go
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Notifier is the public surface of a Tattler client.
+
+Code sending notifications should depend on Notifier rather than on TattlerClientHTTP,
+so tests can swap in a fake instead of running an HTTP server.
+*/
+type Notifier interface {
+	// See TattlerClientHTTP.SendNotification
+	SendNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error
+	// See TattlerClientHTTP.SendNotificationContext
+	SendNotificationContext(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error
+	// See TattlerClientHTTP.SendNotificationAndWait
+	SendNotificationAndWait(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error
+	// See TattlerClientHTTP.SendNotificationOptions
+	SendNotificationOptions(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions) (NotificationResult, error)
+	// See TattlerClientHTTP.SendBatch
+	SendBatch(items []BatchItem) ([]NotificationResult, error)
+	// See TattlerClientHTTP.PrepareNotification
+	PrepareNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (string, []byte, string, error)
+	// See TattlerClientHTTP.ReplayOutstandingTasks
+	ReplayOutstandingTasks(maxAge time.Duration, removeDone bool) (uint, uint, uint, error)
+}
+
+var _ Notifier = (*TattlerClientHTTP)(nil)
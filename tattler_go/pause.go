@@ -0,0 +1,75 @@
+package tattler_go
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// key of the flag set by SetPaused in the persistency cache, for all processes sharing it to observe
+const pausedFlagKey = "paused"
+
+/*
+SetPaused pauses or resumes notifications, e.g. to stop all of them at once during an incident. While paused, sends fail
+with an error wrapping ErrNotificationsPaused without reaching Tattler server: their tasks are journaled for
+ReplayOutstandingTasks to deliver once resumed, or dropped without persistency or with SkipPersistency. Replays fail
+alike, and leave tasks in place.
+
+With persistency, the pause is a flag file in PersistencyDir or PersistencyStorage, so it applies to every client and
+process using it, and survives restarts. Otherwise, it applies to this client only. See also Paused.
+*/
+func (n *TattlerClientHTTP) SetPaused(paused bool) error {
+	if !n.persists() {
+		n.runtimeState().paused.Store(paused)
+		return nil
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return fmt.Errorf("failed to load cache to set pause flag: %w", err)
+	}
+	if paused {
+		if err := cache.Set(pausedFlagKey, []byte(n.now().UTC().Format(time.RFC3339))); err != nil {
+			return fmt.Errorf("failed to set pause flag: %w", err)
+		}
+		golog.Warn("Pausing notifications")
+		return nil
+	}
+	cache.Unset(pausedFlagKey)
+	if cache.Exists(pausedFlagKey) {
+		return fmt.Errorf("failed to remove pause flag")
+	}
+	golog.Info("Resuming notifications")
+	return nil
+}
+
+// IsPaused returns whether notifications are paused, by Paused or SetPaused.
+func (n *TattlerClientHTTP) IsPaused() bool {
+	if n.Paused {
+		return true
+	}
+	if !n.persists() {
+		return n.runtimeState().paused.Load()
+	}
+	cache, err := n.persistencyCache()
+	return err == nil && cache.Exists(pausedFlagKey)
+}
+
+// hold back a notification while paused, journaling it unless persistency is off or skipped
+func (n *TattlerClientHTTP) suppressPaused(recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions) (NotificationResult, error) {
+	if !n.persists() || opts.SkipPersistency {
+		golog.Infof("Dropping notification %v to %v while paused", event_name, recipient)
+		return NotificationResult{Outcome: OutcomeNotSent}, fmt.Errorf("notification %v to %v dropped: %w", event_name, recipient, ErrNotificationsPaused)
+	}
+	urlstr, body, err := n.buildRequest(recipient, event_name, params, vectors, correlationId, opts)
+	if err != nil {
+		return NotificationResult{}, fmt.Errorf("failed to prepare tattler request: %w", err)
+	}
+	meta := taskMeta{Method: notificationMethod, Header: n.requestHeader(), NotIdempotent: opts.NotIdempotent, CorrelationId: n.sentCorrelationId(urlstr, body)}
+	taskname, err := n.persistTask(urlstr, body, meta, false)
+	if err != nil {
+		return NotificationResult{}, fmt.Errorf("failed to journal notification %v to %v while paused: %w", event_name, recipient, err)
+	}
+	golog.Infof("Journaling notification %v to %v as task %v while paused", event_name, recipient, taskname)
+	return NotificationResult{Outcome: OutcomePersisted, CorrelationId: meta.CorrelationId}, fmt.Errorf("notification %v to %v journaled: %w", event_name, recipient, ErrNotificationsPaused)
+}
@@ -0,0 +1,69 @@
+package tattler_go
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetPaused(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var nreqs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreqs.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	// another process sharing PersistencyDir
+	other := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	if err := other.SetPaused(true); err != nil {
+		t.Fatalf("SetPaused(true) unexpectedly failed: %v", err)
+	}
+	if !n.IsPaused() {
+		t.Fatalf("IsPaused() does not observe pause flag set by another client on PersistencyDir")
+	}
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{Priority: PriorityHigh})
+	if !errors.Is(err, ErrNotificationsPaused) || result.Outcome != OutcomePersisted || nreqs.Load() != 0 {
+		t.Fatalf("SendNotificationOptions() while paused = %v, '%v' with %v requests; want journaled", result.Outcome, err, nreqs.Load())
+	}
+	result, err = n.SendNotificationOptions(context.Background(), "637", "ev", map[string]string{}, []string{}, "", SendOptions{SkipPersistency: true})
+	if !errors.Is(err, ErrNotificationsPaused) || result.Outcome != OutcomeNotSent || nreqs.Load() != 0 {
+		t.Fatalf("SendNotificationOptions() with SkipPersistency while paused = %v, '%v' with %v requests; want dropped", result.Outcome, err, nreqs.Load())
+	}
+	if _, err := n.ReplayOutstandingTasksOptions(ReplayOptions{}); !errors.Is(err, ErrNotificationsPaused) || nreqs.Load() != 0 {
+		t.Fatalf("ReplayOutstandingTasksOptions() while paused returned '%v' with %v requests; want ErrNotificationsPaused", err, nreqs.Load())
+	}
+
+	if err := other.SetPaused(false); err != nil {
+		t.Fatalf("SetPaused(false) unexpectedly failed: %v", err)
+	}
+	if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{}); err != nil || res.Replayed != 1 || nreqs.Load() != 1 {
+		t.Fatalf("ReplayOutstandingTasksOptions() after resuming = %+v, %v with %v requests; want task journaled while paused delivered", res, err, nreqs.Load())
+	}
+
+	n.Paused = true
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, ErrNotificationsPaused) || other.IsPaused() {
+		t.Fatalf("SendNotification() with Paused returned '%v'; want ErrNotificationsPaused for this client only", err)
+	}
+
+	volatile := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	volatile.SetPaused(true)
+	if err := volatile.SendNotification("636", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, ErrNotificationsPaused) || nreqs.Load() != 1 {
+		t.Fatalf("SendNotification() paused without persistency returned '%v' with %v requests; want dropped", err, nreqs.Load())
+	}
+	volatile.SetPaused(false)
+	if err := volatile.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil || nreqs.Load() != 2 {
+		t.Fatalf("SendNotification() resumed without persistency returned '%v' with %v requests; want sent", err, nreqs.Load())
+	}
+}
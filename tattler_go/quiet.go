@@ -0,0 +1,103 @@
+package tattler_go
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// Priority tells whether a notification is held back during QuietHours.
+type Priority int
+
+const (
+	// Held back during QuietHours
+	PriorityNormal Priority = iota
+	// Sent regardless of QuietHours
+	PriorityHigh
+)
+
+// QuietHoursAction tells what happens to notifications held back during QuietHours.
+type QuietHoursAction int
+
+const (
+	// Journal notifications into PersistencyDir, for ReplayOutstandingTasks to deliver once the window ends
+	QuietHoursDefer QuietHoursAction = iota
+	// Discard notifications
+	QuietHoursDrop
+)
+
+/*
+QuietHours is a daily window during which notifications of PriorityNormal are not sent, e.g. to not notify users at night.
+Such notifications fail with an error wrapping ErrSuppressedQuietHours, and are deferred or dropped per Action.
+
+Deferred tasks are left alone by ReplayOutstandingTasks until the window ends, so replays must run afterwards to deliver
+them, with a MaxTaskAge exceeding the length of the window. ReplayTask delivers them regardless.
+*/
+type QuietHours struct {
+	// Time of day the window starts at, as time since midnight
+	Start time.Duration
+	// Time of day the window ends at, as time since midnight; windows ending before they start span midnight
+	End time.Duration
+	// Timezone of Start and End, unless overridden by SendOptions.RecipientLocation; defaults to UTC
+	Location *time.Location
+	// What to do with notifications held back; defaults to QuietHoursDefer, which requires persistency
+	Action QuietHoursAction
+}
+
+func (q *QuietHours) validate() error {
+	const day = 24 * time.Hour
+	if q.Start < 0 || q.Start >= day || q.End < 0 || q.End >= day {
+		return fmt.Errorf("invalid QuietHours from %v to %v; want times of day between 0 and 24h", q.Start, q.End)
+	} else if q.Start == q.End {
+		return fmt.Errorf("invalid QuietHours from %v to %v; want a non-empty window", q.Start, q.End)
+	} else if q.Action < QuietHoursDefer || q.Action > QuietHoursDrop {
+		return fmt.Errorf("invalid QuietHours Action=%v", q.Action)
+	}
+	return nil
+}
+
+// end of the window t falls in, in timezone loc, or false if t falls outside the window
+func (q *QuietHours) until(t time.Time, loc *time.Location) (time.Time, bool) {
+	if loc == nil {
+		loc = q.Location
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	year, month, day := t.Date()
+	start, end := timeOfDay(year, month, day, q.Start, loc), timeOfDay(year, month, day, q.End, loc)
+	if q.Start < q.End {
+		return end, !t.Before(start) && t.Before(end)
+	}
+	// window spanning midnight
+	if !t.Before(start) {
+		return timeOfDay(year, month, day+1, q.End, loc), true
+	}
+	return end, t.Before(end)
+}
+
+// wall clock time tod on a day in loc, which differs from midnight plus tod on days DST starts or ends
+func timeOfDay(year int, month time.Month, day int, tod time.Duration, loc *time.Location) time.Time {
+	return time.Date(year, month, day, int(tod/time.Hour), int(tod%time.Hour/time.Minute), int(tod%time.Minute/time.Second), int(tod%time.Second), loc)
+}
+
+// hold back a notification during QuietHours, deferring or dropping it
+func (n *TattlerClientHTTP) suppressQuiet(recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions, until time.Time) (NotificationResult, error) {
+	if n.QuietHours.Action == QuietHoursDrop || opts.SkipPersistency {
+		golog.Infof("Dropping notification %v to %v during quiet hours until %v", event_name, recipient, until)
+		return NotificationResult{Outcome: OutcomeNotSent}, fmt.Errorf("notification %v to %v dropped: %w", event_name, recipient, ErrSuppressedQuietHours)
+	}
+	urlstr, body, err := n.buildRequest(recipient, event_name, params, vectors, correlationId, opts)
+	if err != nil {
+		return NotificationResult{}, fmt.Errorf("failed to prepare tattler request: %w", err)
+	}
+	meta := taskMeta{Method: notificationMethod, Header: n.requestHeader(), NotBefore: &until, NotIdempotent: opts.NotIdempotent, CorrelationId: n.sentCorrelationId(urlstr, body)}
+	taskname, err := n.persistTask(urlstr, body, meta, false)
+	if err != nil {
+		return NotificationResult{}, fmt.Errorf("failed to defer notification %v to %v past quiet hours: %w", event_name, recipient, err)
+	}
+	golog.Infof("Deferring notification %v to %v as task %v until %v", event_name, recipient, taskname, until)
+	return NotificationResult{Outcome: OutcomePersisted, CorrelationId: meta.CorrelationId}, fmt.Errorf("notification %v to %v deferred until %v: %w", event_name, recipient, until, ErrSuppressedQuietHours)
+}
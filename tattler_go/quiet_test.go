@@ -0,0 +1,109 @@
+package tattler_go
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	at := func(hour int, min int) time.Time {
+		return time.Date(2024, 3, 10, hour, min, 0, 0, time.UTC)
+	}
+	night := QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour}
+	for _, tc := range []struct {
+		t     time.Time
+		quiet bool
+		until time.Time
+	}{
+		{at(21, 59), false, time.Time{}},
+		{at(22, 0), true, time.Date(2024, 3, 11, 7, 0, 0, 0, time.UTC)},
+		{at(3, 0), true, at(7, 0)},
+		{at(7, 0), false, time.Time{}},
+	} {
+		until, quiet := night.until(tc.t, nil)
+		if quiet != tc.quiet || (quiet && !until.Equal(tc.until)) {
+			t.Fatalf("QuietHours{22h-7h}.until(%v) = %v, %v; want %v, %v", tc.t, until, quiet, tc.until, tc.quiet)
+		}
+	}
+
+	lunch := QuietHours{Start: 12 * time.Hour, End: 13 * time.Hour}
+	if until, quiet := lunch.until(at(12, 30), nil); !quiet || !until.Equal(at(13, 0)) {
+		t.Fatalf("QuietHours{12h-13h}.until(12:30) = %v, %v; want 13:00, true", until, quiet)
+	}
+	// 12:30 UTC is 13:30 in UTC+1
+	if _, quiet := lunch.until(at(12, 30), time.FixedZone("UTC+1", 3600)); quiet {
+		t.Fatalf("QuietHours{12h-13h}.until(12:30 UTC) in UTC+1 reports quiet hours")
+	}
+
+	// DST starts on March 31st 2024 in Berlin, so the day lasts 23 hours
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone database unavailable: %v", err)
+	}
+	inBerlin := func(day int, hour int, min int) time.Time {
+		return time.Date(2024, 3, day, hour, min, 0, 0, berlin)
+	}
+	morning := QuietHours{Start: 6 * time.Hour, End: 8 * time.Hour, Location: berlin}
+	if _, quiet := morning.until(inBerlin(31, 5, 30), nil); quiet {
+		t.Fatalf("QuietHours{6h-8h}.until(05:30) on DST day reports quiet hours")
+	}
+	if until, quiet := morning.until(inBerlin(31, 7, 30), nil); !quiet || !until.Equal(inBerlin(31, 8, 0)) {
+		t.Fatalf("QuietHours{6h-8h}.until(07:30) on DST day = %v, %v; want %v, true", until, quiet, inBerlin(31, 8, 0))
+	}
+	if _, quiet := morning.until(inBerlin(31, 8, 30), nil); quiet {
+		t.Fatalf("QuietHours{6h-8h}.until(08:30) on DST day reports quiet hours")
+	}
+	if until, quiet := night.until(inBerlin(30, 23, 0), berlin); !quiet || !until.Equal(inBerlin(31, 7, 0)) {
+		t.Fatalf("QuietHours{22h-7h}.until(23:00) before DST day = %v, %v; want %v, true", until, quiet, inBerlin(31, 7, 0))
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// window from one hour ago to one hour ahead
+	sinceMidnight := time.Duration(time.Now().UTC().Hour()) * time.Hour
+	quiet := &QuietHours{Start: (sinceMidnight + 23*time.Hour) % (24 * time.Hour), End: (sinceMidnight + 2*time.Hour) % (24 * time.Hour)}
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", QuietHours: quiet}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted QuietHoursDefer without PersistencyDir")
+	}
+	n.PersistencyDir = fpath
+
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if !errors.Is(err, ErrSuppressedQuietHours) || result.Outcome != OutcomePersisted || requests != 0 {
+		t.Fatalf("SendNotificationOptions() during quiet hours = %v, '%v' with %v requests; want deferred", result.Outcome, err, requests)
+	}
+	if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: time.Hour}); err != nil || res.Skipped != 1 || requests != 0 {
+		t.Fatalf("ReplayOutstandingTasksOptions() during quiet hours = %+v, %v with %v requests; want deferred task skipped", res, err, requests)
+	}
+
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{Priority: PriorityHigh}); err != nil || requests != 1 {
+		t.Fatalf("SendNotificationOptions() with PriorityHigh during quiet hours returned '%v' with %v requests; want sent", err, requests)
+	}
+
+	quiet.Action = QuietHoursDrop
+	result, err = n.SendNotificationOptions(context.Background(), "637", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if !errors.Is(err, ErrSuppressedQuietHours) || result.Outcome != OutcomeNotSent || requests != 1 {
+		t.Fatalf("SendNotificationOptions() with QuietHoursDrop = %v, '%v' with %v requests; want dropped", result.Outcome, err, requests)
+	}
+	if res, _ := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: time.Hour}); res.Found != 1 {
+		t.Fatalf("SendNotificationOptions() with QuietHoursDrop journaled the notification: %+v", res)
+	}
+}
@@ -0,0 +1,92 @@
+package tattler_go
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// Headers in which Tattler server may advertise its rate limit
+const (
+	RateLimitLimitHeader     string = "X-RateLimit-Limit"
+	RateLimitRemainingHeader string = "X-RateLimit-Remaining"
+	RateLimitResetHeader     string = "X-RateLimit-Reset"
+)
+
+// values of RateLimitResetHeader from this on are taken as Unix times rather than seconds from now
+const rateLimitResetEpoch = 1_000_000_000
+
+// RateLimit is the rate limit Tattler server advertised in its last response carrying one.
+type RateLimit struct {
+	// Requests allowed per window
+	Limit int
+	// Requests left in the current window
+	Remaining int
+	// When the current window ends, and Remaining is replenished
+	Reset time.Time
+}
+
+// parse the rate limit advertised in a response header received at now, or return false if it advertises none
+func parseRateLimit(header http.Header, now time.Time) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(header.Get(RateLimitRemainingHeader))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	limit, _ := strconv.Atoi(header.Get(RateLimitLimitHeader))
+	rl := RateLimit{Limit: limit, Remaining: remaining}
+	// either seconds from now, or a Unix time
+	if reset, err := strconv.ParseInt(header.Get(RateLimitResetHeader), 10, 64); err == nil && reset >= rateLimitResetEpoch {
+		rl.Reset = time.Unix(reset, 0)
+	} else if err == nil && reset >= 0 {
+		rl.Reset = now.Add(time.Duration(reset) * time.Second)
+	}
+	return rl, true
+}
+
+// ServerRateLimit returns the rate limit Tattler server advertised in its last response carrying one, or false if none did yet.
+func (n *TattlerClientHTTP) ServerRateLimit() (RateLimit, bool) {
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	return state.rateLimit, state.rateLimited
+}
+
+// record the rate limit advertised in a response, if any
+func (n *TattlerClientHTTP) recordRateLimit(header http.Header) {
+	rl, ok := parseRateLimit(header, n.now())
+	if !ok {
+		return
+	}
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	state.rateLimit, state.rateLimited = rl, true
+}
+
+// wait until the server's rate limit window resets if HonorServerRateLimit and no requests are left in it, or ctx is done
+func (n *TattlerClientHTTP) awaitRateLimit(ctx context.Context) error {
+	if !n.HonorServerRateLimit {
+		return nil
+	}
+	rl, ok := n.ServerRateLimit()
+	if !ok || rl.Remaining > 0 {
+		return nil
+	}
+	wait := rl.Reset.Sub(n.now())
+	if wait <= 0 {
+		return nil
+	}
+	golog.Debugf("Delaying request by %v until tattler rate limit resets at %v", wait, rl.Reset)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for tattler rate limit to reset at %v: %w", rl.Reset, ctx.Err())
+	}
+}
@@ -0,0 +1,70 @@
+package tattler_go
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerRateLimit(t *testing.T) {
+	var remaining atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RateLimitLimitHeader, "10")
+		w.Header().Set(RateLimitRemainingHeader, "0")
+		if rem := remaining.Add(-1); rem >= 0 {
+			w.Header().Set(RateLimitRemainingHeader, "5")
+		}
+		w.Header().Set(RateLimitResetHeader, "1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	if _, ok := n.ServerRateLimit(); ok {
+		t.Fatalf("ServerRateLimit() reported a limit before any response")
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed: %v", err)
+	}
+	rl, ok := n.ServerRateLimit()
+	if !ok || rl.Limit != 10 || rl.Remaining != 0 || time.Until(rl.Reset) <= 0 || time.Until(rl.Reset) > time.Second {
+		t.Fatalf("ServerRateLimit() = %+v, %v; want exhausted limit of 10 resetting within 1s", rl, ok)
+	}
+
+	// without HonorServerRateLimit, sends go ahead regardless
+	tstart := time.Now()
+	n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	if elapsed := time.Since(tstart); elapsed > 500*time.Millisecond {
+		t.Fatalf("SendNotification() without HonorServerRateLimit was delayed by %v", elapsed)
+	}
+
+	n.HonorServerRateLimit = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.SendNotificationContext(ctx, "636", "ev", map[string]string{}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotificationContext() with exhausted rate limit unexpectedly went ahead before its deadline")
+	}
+	remaining.Store(1)
+	tstart = time.Now()
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() with exhausted rate limit failed: %v", err)
+	}
+	if elapsed := time.Since(tstart); elapsed < 500*time.Millisecond {
+		t.Fatalf("SendNotification() with exhausted rate limit was delayed only by %v; want until reset", elapsed)
+	}
+	if rl, _ := n.ServerRateLimit(); rl.Remaining != 5 {
+		t.Fatalf("ServerRateLimit() = %+v after replenishing; want 5 remaining", rl)
+	}
+
+	epoch := time.Now().Add(time.Hour).Truncate(time.Second)
+	header := http.Header{}
+	header.Set(RateLimitRemainingHeader, "3")
+	header.Set(RateLimitResetHeader, strconv.FormatInt(epoch.Unix(), 10))
+	if rl, ok := parseRateLimit(header, time.Now()); !ok || rl.Remaining != 3 || !rl.Reset.Equal(epoch) {
+		t.Fatalf("parseRateLimit() of Unix time reset = %+v, %v; want reset at %v", rl, ok, epoch)
+	}
+}
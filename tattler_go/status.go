@@ -0,0 +1,96 @@
+package tattler_go
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Path, relative to Endpoint, under which Tattler server reports the status of deliveries by id
+const deliveryStatusPath string = "status"
+
+// DeliveryStatus is the status of one delivery, as reported by Tattler server to GetDeliveryStatus.
+type DeliveryStatus struct {
+	// Id of the delivery, as in NotificationResult.DeliveryIds
+	Id string `json:"id"`
+	// Vector delivered through
+	Vector string `json:"vector"`
+	// Status of the delivery, e.g. "pending", "delivered" or "bounced"; see Tattler server docs for the full list
+	Status string `json:"status"`
+	// Explanation given by the server, if any
+	Detail string `json:"detail"`
+	// When the status last changed; zero if not reported
+	Updated time.Time `json:"updated"`
+}
+
+// ids Tattler server assigned to each vector of a delivered notification, from its response: a JSON list of objects with
+// "vector" and "id" attributes, the latter like "email:49b99061-...". Return nil if the response carries none.
+func deliveryIds(respbody []byte) map[string]string {
+	var deliveries []struct {
+		Vector string `json:"vector"`
+		Id     string `json:"id"`
+	}
+	if json.Unmarshal(respbody, &deliveries) != nil {
+		return nil
+	}
+	var ids map[string]string
+	for _, d := range deliveries {
+		if d.Id == "" {
+			continue
+		}
+		vector := d.Vector
+		if vector == "" {
+			// ids are prefixed by their vector
+			vector, _, _ = strings.Cut(d.Id, ":")
+		}
+		if ids == nil {
+			ids = map[string]string{}
+		}
+		ids[vector] = d.Id
+	}
+	return ids
+}
+
+/*
+GetDeliveryStatus asks Tattler server for the status of a delivery, by an id it assigned as reported in
+NotificationResult.DeliveryIds.
+
+The server is expected to report it at `{Endpoint}/status/{id}` as a JSON object with the attributes of DeliveryStatus.
+Failure statuses are returned like for FetchSupportedModes, e.g. a *ServerError matching ErrEndpointNotFound for unknown ids.
+*/
+func (n *TattlerClientHTTP) GetDeliveryStatus(ctx context.Context, id string) (DeliveryStatus, error) {
+	if err := n.ensureValid(); err != nil {
+		return DeliveryStatus{}, fmt.Errorf("validating configuration failed: %w", err)
+	}
+	if id = strings.TrimSpace(id); id == "" {
+		return DeliveryStatus{}, fmt.Errorf("empty delivery id given")
+	}
+	statusurl := fmt.Sprintf("%v/%v/%v", n.Endpoint, deliveryStatusPath, url.PathEscape(id))
+	request, err := http.NewRequestWithContext(ctx, "GET", statusurl, nil)
+	if err != nil {
+		return DeliveryStatus{}, fmt.Errorf("failed to prepare delivery status request '%v': %w", statusurl, err)
+	}
+	request.Header.Set("Accept", n.acceptHeader())
+	request.Header.Set(ClientSchemaHeader, n.clientSchema())
+	if n.ScopeInHeader {
+		request.Header.Set(n.scopeHeader(), n.Scope)
+	}
+	resp, respbody, _, resperr := n.roundTrip(ctx, request, n.newHTTPClient())
+	if resperr != nil {
+		return DeliveryStatus{}, requestError(statusurl, resperr)
+	}
+	if autherr := authErrorFor(statusurl, resp.StatusCode, resp.Status, resp.Header); autherr != nil {
+		return DeliveryStatus{}, autherr
+	} else if resp.StatusCode != http.StatusOK {
+		return DeliveryStatus{}, &ServerError{URL: statusurl, StatusCode: resp.StatusCode, Status: resp.Status, Body: respbody, Problem: n.problemFor(resp.Header, respbody)}
+	}
+	var status DeliveryStatus
+	if err := json.Unmarshal(respbody, &status); err != nil {
+		return DeliveryStatus{}, fmt.Errorf("tattler delivery status at %v is unparseable: %w", statusurl, err)
+	}
+	return status, nil
+}
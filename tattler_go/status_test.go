@@ -0,0 +1,52 @@
+package tattler_go
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliveryStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[{"id":"email:49b99061-2a6f","vector":"email","resultCode":0,"result":"success","detail":"OK"},` +
+				`{"id":"sms:77c1","resultCode":0,"result":"success","detail":"OK"}]`))
+		case r.URL.Path == "/status/email:49b99061-2a6f":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id":"email:49b99061-2a6f","vector":"email","status":"bounced","detail":"mailbox full","updated":"2024-05-01T10:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if err != nil {
+		t.Fatalf("SendNotificationOptions() unexpectedly failed: %v", err)
+	}
+	if len(result.DeliveryIds) != 2 || result.DeliveryIds["email"] != "email:49b99061-2a6f" || result.DeliveryIds["sms"] != "sms:77c1" {
+		t.Fatalf("SendNotificationOptions() returned DeliveryIds %v", result.DeliveryIds)
+	}
+
+	status, err := n.GetDeliveryStatus(context.Background(), result.DeliveryIds["email"])
+	want := DeliveryStatus{Id: "email:49b99061-2a6f", Vector: "email", Status: "bounced", Detail: "mailbox full", Updated: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	if err != nil || status != want {
+		t.Fatalf("GetDeliveryStatus() = %+v, %v; want %+v", status, err, want)
+	}
+	if _, err := n.GetDeliveryStatus(context.Background(), "email:unknown"); !errors.Is(err, ErrEndpointNotFound) {
+		t.Fatalf("GetDeliveryStatus() of unknown id returned err=%v; want ErrEndpointNotFound", err)
+	}
+	if _, err := n.GetDeliveryStatus(context.Background(), " "); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Fatalf("GetDeliveryStatus() of empty id returned err=%v", err)
+	}
+	if ids := deliveryIds([]byte(`{"correlationId":"abc"}`)); ids != nil {
+		t.Fatalf("deliveryIds() of response without ids = %v; want nil", ids)
+	}
+}
@@ -0,0 +1,67 @@
+package tattler_go
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RenderedMessage is the content Tattler server rendered and delivered for a notification sent with SendSync.
+type RenderedMessage struct {
+	// Correlation id the notification was sent with
+	CorrelationId string
+	// Rendered content, for each vector the server delivered to or attempted to
+	Vectors []RenderedVector
+}
+
+// RenderedVector is the content rendered for one vector, and the outcome of delivering it.
+type RenderedVector struct {
+	Vector string `json:"vector"`
+	// 0 upon success
+	ResultCode int    `json:"resultCode"`
+	Result     string `json:"result"`
+	Detail     string `json:"detail"`
+	// Subject line, for vectors which have one
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// result reported by Tattler server for vectors whose content failed to render
+const renderErrorResult = "render_error"
+
+/*
+Send a notification, asking Tattler server to render and deliver it synchronously instead of queueing it,
+and return the content it rendered.
+
+SendSync passes query parameter "sync=true", and expects the server to respond with a JSON list of objects, one per vector,
+like those of asynchronous deliveries plus "subject" and "body" attributes with the rendered content.
+
+Failures to render are returned as *RenderError: either the server rejects the notification as a whole with
+HTTP 422 Unprocessable Entity, or it reports result "render_error" for a vector. Other failures are returned like
+for SendNotificationContext. Vectors failing to deliver are reported in the returned message too, along with an error.
+*/
+func (n *TattlerClientHTTP) SendSync(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (RenderedMessage, error) {
+	result, err := n.sendNotification(ctx, recipient, event_name, params, vectors, correlationId, SendOptions{sync: true})
+	if err != nil {
+		var srverr *ServerError
+		if errors.As(err, &srverr) && srverr.StatusCode == http.StatusUnprocessableEntity {
+			return RenderedMessage{}, &RenderError{Detail: string(srverr.Body), Err: err}
+		}
+		return RenderedMessage{}, err
+	}
+	msg := RenderedMessage{CorrelationId: result.CorrelationId}
+	if err := json.Unmarshal(result.Body, &msg.Vectors); err != nil {
+		return msg, fmt.Errorf("tattler delivered notification but responded with unparseable rendered content: %w", err)
+	}
+	var errs []error
+	for _, rendered := range msg.Vectors {
+		if rendered.Result == renderErrorResult {
+			errs = append(errs, &RenderError{Vector: rendered.Vector, Detail: rendered.Detail})
+		} else if rendered.ResultCode != 0 {
+			errs = append(errs, fmt.Errorf("tattler failed to deliver notification via %v: %v (%v)", rendered.Vector, rendered.Detail, rendered.ResultCode))
+		}
+	}
+	return msg, errors.Join(errs...)
+}
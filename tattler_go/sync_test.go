@@ -0,0 +1,61 @@
+package tattler_go
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendSync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sync") != "true" {
+			t.Errorf("SendSync() requested without sync=true: %v", r.URL)
+		}
+		switch r.URL.Query().Get("user") {
+		case "badtemplate":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`missing param 'amount'`))
+		case "badvector":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[{"vector":"email","resultCode":0,"result":"success","detail":"OK","subject":"Hi","body":"Hello"},` +
+				`{"vector":"sms","resultCode":1,"result":"render_error","detail":"sms template missing"}]`))
+		case "unreachable":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[{"vector":"email","resultCode":2,"result":"error","detail":"mailbox unavailable","subject":"Hi","body":"Hello"}]`))
+		default:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[{"vector":"email","resultCode":0,"result":"success","detail":"OK","subject":"Hi","body":"Hello 636"}]`))
+		}
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	msg, err := n.SendSync(context.Background(), "636", "ev", map[string]string{}, []string{}, "correlId")
+	if err != nil {
+		t.Fatalf("SendSync() unexpectedly failed: %v", err)
+	}
+	if msg.CorrelationId != "correlId" || len(msg.Vectors) != 1 || msg.Vectors[0].Subject != "Hi" || msg.Vectors[0].Body != "Hello 636" {
+		t.Fatalf("SendSync() returned unexpected rendered message %+v", msg)
+	}
+
+	var renderErr *RenderError
+	_, err = n.SendSync(context.Background(), "badtemplate", "ev", map[string]string{}, []string{}, "")
+	if !errors.As(err, &renderErr) || renderErr.Vector != "" || StatusCode(err) != http.StatusUnprocessableEntity {
+		t.Fatalf("SendSync() upon 422 returned '%v'; want RenderError for the whole notification", err)
+	}
+
+	msg, err = n.SendSync(context.Background(), "badvector", "ev", map[string]string{}, []string{}, "")
+	if !errors.As(err, &renderErr) || renderErr.Vector != "sms" {
+		t.Fatalf("SendSync() upon vector failing to render returned '%v'; want RenderError for vector sms", err)
+	}
+	if len(msg.Vectors) != 2 || msg.Vectors[0].Body != "Hello" {
+		t.Fatalf("SendSync() upon vector failing to render fails to return content of other vectors: %+v", msg)
+	}
+
+	_, err = n.SendSync(context.Background(), "unreachable", "ev", map[string]string{}, []string{}, "")
+	if err == nil || errors.As(err, &renderErr) {
+		t.Fatalf("SendSync() upon vector failing to deliver returned '%v'; want error other than RenderError", err)
+	}
+}
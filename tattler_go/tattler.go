@@ -16,6 +16,9 @@ Basic usage:
 	myContext["invoice_number"] = "20230512"
 	err := notifcli.SendNotification("7598", "new_invoice_created", myContext)
 
+Code depending on the client should accept a Notifier, which TattlerClientHTTP implements,
+so that tests can substitute a fake.
+
 Notice that "Mode" defaults to "debug", so notifications are sent to the debug address
 instead of the requested recipient, unless explicitly changed. Find details at
 https://docs.tattler.dev/en/latest/keyconcepts/mode.html .
@@ -26,24 +29,53 @@ This preserves notifications sent while the server was unreachable, and allows
 replaying failed deliveries after the fact.
 
 Persistency is organized as follows: each uncompleted notification attempt is stored
-as a pair of files (cache keys), named:
+as a set of files (cache keys), named:
 - `{timestamp}_{randint}_url` -- whose content is the URL sent to tattler
 - `{timestamp}_{randint}_body` -- whose content is the JSON body POSTed to tattler
+- `{timestamp}_{randint}_meta` -- whose content is a JSON object with the HTTP method and headers of the request
+
+Tasks journalled before `_meta` was introduced lack it, and are replayed as POST with default headers.
+
+With CompressPersisted, `_body` is stored gzip-compressed, marked by fscache metadata `encoding: gzip`. Parts lacking
+the mark are read as is, so tasks compressed or not can be replayed by any client.
+
+While a send or ReplayOutstandingTasks delivers a task, it holds a `{timestamp}_{randint}_claim` key, so several
+processes can send and replay on the same PersistencyDir without delivering a task twice. A claim left behind by a crashed
+process blocks its task until it grows stale, after 10 times Timeout for each endpoint, and is taken over.
 */
 package tattler_go
 
 import (
 	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode/utf8"
+	"unsafe"
 
 	"github.com/kataras/golog"
 	"github.com/tattler-community/tattler-client-go/fscache"
@@ -57,14 +89,370 @@ type TattlerClientHTTP struct {
 	Scope string
 	// Base URL to reach Tattler server at; actual notifications will be composed by suffixing paths to this base URL.
 	Endpoint string
+	// Base URLs to try in turn, with the same request, when sending to Endpoint fails for a transport error or a 5xx response.
+	FailoverEndpoints []string
 	// How long to wait for a request to Tattler server to complete.
 	Timeout time.Duration
+	// How long to wait for a connection to Tattler server to be established; 0 means up to Timeout. Must be set before the first send.
+	ConnectTimeout time.Duration
 	// Operating mode to request to Tattler server; see Tattler server docs for "Modes" for its semantic.
 	Mode string
+	// Fraction of notifications, between 0 and 1, to send in CanaryMode instead of Mode, picked at random upon each send.
+	CanaryFraction float64
+	// Mode to send the CanaryFraction of notifications in, e.g. "staging" to validate template changes; required if CanaryFraction > 0.
+	CanaryMode string
+	// Seed for picking canary notifications, for reproducible picks in tests; 0 seeds randomly. Must be set before the first send.
+	CanarySeed int64
+	// Recipients allowed in mode "debug", as a safety belt should the server's debug address be misconfigured; notifications
+	// to others fail with ErrRecipientNotAllowed. Empty allows all. Other modes are not restricted.
+	DebugRecipientAllowlist []string
+	// Modes accepted by ValidateConfiguration; defaults to NotificationModes. See FetchSupportedModes to obtain them from the server.
+	AllowedModes []string
+	// Decides whether a response of Tattler server means the notification was accepted, so its task is cleared; defaults to DefaultSuccess.
+	SuccessFunc func(statusCode int, body []byte) bool
+	// Called on each request to Tattler server right before sending it, e.g. to set headers, sign it or trace it: requests
+	// delivering notifications, also upon replay, as well as probes by Ping and queries like FetchSupportedModes. If it
+	// returns error, the request is not sent and fails with that error.
+	RequestInterceptor func(*http.Request) error
+	// Accept header to send with requests; defaults to DefaultAccept. Include "application/problem+json" to have RFC 7807
+	// problem documents in failed responses parsed into ServerError.Problem.
+	Accept string
+	// Request schema version to announce to Tattler server in ClientSchemaHeader; defaults to DefaultClientSchema.
+	ClientSchema string
+	// Send Scope in header ScopeHeader instead of as a segment of notification URLs, e.g. for gateways routing by header.
+	ScopeInHeader bool
+	// Header to send Scope in if ScopeInHeader is set; defaults to DefaultScopeHeader. Only allowed with ScopeInHeader.
+	ScopeHeader string
+	// Path segment between Endpoint and scope in notification URLs; defaults to DefaultNotificationPathSegment.
+	NotificationPathSegment string
+	// Omit the slash between event name and query in notification URLs (".../event?..." instead of ".../event/?...").
+	NoTrailingSlash bool
 	// Attempt to persist tasks in this folder before sending notifications; clear the task if the notification succeeded.
 	PersistencyDir string
+	// Storage to persist tasks into instead of PersistencyDir, e.g. an object store shared across instances; see
+	// fscache.Storage. Cannot be combined with PersistencyDir. Must be set before the first send.
+	PersistencyStorage fscache.Storage
+	// Permissions of files persisted into PersistencyDir, e.g. 0640 for a replaying process of another user in the group
+	// to read them; 0 for 0600. See fscache.FSCache.SetFileMode. Must be set before the first send.
+	PersistencyFileMode fs.FileMode
+	// Clock telling the time for time-based behavior, e.g. DedupWindow, QuietHours and the age of persisted tasks; defaults
+	// to fscache.SystemClock. Tests may set a fake one to not wait in real time. Timeouts and the delay of requests still
+	// run in real time. Must be set before the first send.
+	Clock fscache.Clock
+	// Store the body of persisted tasks gzip-compressed. Tasks are read back alike regardless of this setting.
+	CompressPersisted bool
+	// Whether failing to persist a task aborts its notification; defaults to DeliveryBestEffort.
+	Delivery DeliveryGuarantee
+	// Before each send, replay up to DrainMaxTasks journaled tasks, oldest first, so fresh notifications do not overtake
+	// a backlog. This trades latency for ordering: each send lists the journal, and waits for the replays, up to
+	// DrainMaxTasks times Timeout. Draining stops at the first failing task, and its failures do not fail the send.
+	// Requires persistency.
+	DrainBeforeSend bool
+	// Maximum number of tasks replayed before each send with DrainBeforeSend; defaults to DefaultDrainMaxTasks.
+	DrainMaxTasks int
+	// Spread files in PersistencyDir across subdirectories, so no single directory holds the whole journal. Items persisted
+	// with a different setting are not seen, so must not change while PersistencyDir holds tasks.
+	ShardPersistency bool
+	// How to handle vector names which fail validation; defaults to VectorPolicyDrop.
+	VectorPolicy VectorPolicy
+	// Maximum number of distinct vectors per notification, beyond which it fails, e.g. to not exceed URL length limits;
+	// defaults to DefaultMaxVectors.
+	MaxVectors int
+	// Where to send the correlationId of notifications; defaults to CorrelationIdInQuery.
+	CorrelationIdPlacement CorrelationIdPlacement
+	// Param key to send the correlationId under in the body, if CorrelationIdPlacement includes it; defaults to
+	// DefaultCorrelationIdBodyKey. Notifications with a param of this key fail rather than have it overwritten.
+	CorrelationIdBodyKey string
+	// Treat vector names outside KnownVectors as invalid, handling them per VectorPolicy; cannot be combined with VectorPolicyPassThrough.
+	OnlyKnownVectors bool
+	// Fail notifications left without any valid vector, instead of letting Tattler server deliver to all vectors of the recipient.
+	RequireVectors bool
+	// Fail notifications with param keys which are not valid template variable names, i.e. matching [A-Za-z_][A-Za-z0-9_]*.
+	ValidateParamKeys bool
+	// Fail notifications with param keys among ReservedQueryParams or equal to CorrelationIdBodyKey, which name what the
+	// client sends itself, so they are never mistaken for or overwritten by it.
+	RejectReservedParamKeys bool
+	// Expand param values as text/template templates over the params before sending, e.g. "Hello {{.name}}" given a
+	// "name" param. Templates see the params as given, not expanded, and fail if they reference a param not given.
+	PreRenderParams bool
+	// Additional query parameters to pass to Tattler server with each notification; cannot override ReservedQueryParams.
+	ExtraQueryParams map[string]string
+	// Additional query parameters which may repeat, e.g. for servers expecting repeated keys rather than comma-joined
+	// lists. Values are added after those of ExtraQueryParams and of the client, keeping their order. Cannot include
+	// ReservedQueryParams unless AllowReservedExtraQuery.
+	ExtraQueryValues url.Values
+	// Let ExtraQueryValues add values to ReservedQueryParams, after the one set by the client.
+	AllowReservedExtraQuery bool
+	// Delay requests while Tattler server's advertised rate limit is exhausted, until it resets; see ServerRateLimit.
+	HonorServerRateLimit bool
+	// Maximum number of requests in flight at once; callers exceeding it block until a slot frees. 0 means unlimited. Must be set before the first send.
+	MaxConcurrent int
+	// Measure the phases of each request delivering a notification, e.g. connecting and waiting for the server, and report
+	// them in NotificationResult.Timing. Off by default, as tracing adds overhead to each request.
+	TraceTiming bool
+
+	// Upon successful delivery, move the task into ArchiveDir along with the server's response and delivery time, instead of just deleting it.
+	ArchiveOnSuccess bool
+	// Upon successful delivery, also record its URL and body into ArchiveDir by correlationId, for LookupSent.
+	// Works regardless of persistency.
+	ArchiveSent bool
+	// Folder holding archived tasks, if ArchiveOnSuccess or ArchiveSent is set; must differ from PersistencyDir.
+	ArchiveDir string
+	// Daily window during which notifications of PriorityNormal are deferred or dropped instead of sent; nil for none.
+	QuietHours *QuietHours
+	// Hold back all notifications of this client like SetPaused does, regardless of the pause flag.
+	Paused bool
+	// Skip notifications identical (in scope, event, recipient and params) to one delivered within this window, returning ErrDeduplicated. Requires PersistencyDir, where delivered notifications are marked; ReplayOutstandingTasks clears marks older than the window.
+	DedupWindow time.Duration
+	// Keys of params whose values are masked in log output; values are still sent to Tattler server.
+	SensitiveParamKeys []string
+	// Check that responses echoing a correlationId in CorrelationIdHeader echo the one sent, failing with a
+	// CorrelationMismatchError otherwise, e.g. to detect responses misrouted by a proxy. Responses not echoing it pass.
+	VerifyCorrelationEcho bool
+	// Only deem responses successful if, besides passing SuccessFunc, they have Content-Type application/json and their
+	// body is a JSON object or list of objects, as Tattler server sends. Others fail with an InvalidResponseError and keep
+	// their task, e.g. an HTML page served with 200 OK by a misconfigured proxy.
+	StrictResponseValidation bool
+	// Speak only HTTP/2 to Tattler server: as negotiated over TLS for https:// endpoints, and with prior knowledge (h2c)
+	// for http:// ones. Requests to servers not supporting it fail. Must be set before the first send.
+	ForceHTTP2 bool
+	// Unix domain socket to reach Tattler server at instead of over TCP, e.g. for a sidecar. Endpoint then only provides
+	// the path of URLs, its host being ignored, and defaults to DefaultSocketEndpoint. Must be set before the first send.
+	SocketPath string
+
+	// set by NewClient and Revalidate, to skip revalidating configuration upon each send
+	sealed bool
+	// set by NewClient and Revalidate, for fastRequestURL
+	fastBase fastBase
+	// runtime state, a *clientState created upon first use; accessed atomically, as an unsafe.Pointer rather than an
+	// atomic.Pointer so that configurations can be copied
+	state unsafe.Pointer
+}
+
+// clientState holds what a client accumulates while running, as opposed to its configuration.
+type clientState struct {
+	// slots for in-flight requests, if MaxConcurrent > 0
+	sem chan struct{}
+	// transport dedicated to this client, if its configuration requires one
+	transport *http.Transport
+	// set by SetPaused, if persistency is disabled
+	paused atomic.Bool
+
+	// guards fields below
+	mux sync.Mutex
+	// protocol negotiated by the last request, e.g. "HTTP/2.0"
+	lastProto string
+	// rate limit advertised by the server, if rateLimited
+	rateLimit   RateLimit
+	rateLimited bool
+	// outcomes of deliveries so far
+	health HealthStatus
+	// source of canary picks, if CanaryFraction > 0
+	canaryRand *rand.Rand
+	// cache of PersistencyDir or PersistencyStorage, resolved upon first use, and settings it was resolved for
+	persistency        *fscache.FSCache
+	persistencyDir     string
+	persistencySharded bool
+}
+
+func (n *TattlerClientHTTP) runtimeState() *clientState {
+	if state := atomic.LoadPointer(&n.state); state != nil {
+		return (*clientState)(state)
+	}
+	state := &clientState{}
+	if n.MaxConcurrent > 0 {
+		state.sem = make(chan struct{}, n.MaxConcurrent)
+	}
+	if n.ForceHTTP2 || n.ConnectTimeout > 0 || n.SocketPath != "" {
+		state.transport = n.newTransport()
+	}
+	// sends racing to create the state all use the first one stored; the others are dropped unused
+	if atomic.CompareAndSwapPointer(&n.state, nil, unsafe.Pointer(state)) {
+		return state
+	}
+	return (*clientState)(atomic.LoadPointer(&n.state))
+}
+
+// create a transport honoring the client's connection settings
+func (n *TattlerClientHTTP) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if n.ForceHTTP2 {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if n.ConnectTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   n.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if n.SocketPath != "" {
+		dialer := &net.Dialer{Timeout: n.ConnectTimeout}
+		socketPath := n.SocketPath
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+	return transport
+}
+
+// create a client for requests to Tattler server
+func (n *TattlerClientHTTP) newHTTPClient() *http.Client {
+	client := &http.Client{Timeout: n.Timeout}
+	if transport := n.runtimeState().transport; transport != nil {
+		client.Transport = transport
+	}
+	return client
+}
+
+// LastProtocol returns the protocol negotiated by the last request to Tattler server, e.g. "HTTP/1.1" or "HTTP/2.0".
+//
+// LastProtocol returns an empty string if no response was received yet.
+func (n *TattlerClientHTTP) LastProtocol() string {
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	return state.lastProto
+}
+
+// DeliveryGuarantee controls how tasks are journaled into PersistencyDir around sending notifications.
+//
+// Under either guarantee, a task is persisted before its notification is sent, and cleared only after Tattler server
+// accepted it, so notifications which failed or were interrupted (e.g. by a crash) are left for ReplayOutstandingTasks.
+// A notification delivered but not cleared (e.g. upon a crash right after delivery, or PersistencyDir becoming unavailable)
+// is delivered again upon replay; failing to clear it is logged, but not reported to the sender, whose send succeeded.
+type DeliveryGuarantee int
+
+const (
+	// Send notifications even if their task failed to be persisted, which is only logged; such notifications are lost if
+	// delivery fails. Notifications are not persisted at all if PersistencyDir is empty.
+	DeliveryBestEffort DeliveryGuarantee = iota
+	// Send notifications only once their task was persisted, returning error without sending otherwise. Requires PersistencyDir.
+	// Sends with SendOptions.SkipPersistency are exempt, and are delivered best-effort.
+	DeliveryAtLeastOnce
+)
+
+// RecipientType tells Tattler server how to interpret the recipient of a notification.
+type RecipientType int
+
+const (
+	// Recipient is the id of a user known to Tattler server, passed as query parameter "user"
+	RecipientUserID RecipientType = iota
+	// Recipient is an email address not tied to a known user, passed as query parameter "email"
+	RecipientEmailAddress
+	// Recipient is a phone number in international format (e.g. +41791234567) not tied to a known user, passed as query parameter "sms"
+	RecipientPhoneNumber
+)
+
+// query parameter carrying the recipient for each RecipientType
+var recipientQueryParams = map[RecipientType]string{
+	RecipientUserID:       "user",
+	RecipientEmailAddress: "email",
+	RecipientPhoneNumber:  "sms",
+}
+
+// HealthStatus summarizes the recent outcomes of deliveries to Tattler server, by sends and replays.
+type HealthStatus struct {
+	// When a notification was last delivered; zero if never
+	LastSuccess time.Time
+	// When delivering a notification last failed; zero if never
+	LastFailure time.Time
+	// Why delivering a notification last failed; empty if never
+	LastError string
+	// Deliveries failed since the last successful one
+	ConsecutiveFailures uint
+}
+
+// Health reports the recent outcomes of deliveries by this client, e.g. for a readiness probe.
+func (n *TattlerClientHTTP) Health() HealthStatus {
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	return state.health
+}
+
+// current time per Clock
+func (n *TattlerClientHTTP) now() time.Time {
+	if n.Clock != nil {
+		return n.Clock.Now()
+	}
+	return time.Now()
+}
+
+// account the outcome of a delivery into Health
+func (n *TattlerClientHTTP) recordHealth(err error) {
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	if err == nil {
+		state.health.LastSuccess = n.now()
+		state.health.ConsecutiveFailures = 0
+		return
+	}
+	state.health.LastFailure = n.now()
+	state.health.LastError = err.Error()
+	state.health.ConsecutiveFailures++
+}
+
+// mode to send a notification in: CanaryMode for a CanaryFraction of them, else Mode
+func (n *TattlerClientHTTP) pickMode() string {
+	if n.CanaryFraction <= 0 {
+		return n.Mode
+	}
+	state := n.runtimeState()
+	state.mux.Lock()
+	if state.canaryRand == nil {
+		seed := n.CanarySeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		state.canaryRand = rand.New(rand.NewSource(seed))
+	}
+	canary := state.canaryRand.Float64() < n.CanaryFraction
+	state.mux.Unlock()
+	if canary {
+		golog.Infof("Sending notification in canary mode '%v' instead of '%v'", n.CanaryMode, n.Mode)
+		return n.CanaryMode
+	}
+	golog.Debugf("Sending notification in mode '%v'", n.Mode)
+	return n.Mode
 }
 
+// VectorPolicy controls how vector names which fail validation are handled.
+type VectorPolicy int
+
+const (
+	// Drop invalid vectors with a warning, and deliver to the valid ones
+	VectorPolicyDrop VectorPolicy = iota
+	// Fail the notification if any vector is invalid
+	VectorPolicyStrict
+	// Send vectors as given (trimmed, and skipping empty ones), and let Tattler server decide
+	VectorPolicyPassThrough
+)
+
+// Maximum number of distinct vectors per notification when no MaxVectors is given in TattlerClientHTTP structure
+const DefaultMaxVectors int = 16
+
+// CorrelationIdPlacement controls where the correlationId of notifications is sent.
+type CorrelationIdPlacement int
+
+const (
+	// Send the correlationId as query parameter
+	CorrelationIdInQuery CorrelationIdPlacement = iota
+	// Send the correlationId both as query parameter, and in the body under CorrelationIdBodyKey
+	CorrelationIdInQueryAndBody
+	// Send the correlationId only in the body under CorrelationIdBodyKey
+	CorrelationIdInBody
+)
+
+// Param key to send the correlationId under in the body when no CorrelationIdBodyKey is given in TattlerClientHTTP structure
+const DefaultCorrelationIdBodyKey string = "correlationId"
+
+// Maximum number of tasks replayed before each send with DrainBeforeSend when no DrainMaxTasks is given in TattlerClientHTTP structure
+const DefaultDrainMaxTasks int = 10
+
 // Default timeout to use when none is given in TattlerClientHTTP structure
 const DefaultTimeout time.Duration = 5 * time.Second
 
@@ -74,6 +462,36 @@ var NotificationModes = []string{"production", "staging", "debug"}
 // Notification mode to use when no custom mode is requested
 const DefaultMode string = "debug"
 
+// Path segment to use in notification URLs when none is given in TattlerClientHTTP structure
+const DefaultNotificationPathSegment string = "notification"
+
+// Accept header to send when none is given in TattlerClientHTTP structure
+const DefaultAccept string = "application/json"
+
+// Request schema version the client speaks, announced when none is given in TattlerClientHTTP structure
+const DefaultClientSchema string = "1"
+
+// Endpoint to use when SocketPath is set and no Endpoint is given in TattlerClientHTTP structure
+const DefaultSocketEndpoint string = "http://localhost"
+
+// Header to send Scope in when ScopeInHeader is set and no ScopeHeader is given in TattlerClientHTTP structure
+const DefaultScopeHeader string = "X-Tattler-Scope"
+
+// Header in which Tattler server may echo the correlationId of the request it responds to; see VerifyCorrelationEcho
+const CorrelationIdHeader string = "X-Correlation-Id"
+
+// Header announcing the request schema version of the client to Tattler server
+const ClientSchemaHeader string = "X-Tattler-Client-Schema"
+
+// Header announcing the request schema version of Tattler server in its responses
+const ServerSchemaHeader string = "X-Tattler-Server-Schema"
+
+// media type of RFC 7807 problem documents
+const problemMediaType = "application/problem+json"
+
+// Query parameters set by the client itself, which ExtraQueryParams cannot override
+var ReservedQueryParams = []string{"mode", "user", "email", "sms", "vector", "correlationId", "debugAddress", "sync", "template"}
+
 // Returns the position of an item in a slice, or -1 if not found
 func find(haystack []string, needle string) int {
 	for i, v := range haystack {
@@ -84,10 +502,110 @@ func find(haystack []string, needle string) int {
 	return -1
 }
 
+// Replacement for values of sensitive params in logs
+const redactedValue string = "***"
+
+// returns params as JSON for logging, with values of SensitiveParamKeys masked
+func (n *TattlerClientHTTP) loggableParams(params map[string]string) string {
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if find(n.SensitiveParamKeys, k) != -1 {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	data, _ := mkJSONContext(redacted)
+	return string(data)
+}
+
 func mkJSONContext(params map[string]string) ([]byte, error) {
+	// params are rendered by tattler, so keep <, > and & literal rather than HTML-escaped
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
 	// cannot fail, because map[string]string is always convertible
-	data, _ := json.Marshal(params)
-	return data, nil
+	encoder.Encode(params)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// source of randomness for correlation ids and task names; replaced in tests
+var randReader io.Reader = crand.Reader
+
+// generate a random correlation id
+func newCorrelationId() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(randReader, b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate correlation id: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// tells apart task names generated within the same second without randomness
+var taskCounter atomic.Uint64
+
+// generate a name for a new task; if randomness is unavailable, falls back to the process id and a counter, so tasks
+// are journaled regardless
+func newTaskName(t time.Time) string {
+	now := t.Unix()
+	var b [4]byte
+	if _, err := io.ReadFull(randReader, b[:]); err != nil {
+		golog.Warnf("Failed to generate random task name, falling back to counter: %v", err)
+		return fmt.Sprintf("%v_p%xc%x", now, os.Getpid(), taskCounter.Add(1))
+	}
+	return fmt.Sprintf("%v_%x", now, b)
+}
+
+// Names of vectors known to Tattler server
+const (
+	VectorEmail string = "email"
+	VectorSMS   string = "sms"
+	VectorPush  string = "push"
+)
+
+// Vectors accepted when OnlyKnownVectors is set
+var KnownVectors = []string{VectorEmail, VectorSMS, VectorPush}
+
+// IsKnownVector returns whether a vector name, once normalized, is among KnownVectors.
+func IsKnownVector(vname string) bool {
+	normvname, valid := normalizeVectorName(vname)
+	return valid && slices.Contains(KnownVectors, normvname)
+}
+
+// NormalizeVectors splits vector names into the valid ones, normalized, and the invalid ones, as given.
+// Unlike TattlerClientHTTP.CheckVectors, it disregards any client's OnlyKnownVectors and VectorPolicy.
+func NormalizeVectors(vectors []string) (valid []string, invalid []string) {
+	for _, v := range vectors {
+		if normvname, ok := normalizeVectorName(v); ok {
+			valid = append(valid, normvname)
+		} else {
+			invalid = append(invalid, v)
+		}
+	}
+	return valid, invalid
+}
+
+/*
+CheckVectors splits vector names as notifications of this client would: into those sent, normalized unless
+VectorPolicyPassThrough, and those rejected, as given, which VectorPolicyDrop drops and VectorPolicyStrict fails
+notifications for. Blank names are neither.
+*/
+func (c *TattlerClientHTTP) CheckVectors(vectors []string) (valid []string, invalid []string) {
+	for _, v := range vectors {
+		normvname, ok := normalizeVectorName(v)
+		if ok && c.OnlyKnownVectors && !slices.Contains(KnownVectors, normvname) {
+			ok = false
+		}
+		if ok {
+			valid = append(valid, normvname)
+		} else if c.VectorPolicy == VectorPolicyPassThrough {
+			if v = strings.TrimSpace(v); v != "" {
+				valid = append(valid, v)
+			}
+		} else {
+			invalid = append(invalid, v)
+		}
+	}
+	return valid, invalid
 }
 
 // normalize a vector name, if valid, else return false
@@ -100,209 +618,2211 @@ func normalizeVectorName(vname string) (string, bool) {
 	return normalizedName, true
 }
 
+// validate the shape of a recipient of a given type, and return the query parameter to pass it in
+func recipientQueryParam(recipient string, recipientType RecipientType) (string, error) {
+	param, ok := recipientQueryParams[recipientType]
+	if !ok {
+		return "", fmt.Errorf("invalid RecipientType=%v", recipientType)
+	}
+	switch recipientType {
+	case RecipientEmailAddress:
+		// reject display names and other RFC 5322 forms, which Tattler server would not expect
+		if addr, err := mail.ParseAddress(recipient); err != nil || addr.Name != "" || addr.Address != recipient {
+			return "", fmt.Errorf("recipient '%v' is not a valid email address", recipient)
+		}
+	case RecipientPhoneNumber:
+		if matched, _ := regexp.MatchString(`^\+[1-9][0-9]{6,14}$`, recipient); !matched {
+			return "", fmt.Errorf("recipient '%v' is not a phone number in international format, e.g. +41791234567", recipient)
+		}
+	}
+	return param, nil
+}
+
+/*
+NewClient validates a configuration once, and returns a client using it which skips revalidation upon each send.
+
+Configuration attributes of the returned client must not change afterwards, unless followed by Revalidate.
+*/
+func NewClient(config TattlerClientHTTP) (*TattlerClientHTTP, error) {
+	client := config
+	client.state = nil
+	if err := client.Revalidate(); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// Revalidate validates configuration like ValidateConfiguration, and lets later sends skip revalidation if it is valid.
+//
+// Revalidate must not be called concurrently with sends.
+func (c *TattlerClientHTTP) Revalidate() error {
+	c.sealed = false
+	if err := c.ValidateConfiguration(); err != nil {
+		return err
+	}
+	c.sealed = true
+	c.fastBase = c.newFastBase()
+	return nil
+}
+
+// validate configuration, unless validated already by NewClient or Revalidate
+func (c *TattlerClientHTTP) ensureValid() error {
+	if c.sealed {
+		return nil
+	}
+	return c.ValidateConfiguration()
+}
+
+// strip blanks and trailing slashes off an endpoint, so paths can be appended to it
+func normalizeEndpoint(endpoint string) string {
+	return strings.Trim(strings.TrimSpace(endpoint), "/")
+}
+
 /*
 Validate configuration items set in TattlerClientHTTP structions, and set missing ones to default.
 
 Return nil if configuration is valid; an error description otherwise.
 */
 func (c *TattlerClientHTTP) ValidateConfiguration() error {
-	c.Endpoint = strings.TrimSpace(c.Endpoint)
-	c.Endpoint = strings.Trim(c.Endpoint, "/")
-	c.Scope = strings.TrimSpace(c.Scope)
-	c.Mode = strings.TrimSpace(c.Mode)
+	// only write back fields which change, so a validated configuration can be shared by concurrent senders
+	setIfChanged := func(field *string, value string) {
+		if *field != value {
+			*field = value
+		}
+	}
+	setIfChanged(&c.Endpoint, normalizeEndpoint(c.Endpoint))
+	setIfChanged(&c.SocketPath, strings.TrimSpace(c.SocketPath))
+	if c.SocketPath != "" && c.Endpoint == "" {
+		c.Endpoint = DefaultSocketEndpoint
+	}
+	setIfChanged(&c.Scope, strings.TrimSpace(c.Scope))
+	setIfChanged(&c.Mode, strings.TrimSpace(c.Mode))
 	if c.Timeout == time.Duration(0) {
 		c.Timeout = DefaultTimeout
 	} else if c.Timeout < 0 {
 		return fmt.Errorf("client configuration has invalid Timeout=%v < 0", c.Timeout)
 	}
+	if c.ConnectTimeout < 0 {
+		return fmt.Errorf("client configuration has invalid ConnectTimeout=%v < 0", c.ConnectTimeout)
+	} else if c.ConnectTimeout > c.Timeout {
+		return fmt.Errorf("client configuration has ConnectTimeout=%v exceeding Timeout=%v", c.ConnectTimeout, c.Timeout)
+	}
 	if c.Endpoint == "" {
 		return fmt.Errorf("client configuration has invalid server endpoint; want http://foo.com:1234/path, have '%v'", c.Endpoint)
 	} else if _, err := url.ParseRequestURI(c.Endpoint); err != nil {
 		return fmt.Errorf("client configuration's server endpoint is not a valid URL, have '%v'", c.Endpoint)
 	}
+	if c.SocketPath != "" && len(c.FailoverEndpoints) > 0 {
+		return fmt.Errorf("client configuration has FailoverEndpoints along with SocketPath '%v', through which all requests go", c.SocketPath)
+	}
+	for _, endpoint := range c.FailoverEndpoints {
+		if _, err := url.ParseRequestURI(normalizeEndpoint(endpoint)); err != nil {
+			return fmt.Errorf("client configuration's failover endpoint is not a valid URL, have '%v'", endpoint)
+		}
+	}
 	if c.Scope == "" {
 		return fmt.Errorf("client configuration has invalid scope; want http://foo.com:1234/path, have '%v'", c.Scope)
+	} else if strings.ContainsAny(c.Scope, "/?#") {
+		return fmt.Errorf("client configuration has invalid scope '%v'; want a single URL path segment", c.Scope)
+	}
+	setIfChanged(&c.NotificationPathSegment, strings.TrimSpace(c.NotificationPathSegment))
+	setIfChanged(&c.ScopeHeader, strings.TrimSpace(c.ScopeHeader))
+	if c.ScopeHeader != "" && !c.ScopeInHeader {
+		return fmt.Errorf("client configuration has ScopeHeader '%v' without ScopeInHeader; scope would be sent in path", c.ScopeHeader)
+	} else if c.ScopeInHeader && c.ScopeHeader == "" {
+		c.ScopeHeader = DefaultScopeHeader
+	} else if matched, _ := regexp.MatchString("^[A-Za-z0-9-]+$", c.ScopeHeader); c.ScopeInHeader && !matched {
+		return fmt.Errorf("client configuration has invalid ScopeHeader '%v'", c.ScopeHeader)
+	}
+	setIfChanged(&c.Accept, strings.TrimSpace(c.Accept))
+	setIfChanged(&c.ClientSchema, strings.TrimSpace(c.ClientSchema))
+	if c.ClientSchema == "" {
+		c.ClientSchema = DefaultClientSchema
+	}
+	if c.Accept == "" {
+		c.Accept = DefaultAccept
+	}
+	if c.NotificationPathSegment == "" {
+		c.NotificationPathSegment = DefaultNotificationPathSegment
+	} else if strings.Contains(c.NotificationPathSegment, "/") || c.NotificationPathSegment == "." || c.NotificationPathSegment == ".." {
+		return fmt.Errorf("client configuration has invalid NotificationPathSegment; want a single path segment, have '%v'", c.NotificationPathSegment)
 	}
 	if c.Mode == "" {
 		c.Mode = DefaultMode
-	} else if find(NotificationModes, c.Mode) == -1 {
-		return fmt.Errorf("invalid mode '%v' requested out of supported '%v'; giving up delivery altogether", c.Mode, NotificationModes)
+	} else if find(c.allowedModes(), c.Mode) == -1 {
+		return fmt.Errorf("invalid mode '%v' requested out of supported '%v'; giving up delivery altogether", c.Mode, c.allowedModes())
 	}
-	return nil
-}
-
-func (c *TattlerClientHTTP) mkTattlerRequestURL(recipient string, event_name string, vectors []string, correlationId string) (string, error) {
-	if err := c.ValidateConfiguration(); err != nil {
-		return "", fmt.Errorf("validating configuration failed: %v", err)
+	setIfChanged(&c.CanaryMode, strings.TrimSpace(c.CanaryMode))
+	if c.CanaryFraction < 0 || c.CanaryFraction > 1 {
+		return fmt.Errorf("client configuration has invalid CanaryFraction=%v; want 0 to 1", c.CanaryFraction)
+	} else if c.CanaryFraction > 0 && find(c.allowedModes(), c.CanaryMode) == -1 {
+		return fmt.Errorf("client configuration has CanaryFraction=%v with invalid CanaryMode '%v' out of supported '%v'", c.CanaryFraction, c.CanaryMode, c.allowedModes())
 	}
-	// process vectors
-	var validVectors []string
-	if len(vectors) > 0 {
-		// some vectors requested. Validate them
-		for _, v := range vectors {
-			normvname, valid := normalizeVectorName(v)
-			if valid {
-				validVectors = append(validVectors, normvname)
-			} else {
-				golog.Warnf("SendNotification() of %v to %v requests invalid vector %v; ignoring", event_name, recipient, v)
-			}
+	if c.VectorPolicy < VectorPolicyDrop || c.VectorPolicy > VectorPolicyPassThrough {
+		return fmt.Errorf("client configuration has invalid VectorPolicy=%v", c.VectorPolicy)
+	}
+	if c.MaxVectors < 0 {
+		return fmt.Errorf("client configuration has invalid MaxVectors=%v < 0", c.MaxVectors)
+	} else if c.MaxVectors == 0 {
+		c.MaxVectors = DefaultMaxVectors
+	}
+	setIfChanged(&c.CorrelationIdBodyKey, strings.TrimSpace(c.CorrelationIdBodyKey))
+	if c.CorrelationIdPlacement < CorrelationIdInQuery || c.CorrelationIdPlacement > CorrelationIdInBody {
+		return fmt.Errorf("client configuration has invalid CorrelationIdPlacement=%v", c.CorrelationIdPlacement)
+	} else if c.CorrelationIdPlacement == CorrelationIdInQuery && c.CorrelationIdBodyKey != "" {
+		return fmt.Errorf("client configuration has CorrelationIdBodyKey '%v' with CorrelationIdInQuery; correlationId would not be sent in body", c.CorrelationIdBodyKey)
+	} else if c.CorrelationIdPlacement != CorrelationIdInQuery && c.CorrelationIdBodyKey == "" {
+		c.CorrelationIdBodyKey = DefaultCorrelationIdBodyKey
+	}
+	if c.MaxConcurrent < 0 {
+		return fmt.Errorf("client configuration has invalid MaxConcurrent=%v < 0", c.MaxConcurrent)
+	}
+	if c.DrainMaxTasks < 0 {
+		return fmt.Errorf("client configuration has invalid DrainMaxTasks=%v < 0", c.DrainMaxTasks)
+	} else if c.DrainBeforeSend && !c.persists() {
+		return fmt.Errorf("client configuration has DrainBeforeSend without PersistencyDir or PersistencyStorage")
+	} else if c.DrainBeforeSend && c.DrainMaxTasks == 0 {
+		c.DrainMaxTasks = DefaultDrainMaxTasks
+	}
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("client configuration has invalid DedupWindow=%v < 0", c.DedupWindow)
+	} else if c.OnlyKnownVectors && c.VectorPolicy == VectorPolicyPassThrough {
+		return fmt.Errorf("client configuration has OnlyKnownVectors with VectorPolicyPassThrough, which would pass unknown vectors through")
+	} else if c.Delivery < DeliveryBestEffort || c.Delivery > DeliveryAtLeastOnce {
+		return fmt.Errorf("client configuration has invalid Delivery=%v", c.Delivery)
+	} else if c.PersistencyDir != "" && c.PersistencyStorage != nil {
+		return fmt.Errorf("client configuration has both PersistencyDir and PersistencyStorage; want at most one")
+	} else if c.Delivery == DeliveryAtLeastOnce && !c.persists() {
+		return fmt.Errorf("client configuration has DeliveryAtLeastOnce without PersistencyDir to journal tasks in")
+	} else if c.DedupWindow > 0 && !c.persists() {
+		return fmt.Errorf("client configuration has DedupWindow without PersistencyDir to track delivered notifications in")
+	}
+	if c.QuietHours != nil {
+		if err := c.QuietHours.validate(); err != nil {
+			return fmt.Errorf("client configuration has %w", err)
+		} else if c.QuietHours.Action == QuietHoursDefer && !c.persists() {
+			return fmt.Errorf("client configuration has QuietHoursDefer without PersistencyDir to defer notifications into")
 		}
 	}
-	queryParams := map[string]string{}
-	queryParams["mode"] = c.Mode
-	queryParams["user"] = recipient
-	if len(validVectors) > 0 {
-		queryParams["vector"] = strings.Join(validVectors, ",")
+	if c.ArchiveOnSuccess && (!c.persists() || c.ArchiveDir == "") {
+		return fmt.Errorf("client configuration has ArchiveOnSuccess without both PersistencyDir and ArchiveDir")
+	} else if c.ArchiveSent && c.ArchiveDir == "" {
+		return fmt.Errorf("client configuration has ArchiveSent without ArchiveDir")
 	}
-	correlationId = strings.TrimSpace(correlationId)
-	if correlationId != "" {
-		queryParams["correlationId"] = correlationId
-	} else {
-		queryParams["correlationId"] = fmt.Sprintf("%x%x", rand.Uint64(), rand.Uint64())
+	if c.ArchiveOnSuccess || c.ArchiveSent {
+		if path.Clean(c.ArchiveDir) == path.Clean(c.PersistencyDir) {
+			return fmt.Errorf("client configuration has ArchiveDir equal to PersistencyDir '%v'; archived tasks would be replayed", c.PersistencyDir)
+		}
 	}
-	var paramsPart []string
-	for k, v := range queryParams {
-		p := fmt.Sprintf("%v=%v", url.QueryEscape(k), url.QueryEscape(v))
-		paramsPart = append(paramsPart, p)
+	for k := range c.ExtraQueryParams {
+		if find(ReservedQueryParams, k) != -1 {
+			return fmt.Errorf("client configuration has ExtraQueryParams overriding reserved parameter '%v'", k)
+		}
 	}
-	paramstr := strings.Join(paramsPart, "&")
-	finalURL := fmt.Sprintf("%v/notification/%v/%v/?%v", c.Endpoint, c.Scope, event_name, paramstr)
-	return finalURL, nil
-}
-
-// PrepareNotification prepares URL and Body to send to Tattler over HTTP for sending a notification.
-//
-// PrepareNotification returns error if the underlying TattlerClientHTTP object is misconfigured
-func (n *TattlerClientHTTP) PrepareNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (string, []byte, string, error) {
-	recipient = strings.TrimSpace(recipient)
-	event_name = strings.TrimSpace(event_name)
-	if recipient == "" || event_name == "" {
-		return "", nil, "", fmt.Errorf("failed to send notification '%v' to '%v': empty recipient or event_name provided", event_name, recipient)
+	for k := range c.ExtraQueryValues {
+		if find(ReservedQueryParams, k) != -1 && !c.AllowReservedExtraQuery {
+			return fmt.Errorf("client configuration has ExtraQueryValues adding to reserved parameter '%v' without AllowReservedExtraQuery", k)
+		}
 	}
+	return nil
+}
 
-	// URL
-	urlstr, urlerr := n.mkTattlerRequestURL(recipient, event_name, vectors, correlationId)
-	if urlerr != nil {
-		return "", nil, "", fmt.Errorf("failed to assemble URL for notification server: %v", urlerr)
+// modes the client accepts: AllowedModes if given, else NotificationModes
+func (n *TattlerClientHTTP) allowedModes() []string {
+	if len(n.AllowedModes) > 0 {
+		return n.AllowedModes
 	}
-	golog.Debugf("Prepared tattler URL=%v", urlstr)
-
-	// Body
-	body, _ := mkJSONContext(params)
-	golog.Debugf("Prepared body for notification server of %v bytes='%v'", len(body), body)
+	return NotificationModes
+}
 
-	taskname, persisterr := n.PersistTask(urlstr, body)
-	if persisterr != nil {
-		golog.Errorf("Error persisting task: '%v' (ignoring)", persisterr)
-	}
+// AllowedModeNames returns the modes the client accepts for Mode and CanaryMode: AllowedModes if given, else NotificationModes.
+func (n *TattlerClientHTTP) AllowedModeNames() []string {
+	return slices.Clone(n.allowedModes())
+}
 
-	return urlstr, body, taskname, nil
+// IsValidMode returns whether ValidateConfiguration would accept mode as Mode, regardless of the rest of the configuration.
+func (n *TattlerClientHTTP) IsValidMode(mode string) bool {
+	return find(n.allowedModes(), strings.TrimSpace(mode)) != -1
 }
 
-func (n *TattlerClientHTTP) prepareHTTPRequest(urlstr string, body []byte) (*http.Request, *http.Client) {
-	// request cannot fail, because urlstr was already validated
-	request, _ := http.NewRequest("POST", urlstr, bytes.NewBuffer(body))
-	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	request.Header.Set("Accept", "application/json")
+// Path, relative to Endpoint, where Tattler server describes its capabilities
+const capabilitiesPath string = "capabilities"
 
-	client := &http.Client{}
-	client.Timeout = n.Timeout
+/*
+Fetch the notification modes supported by Tattler server.
 
-	return request, client
+The server is expected to describe its capabilities at `{Endpoint}/capabilities` as a JSON object
+whose "modes" attribute lists supported mode names. If the server lacks that endpoint (404 or 405),
+NotificationModes is returned instead. Assign the result to AllowedModes to validate against it.
+*/
+func (n *TattlerClientHTTP) FetchSupportedModes(ctx context.Context) ([]string, error) {
+	if err := n.ensureValid(); err != nil {
+		return nil, fmt.Errorf("validating configuration failed: %w", err)
+	}
+	capsurl := fmt.Sprintf("%v/%v", n.Endpoint, capabilitiesPath)
+	request, err := http.NewRequestWithContext(ctx, "GET", capsurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare capabilities request '%v': %w", capsurl, err)
+	}
+	request.Header.Set("Accept", n.acceptHeader())
+	client := n.newHTTPClient()
+	resp, respbody, _, resperr := n.roundTrip(ctx, request, client)
+	if resperr != nil {
+		return nil, requestError(capsurl, resperr)
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		golog.Infof("Tattler %v does not describe its capabilities; assuming modes %v", n.Endpoint, NotificationModes)
+		return slices.Clone(NotificationModes), nil
+	}
+	if autherr := authErrorFor(capsurl, resp.StatusCode, resp.Status, resp.Header); autherr != nil {
+		return nil, autherr
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, &ServerError{URL: capsurl, StatusCode: resp.StatusCode, Status: resp.Status, Body: respbody}
+	}
+	var caps struct {
+		Modes []string `json:"modes"`
+	}
+	if err := json.Unmarshal(respbody, &caps); err != nil {
+		return nil, fmt.Errorf("tattler capabilities at %v are unparseable: %w", capsurl, err)
+	}
+	if len(caps.Modes) == 0 {
+		return nil, fmt.Errorf("tattler capabilities at %v list no modes", capsurl)
+	}
+	return caps.Modes, nil
 }
 
-func (n *TattlerClientHTTP) processResponse(statusCode int, statusText string, urlstr string, body []byte, taskname string) error {
-	if statusCode != 200 {
-		var extraPersistMsg string
-		if n.PersistencyDir != "" {
-			extraPersistMsg = " (keeping persistent task)"
-		}
-		return fmt.Errorf("tattler req '%v' failed with %v%v: %v", urlstr, extraPersistMsg, statusCode, statusText)
+// Ping probes the Tattler server at Endpoint, and returns nil if the server responds.
+//
+// Any HTTP response counts as reachable, except 401 and 403 which indicate that the server refuses this client, and
+// responses announcing a request schema other than ClientSchema, reported as SchemaMismatchError. The probe is sent like
+// notifications, through RequestInterceptor and with Scope in ScopeHeader if ScopeInHeader, so gateways authenticating
+// them let it through.
+func (n *TattlerClientHTTP) Ping(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", n.Endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to prepare probe of '%v': %w", n.Endpoint, err)
+	}
+	request.Header.Set("Accept", n.acceptHeader())
+	request.Header.Set(ClientSchemaHeader, n.clientSchema())
+	if n.ScopeInHeader {
+		request.Header.Set(n.scopeHeader(), n.Scope)
 	}
 
-	if taskname != "" {
-		n.ClearTask(taskname)
+	resp, _, _, resperr := n.roundTrip(ctx, request, n.newHTTPClient())
+	if resperr != nil {
+		return fmt.Errorf("failed to reach tattler %v: %w", n.Endpoint, resperr)
 	}
-	golog.Infof("Notification -> %v sent: %v %v", urlstr, statusCode, string(body))
-	return nil
+
+	if err := authErrorFor(n.Endpoint, resp.StatusCode, resp.Status, resp.Header); err != nil {
+		return err
+	}
+	return n.checkServerSchema(n.Endpoint, resp.Header)
 }
 
 /*
-Send a notification about an event to a recipient.
+Validate configuration like ValidateConfiguration, then probe the server with Ping.
 
-Validate the undelying connection settings and send the notification. If vectors are omitted, they default to all available vectors for the user.
-If a non-empty correlationId is provided, it is passed on in the request to the Tattler server, else a new one is auto-generated.
+This is meant to be called once at startup, to catch both configuration typos and connectivity problems.
+Use ValidateConfiguration on hot paths, as this performs a network round-trip.
 */
-func (n *TattlerClientHTTP) SendNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error {
-	urlstr, body, taskname, berr := n.PrepareNotification(recipient, event_name, params, vectors, correlationId)
-	if berr != nil {
-		return fmt.Errorf("failed to prepare tattler request: %v", berr)
+func (n *TattlerClientHTTP) ValidateConfigurationLive(ctx context.Context) error {
+	if err := n.ValidateConfiguration(); err != nil {
+		return err
 	}
-
-	request, client := n.prepareHTTPRequest(urlstr, body)
-	resp, resperr := client.Do(request)
-	if resperr != nil {
-		return fmt.Errorf("failed to request tattler %v: %v", urlstr, resperr)
+	if err := n.Ping(ctx); err != nil {
+		return fmt.Errorf("client configuration is valid but server probe failed: %w", err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	respbody, _ := io.ReadAll(resp.Body)
-	return n.processResponse(resp.StatusCode, resp.Status, urlstr, respbody, taskname)
+// QueryParams returns the query parameters BuildRequest would send for a notification, e.g. to inspect them in tests.
+//
+// A correlationId is generated anew upon each call if none is provided.
+// QueryParams returns error if the underlying TattlerClientHTTP object is misconfigured, or the request is invalid
+func (n *TattlerClientHTTP) QueryParams(recipient string, event_name string, vectors []string, correlationId string) (url.Values, error) {
+	recipient = strings.TrimSpace(recipient)
+	event_name = strings.TrimSpace(event_name)
+	if recipient == "" || event_name == "" {
+		return nil, fmt.Errorf("empty recipient or event_name provided")
+	}
+	return n.mkQueryParams(recipient, event_name, vectors, correlationId, SendOptions{})
 }
 
-func (n *TattlerClientHTTP) PersistTask(requrl string, reqbody []byte) (string, error) {
-	if n.PersistencyDir == "" {
-		golog.Debug("Not persisting task because PersistencyDir empty.")
-		return "", nil
+func (c *TattlerClientHTTP) mkTattlerRequestURL(recipient string, event_name string, vectors []string, correlationId string, opts SendOptions) (string, error) {
+	if urlstr, ok := c.fastRequestURL(recipient, event_name, vectors, correlationId, opts); ok {
+		return urlstr, nil
 	}
-	cache, err := fscache.GetInstance(n.PersistencyDir)
+	return c.mkGeneralRequestURL(recipient, event_name, vectors, correlationId, opts)
+}
+
+// mkTattlerRequestURL for any request, without fast path
+func (c *TattlerClientHTTP) mkGeneralRequestURL(recipient string, event_name string, vectors []string, correlationId string, opts SendOptions) (string, error) {
+	queryParams, err := c.mkQueryParams(recipient, event_name, vectors, correlationId, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to load cache to persist task: %v", err)
+		return "", err
 	}
-	taskname := fmt.Sprintf("%v_%x", time.Now().Unix(), rand.Uint32())
-	urlkname := fmt.Sprintf("%v_url", taskname)
-	urlerr := cache.Set(urlkname, []byte(requrl))
-	if urlerr != nil {
-		return "", fmt.Errorf("failed to persist request URL part into %v: %v", urlkname, urlerr)
-	}
-	bodykname := fmt.Sprintf("%v_body", taskname)
-	bodyerr := cache.Set(bodykname, []byte(reqbody))
-	if bodyerr != nil {
-		return "", fmt.Errorf("failed to persist request body part into %v: %v", bodykname, urlerr)
+	// Encode() sorts by key, so equal requests produce equal URLs
+	paramstr := queryParams.Encode()
+	trailingSlash := "/"
+	if c.NoTrailingSlash {
+		trailingSlash = ""
 	}
-	golog.Infof("Task journalled successfully with keys=%v_{url, body}", taskname)
-	return taskname, nil
+	finalURL := fmt.Sprintf("%v%v%v?%v", c.notificationBase(), event_name, trailingSlash, paramstr)
+	return finalURL, nil
 }
 
-func (n *TattlerClientHTTP) ClearTask(taskname string) error {
-	if taskname == "" {
-		golog.Debugf("Omitting clearing empty taskname.")
-		return nil
+// notification URLs up to the event name: Endpoint, NotificationPathSegment and Scope unless ScopeInHeader, then a slash
+func (c *TattlerClientHTTP) notificationBase() string {
+	if c.ScopeInHeader {
+		return c.Endpoint + "/" + c.NotificationPathSegment + "/"
 	}
-	if n.PersistencyDir == "" {
-		golog.Warnf("Requested to ClearTask() when PersistencyDir disabled")
-		return fmt.Errorf("cannot ClearTask(%v) because PersistencyDir is disabled", taskname)
+	return c.Endpoint + "/" + c.NotificationPathSegment + "/" + c.Scope + "/"
+}
+
+func (c *TattlerClientHTTP) mkQueryParams(recipient string, event_name string, vectors []string, correlationId string, opts SendOptions) (url.Values, error) {
+	if err := c.ensureValid(); err != nil {
+		return nil, fmt.Errorf("validating configuration failed: %w", err)
 	}
-	for _, part := range []string{"url", "body"} {
-		rpath := fmt.Sprintf("%v_%v", taskname, part)
-		os.Remove(rpath)
+	mode := c.pickMode()
+	opts.DebugOverrideAddress = strings.TrimSpace(opts.DebugOverrideAddress)
+	if opts.DebugOverrideAddress != "" && mode != "debug" {
+		return nil, fmt.Errorf("DebugOverrideAddress '%v' requested in mode '%v'; only allowed in mode 'debug'", opts.DebugOverrideAddress, mode)
 	}
-	cache, err := fscache.GetInstance(n.PersistencyDir)
+	recipientParam, err := recipientQueryParam(recipient, opts.RecipientType)
 	if err != nil {
-		return fmt.Errorf("failed to load cache to clear task %v: %v", taskname, err)
+		return nil, err
 	}
-	for _, part := range []string{"url", "body"} {
-		cache.Unset(fmt.Sprintf("%v_%v", taskname, part))
+	if mode == "debug" && len(c.DebugRecipientAllowlist) > 0 && !slices.Contains(c.DebugRecipientAllowlist, recipient) {
+		return nil, fmt.Errorf("recipient '%v' in mode 'debug': %w", recipient, ErrRecipientNotAllowed)
 	}
-	golog.Infof("Task %v successfully cleared from journal.")
-	return nil
-}
-
-// iterate over persisted tasks and request delivery to tattler.
-// Tasks older than maxAge are ignored.
-// Tasks that could be successfully delivered are discarded unless removeDone is set to false.
-// Returns the number of tasks found, sent, ignored. Or non-nil error upon failure
-func (n *TattlerClientHTTP) ReplayOutstandingTasks(maxAge time.Duration, removeDone bool) (uint, uint, uint, error) {
-
-	return 0, 0, 0, nil
+	if opts.TemplateOverride != nil {
+		if matched, _ := regexp.MatchString("^[A-Za-z0-9_-]+$", *opts.TemplateOverride); !matched {
+			return nil, fmt.Errorf("TemplateOverride '%v' is not a valid template name", *opts.TemplateOverride)
+		}
+	}
+	if err := validateLabels(opts.Labels); err != nil {
+		return nil, err
+	}
+	// process vectors
+	validVectors, invalidVectors := c.CheckVectors(vectors)
+	if len(invalidVectors) > 0 {
+		if c.VectorPolicy == VectorPolicyStrict {
+			return nil, fmt.Errorf("notification of %v to %v requests invalid vectors %q", event_name, recipient, invalidVectors)
+		}
+		golog.Warnf("SendNotification() of %v to %v requests invalid vectors %q; ignoring", event_name, recipient, invalidVectors)
+	}
+	// repeating a vector adds nothing but length to the URL
+	seen := map[string]bool{}
+	validVectors = slices.DeleteFunc(validVectors, func(v string) bool {
+		dup := seen[v]
+		seen[v] = true
+		return dup
+	})
+	if len(validVectors) > c.MaxVectors {
+		return nil, fmt.Errorf("notification of %v to %v requests %v distinct vectors, exceeding MaxVectors=%v", event_name, recipient, len(validVectors), c.MaxVectors)
+	}
+	if c.RequireVectors && len(validVectors) == 0 {
+		return nil, fmt.Errorf("notification of %v to %v has no valid vector, as required by RequireVectors (requested %q)", event_name, recipient, vectors)
+	}
+	queryParams := url.Values{}
+	for k, v := range c.ExtraQueryParams {
+		queryParams.Set(k, v)
+	}
+	queryParams.Set("mode", mode)
+	queryParams.Set(recipientParam, recipient)
+	if len(validVectors) > 0 {
+		queryParams.Set("vector", strings.Join(validVectors, ","))
+	}
+	correlationId = strings.TrimSpace(correlationId)
+	if correlationId != "" {
+		if opts.ServerCorrelationId {
+			return nil, fmt.Errorf("correlationId '%v' given along with ServerCorrelationId", correlationId)
+		}
+		if c.CorrelationIdPlacement != CorrelationIdInBody {
+			queryParams.Set("correlationId", correlationId)
+		}
+	} else if !opts.ServerCorrelationId && c.CorrelationIdPlacement != CorrelationIdInBody {
+		corrid, err := newCorrelationId()
+		if err != nil {
+			return nil, err
+		}
+		queryParams.Set("correlationId", corrid)
+	}
+	if opts.DebugOverrideAddress != "" {
+		queryParams.Set("debugAddress", opts.DebugOverrideAddress)
+	}
+	if opts.sync {
+		queryParams.Set("sync", "true")
+	}
+	if opts.TemplateOverride != nil {
+		queryParams.Set("template", *opts.TemplateOverride)
+	}
+	for k, v := range opts.Labels {
+		queryParams.Set(LabelParamPrefix+k, v)
+	}
+	for k, values := range c.ExtraQueryValues {
+		for _, v := range values {
+			queryParams.Add(k, v)
+		}
+	}
+	return queryParams, nil
+}
+
+// BuildRequest computes URL and Body to send to Tattler over HTTP for sending a notification, without persisting anything.
+//
+// The result is deterministic if a non-empty correlationId is provided.
+// BuildRequest returns error if the underlying TattlerClientHTTP object is misconfigured
+func (n *TattlerClientHTTP) BuildRequest(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (string, []byte, error) {
+	return n.buildRequest(recipient, event_name, params, vectors, correlationId, SendOptions{})
+}
+
+// keys of m, sorted
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// validateParamsEncoding returns error naming the first param (in key order) whose key or value is not valid UTF-8.
+//
+// json.Marshal would otherwise silently replace invalid bytes, e.g. of Latin-1 values, with U+FFFD.
+func validateParamsEncoding(params map[string]string) error {
+	keys := sortedKeys(params)
+	for _, k := range keys {
+		if !utf8.ValidString(k) {
+			return fmt.Errorf("param key %q is not valid UTF-8", k)
+		}
+		if !utf8.ValidString(params[k]) {
+			return fmt.Errorf("value of param '%v' is not valid UTF-8", k)
+		}
+	}
+	return nil
+}
+
+// valid template variable name, which param keys must be
+var paramKeyRe = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// returns error naming the first param key, in sorted order, which is not a valid template variable name
+func validateParamKeys(params map[string]string) error {
+	keys := sortedKeys(params)
+	for _, k := range keys {
+		if !paramKeyRe.MatchString(k) {
+			return fmt.Errorf("param key %q is not a valid template variable name", k)
+		}
+	}
+	return nil
+}
+
+// returns error naming the first param key, in sorted order, which names something the client sends itself
+func (n *TattlerClientHTTP) validateReservedParamKeys(params map[string]string) error {
+	keys := sortedKeys(params)
+	bodyKey := strings.TrimSpace(n.CorrelationIdBodyKey)
+	for _, k := range keys {
+		if find(ReservedQueryParams, k) != -1 {
+			return fmt.Errorf("param key '%v' is reserved for a query parameter of the client", k)
+		} else if bodyKey != "" && k == bodyKey {
+			return fmt.Errorf("param key '%v' is reserved for the correlationId per CorrelationIdBodyKey", k)
+		}
+	}
+	return nil
+}
+
+// Params Tattler server takes sender overrides from; see SendOptions.From and SendOptions.ReplyTo
+const (
+	FromParam    string = "from"
+	ReplyToParam string = "reply_to"
+)
+
+// Prefix of the query params carrying SendOptions.Labels, e.g. "label_campaign" for label "campaign"
+const LabelParamPrefix = "label_"
+
+// valid label key
+var labelKeyRe = regexp.MustCompile("^[A-Za-z][A-Za-z0-9_]*$")
+
+// returns error naming the first label, in key order, whose key is invalid or whose value is not valid UTF-8
+func validateLabels(labels map[string]string) error {
+	keys := sortedKeys(labels)
+	for _, k := range keys {
+		if !labelKeyRe.MatchString(k) {
+			return fmt.Errorf("label key %q is not valid; want [A-Za-z][A-Za-z0-9_]*", k)
+		}
+		if !utf8.ValidString(labels[k]) {
+			return fmt.Errorf("value of label '%v' is not valid UTF-8", k)
+		}
+	}
+	return nil
+}
+
+// returns a copy of params with the sender overrides of opts added, or error if they are invalid or params has them already
+func (n *TattlerClientHTTP) addSenderParams(params map[string]string, vectors []string, opts SendOptions) (map[string]string, error) {
+	valid, _ := n.CheckVectors(vectors)
+	byEmail := len(valid) == 0 || slices.Contains(valid, VectorEmail)
+	params = maps.Clone(params)
+	if params == nil {
+		params = map[string]string{}
+	}
+	for _, override := range []struct{ param, value string }{{FromParam, opts.From}, {ReplyToParam, opts.ReplyTo}} {
+		value := strings.TrimSpace(override.value)
+		if value == "" {
+			continue
+		}
+		if _, taken := params[override.param]; taken {
+			return nil, fmt.Errorf("param '%v' would be overwritten by sender override", override.param)
+		}
+		if _, err := mail.ParseAddress(value); byEmail && err != nil {
+			return nil, fmt.Errorf("sender override '%v' for param '%v' is not a valid email address: %w", value, override.param, err)
+		}
+		params[override.param] = value
+	}
+	return params, nil
+}
+
+// returns a copy of params with values expanded as templates over params, or error naming the first param, in sorted
+// order, whose template is invalid or references an undefined param
+func preRenderParams(params map[string]string) (map[string]string, error) {
+	keys := sortedKeys(params)
+	rendered := make(map[string]string, len(params))
+	for _, k := range keys {
+		if !strings.Contains(params[k], "{{") {
+			rendered[k] = params[k]
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=error").Parse(params[k])
+		if err != nil {
+			return nil, fmt.Errorf("value of param '%v' is not a valid template: %w", k, err)
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, params); err != nil {
+			return nil, fmt.Errorf("failed to render value of param '%v': %w", k, err)
+		}
+		rendered[k] = buf.String()
+	}
+	return rendered, nil
+}
+
+func (n *TattlerClientHTTP) buildRequest(recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions) (string, []byte, error) {
+	recipient = strings.TrimSpace(recipient)
+	event_name = strings.TrimSpace(event_name)
+	if recipient == "" || event_name == "" {
+		return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': empty recipient or event_name provided", event_name, recipient)
+	}
+
+	if err := validateParamsEncoding(params); err != nil {
+		return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': %w", event_name, recipient, err)
+	}
+	if n.ValidateParamKeys {
+		if err := validateParamKeys(params); err != nil {
+			return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': %w", event_name, recipient, err)
+		}
+	}
+	if n.RejectReservedParamKeys {
+		if err := n.validateReservedParamKeys(params); err != nil {
+			return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': %w", event_name, recipient, err)
+		}
+	}
+	if n.PreRenderParams {
+		var err error
+		if params, err = preRenderParams(params); err != nil {
+			return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': %w", event_name, recipient, err)
+		}
+	}
+	if n.CorrelationIdPlacement != CorrelationIdInQuery && !opts.ServerCorrelationId {
+		if err := n.ensureValid(); err != nil {
+			return "", nil, fmt.Errorf("validating configuration failed: %w", err)
+		}
+		if _, taken := params[n.CorrelationIdBodyKey]; taken {
+			return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': param '%v' would be overwritten by correlationId", event_name, recipient, n.CorrelationIdBodyKey)
+		}
+		// generate it here, so query and body carry the same
+		if correlationId = strings.TrimSpace(correlationId); correlationId == "" {
+			var err error
+			if correlationId, err = newCorrelationId(); err != nil {
+				return "", nil, err
+			}
+		}
+		params = maps.Clone(params)
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[n.CorrelationIdBodyKey] = correlationId
+	}
+	if opts.From != "" || opts.ReplyTo != "" {
+		var err error
+		if params, err = n.addSenderParams(params, vectors, opts); err != nil {
+			return "", nil, fmt.Errorf("failed to send notification '%v' to '%v': %w", event_name, recipient, err)
+		}
+	}
+
+	// URL
+	urlstr, urlerr := n.mkTattlerRequestURL(recipient, event_name, vectors, correlationId, opts)
+	if urlerr != nil {
+		return "", nil, fmt.Errorf("failed to assemble URL for notification server: %w", urlerr)
+	}
+	golog.Debugf("Prepared tattler URL=%v", urlstr)
+
+	// Body
+	body, _ := mkJSONContext(params)
+	golog.Debugf("Prepared body for notification server of %v bytes='%v'", len(body), n.loggableParams(params))
+
+	return urlstr, body, nil
+}
+
+// PrepareNotification prepares URL and Body to send to Tattler over HTTP for sending a notification, and persists them as a task.
+//
+// PrepareNotification returns error if the underlying TattlerClientHTTP object is misconfigured
+func (n *TattlerClientHTTP) PrepareNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) (string, []byte, string, error) {
+	return n.prepareNotification(recipient, event_name, params, vectors, correlationId, SendOptions{}, false)
+}
+
+// build a request and journal its task, claimed if the caller delivers it right away and releases the claim afterwards
+func (n *TattlerClientHTTP) prepareNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions, claim bool) (string, []byte, string, error) {
+	urlstr, body, err := n.buildRequest(recipient, event_name, params, vectors, correlationId, opts)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	if opts.SkipPersistency {
+		golog.Debug("Not persisting task because SkipPersistency requested.")
+		return urlstr, body, "", nil
+	}
+	meta := taskMeta{Method: notificationMethod, Header: n.requestHeader(), NotIdempotent: opts.NotIdempotent, CorrelationId: n.sentCorrelationId(urlstr, body)}
+	taskname, persisterr := n.persistTask(urlstr, body, meta, claim)
+	if persisterr != nil {
+		if n.Delivery == DeliveryAtLeastOnce {
+			return "", nil, "", fmt.Errorf("failed to journal task before sending, as required by DeliveryAtLeastOnce: %w", persisterr)
+		}
+		golog.Errorf("Error persisting task: '%v' (ignoring)", persisterr)
+	}
+
+	return urlstr, body, taskname, nil
+}
+
+// HTTP method used to deliver notifications
+const notificationMethod string = "POST"
+
+// headers to send along with notification requests
+func (n *TattlerClientHTTP) requestHeader() http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json; charset=UTF-8")
+	header.Set("Accept", n.acceptHeader())
+	header.Set(ClientSchemaHeader, n.clientSchema())
+	if n.ScopeInHeader {
+		header.Set(n.scopeHeader(), n.Scope)
+	}
+	return header
+}
+
+// header to send Scope in if ScopeInHeader, also for clients whose configuration was not validated yet
+func (n *TattlerClientHTTP) scopeHeader() string {
+	if header := strings.TrimSpace(n.ScopeHeader); header != "" {
+		return header
+	}
+	return DefaultScopeHeader
+}
+
+// schema version to announce, also for clients whose configuration was not validated yet
+func (n *TattlerClientHTTP) clientSchema() string {
+	if schema := strings.TrimSpace(n.ClientSchema); schema != "" {
+		return schema
+	}
+	return DefaultClientSchema
+}
+
+// returns a SchemaMismatchError if a response announces a schema other than the client's; servers announcing none are trusted
+func (n *TattlerClientHTTP) checkServerSchema(urlstr string, header http.Header) error {
+	schema := strings.TrimSpace(header.Get(ServerSchemaHeader))
+	if schema == "" || schema == n.clientSchema() {
+		return nil
+	}
+	return &SchemaMismatchError{URL: urlstr, ClientSchema: n.clientSchema(), ServerSchema: schema}
+}
+
+// Accept header to send, also for clients whose configuration was not validated yet
+func (n *TattlerClientHTTP) acceptHeader() string {
+	if accept := strings.TrimSpace(n.Accept); accept != "" {
+		return accept
+	}
+	return DefaultAccept
+}
+
+// parse a response into an RFC 7807 problem document, if the client accepts them and the response is one; else nil
+func (n *TattlerClientHTTP) problemFor(header http.Header, body []byte) *Problem {
+	if !strings.Contains(n.acceptHeader(), problemMediaType) {
+		return nil
+	}
+	if mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type")); err != nil || mediatype != problemMediaType {
+		return nil
+	}
+	var problem Problem
+	if err := json.Unmarshal(body, &problem); err != nil {
+		golog.Warnf("Tattler response claims to be a problem document, but is unparseable: %v", err)
+		return nil
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	return &problem
+}
+
+func (n *TattlerClientHTTP) prepareHTTPRequest(urlstr string, body []byte) (*http.Request, *http.Client) {
+	return n.prepareHTTPRequestMeta(urlstr, body, taskMeta{Method: notificationMethod, Header: n.requestHeader()})
+}
+
+func (n *TattlerClientHTTP) prepareHTTPRequestMeta(urlstr string, body []byte, meta taskMeta) (*http.Request, *http.Client) {
+	// request cannot fail, because urlstr was already validated and meta.Method is a valid method
+	request, _ := http.NewRequest(meta.Method, urlstr, bytes.NewBuffer(body))
+	request.Header = meta.Header.Clone()
+
+	return request, n.newHTTPClient()
+}
+
+// DefaultSuccess accepts responses with status 200 OK, regardless of their body.
+func DefaultSuccess(statusCode int, body []byte) bool {
+	return statusCode == http.StatusOK
+}
+
+// handle the response of Tattler server to a notification sent to urlstr, completing taskname upon success unless empty.
+// failover tells urlstr is on one of FailoverEndpoints.
+func (n *TattlerClientHTTP) processResponse(statusCode int, statusText string, header http.Header, urlstr string, body []byte, taskname string, failover bool) error {
+	success := DefaultSuccess
+	if n.SuccessFunc != nil {
+		success = n.SuccessFunc
+	}
+	if err := n.checkServerSchema(urlstr, header); err != nil {
+		golog.Warnf("%v", err)
+	}
+	if err := n.checkCorrelationEcho(urlstr, header); err != nil {
+		// the response may belong to another request, so keep the task
+		return err
+	}
+	if !success(statusCode, body) {
+		if autherr := authErrorFor(urlstr, statusCode, statusText, header); autherr != nil {
+			return autherr
+		}
+		srverr := &ServerError{
+			URL:        urlstr,
+			StatusCode: statusCode,
+			Status:     statusText,
+			Body:       body,
+			TaskKept:   n.persists(),
+			Problem:    n.problemFor(header, body),
+		}
+		if isEndpointNotFound(statusCode) && taskname != "" && !failover {
+			// replaying would fail alike until the configuration is fixed. A failover endpoint lacking the path tells
+			// nothing of Endpoint though, so the task is kept for replay there.
+			if err := n.ClearTask(taskname); err != nil {
+				golog.Warnf("Error clearing task %v for unknown endpoint: '%v'", taskname, err)
+			} else {
+				srverr.TaskKept = false
+			}
+		}
+		return srverr
+	}
+	if n.StrictResponseValidation {
+		if err := validateResult(urlstr, statusCode, header, body); err != nil {
+			// the server may have never seen the request, so keep the task
+			return err
+		}
+	}
+
+	if taskname != "" {
+		n.completeTask(taskname, body)
+	}
+	return nil
+}
+
+// returns an *InvalidResponseError unless a response is a JSON object or list of objects, and declared as JSON
+func validateResult(urlstr string, statusCode int, header http.Header, body []byte) error {
+	contentType := header.Get("Content-Type")
+	invalid := func(reason string) error {
+		return &InvalidResponseError{URL: urlstr, StatusCode: statusCode, ContentType: contentType, Reason: reason}
+	}
+	if mediatype, _, err := mime.ParseMediaType(contentType); err != nil || mediatype != "application/json" {
+		return invalid("want Content-Type application/json")
+	}
+	var object map[string]any
+	if json.Unmarshal(body, &object) == nil && object != nil {
+		return nil
+	}
+	var list []map[string]any
+	if json.Unmarshal(body, &list) == nil && list != nil {
+		return nil
+	}
+	return invalid("want a JSON object or list of objects")
+}
+
+// log a successful delivery, with the size of the request body and how long the server took to respond
+func logDelivered(urlstr string, reqsize int, elapsed time.Duration, statusCode int, respbody []byte) {
+	golog.Infof("Notification -> %v sent (%v bytes in %v): %v %v", urlstr, reqsize, elapsed.Round(time.Millisecond), statusCode, string(respbody))
+}
+
+// remove a delivered task from the journal, archiving it first if ArchiveOnSuccess
+func (n *TattlerClientHTTP) completeTask(taskname string, result []byte) {
+	if n.ArchiveOnSuccess {
+		if err := n.archiveTask(taskname, result); err != nil {
+			golog.Errorf("Error archiving delivered task %v: '%v' (clearing it anyway)", taskname, err)
+		}
+	}
+	// the notification was delivered, so failing to clear it is not reported to the caller, regardless of Delivery
+	if err := n.ClearTask(taskname); err != nil {
+		golog.Warnf("Error clearing delivered task %v: '%v' (it will be delivered again upon replay)", taskname, err)
+	}
+}
+
+// archive key of the delivery of a correlationId by archives predating sentKeyPrefix
+func sentKey(correlationId string) string {
+	return fmt.Sprintf("sent_%x", sha256.Sum256([]byte(correlationId)))
+}
+
+// prefix of the archive keys of the deliveries of a correlationId, each followed by a unique suffix
+func sentKeyPrefix(correlationId string) string {
+	return sentKey(correlationId) + "_"
+}
+
+// record a delivered request into ArchiveDir by correlationId, if ArchiveSent. Failures are logged, as the notification
+// was delivered anyway.
+func (n *TattlerClientHTTP) archiveSent(urlstr string, body []byte, correlationId string) {
+	if !n.ArchiveSent {
+		return
+	}
+	if correlationId == "" {
+		golog.Warnf("Not archiving delivery to %v: correlationId unknown", urlstr)
+		return
+	}
+	archive, err := fscache.GetInstance(n.ArchiveDir)
+	if err == nil {
+		now := n.now()
+		meta := map[string]string{"url": urlstr, "sentAt": now.UTC().Format(time.RFC3339Nano)}
+		// deliveries sharing a correlationId, e.g. to several recipients or replayed, are each kept
+		err = archive.SetWithMetadata(sentKeyPrefix(correlationId)+newTaskName(now), body, meta)
+	}
+	if err != nil {
+		golog.Errorf("Error archiving delivery of correlationId %v: '%v'", correlationId, err)
+	}
+}
+
+// SentRecord is a delivery recorded into ArchiveDir by ArchiveSent.
+type SentRecord struct {
+	// URL requested
+	URL string
+	// Body of the request
+	Body []byte
+	// When the notification was delivered
+	SentAt time.Time
+}
+
+/*
+LookupSent returns the URL and body of the request which delivered a notification, and when it was delivered, as
+recorded into ArchiveDir by ArchiveSent. If several notifications were delivered with the same correlationId, e.g. by
+SendNotificationMulti or replays, the last one is returned; see LookupSentAll for all of them.
+
+LookupSent returns an error wrapping ErrNotArchived if no delivery with correlationId was recorded.
+*/
+func (n *TattlerClientHTTP) LookupSent(correlationId string) (string, []byte, time.Time, error) {
+	records, err := n.LookupSentAll(correlationId)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	last := records[len(records)-1]
+	return last.URL, last.Body, last.SentAt, nil
+}
+
+// LookupSentAll is like LookupSent, but returns all deliveries recorded with correlationId, oldest first. It lists
+// ArchiveDir, so it takes longer as the archive grows.
+func (n *TattlerClientHTTP) LookupSentAll(correlationId string) ([]SentRecord, error) {
+	if n.ArchiveDir == "" {
+		return nil, fmt.Errorf("cannot LookupSent(%v) because ArchiveDir is disabled", correlationId)
+	}
+	archive, err := fscache.GetInstance(n.ArchiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archive: %w", err)
+	}
+	keys := []string{sentKey(correlationId)}
+	prefix := sentKeyPrefix(correlationId)
+	err = archive.ListStream(func(key string) error {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+	var records []SentRecord
+	for _, key := range keys {
+		body, meta, ok := archive.GetWithMetadata(key)
+		if !ok || meta == nil {
+			continue
+		}
+		sentAt, err := time.Parse(time.RFC3339Nano, meta["sentAt"])
+		if err != nil {
+			return nil, fmt.Errorf("archived delivery of correlationId %v has unparseable time '%v': %w", correlationId, meta["sentAt"], err)
+		}
+		records = append(records, SentRecord{URL: meta["url"], Body: body, SentAt: sentAt})
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("cannot look up correlationId %v: %w", correlationId, ErrNotArchived)
+	}
+	slices.SortStableFunc(records, func(a, b SentRecord) int {
+		return a.SentAt.Compare(b.SentAt)
+	})
+	return records, nil
+}
+
+/*
+Copy a task into ArchiveDir, along with the server's response and the delivery time.
+
+Archived tasks are stored as cache keys named like their journalled counterparts, plus:
+- `{taskname}_result` -- whose content is the body of the server's response
+- `{taskname}_deliveredat` -- whose content is the delivery time, in RFC 3339 format
+*/
+func (n *TattlerClientHTTP) archiveTask(taskname string, result []byte) error {
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return fmt.Errorf("failed to load cache to archive task: %w", err)
+	}
+	archive, err := fscache.GetInstance(n.ArchiveDir)
+	if err != nil {
+		return fmt.Errorf("failed to load archive: %w", err)
+	}
+	for _, part := range []string{"url", "body", "meta"} {
+		kname := fmt.Sprintf("%v_%v", taskname, part)
+		if err := copyTaskPart(cache, archive, kname); err != nil {
+			return fmt.Errorf("failed to archive %v: %w", kname, err)
+		}
+	}
+	if result == nil {
+		result = []byte{}
+	}
+	if err := archive.Set(fmt.Sprintf("%v_result", taskname), result); err != nil {
+		return fmt.Errorf("failed to archive result of %v: %w", taskname, err)
+	}
+	deliveredAt := n.now().UTC().Format(time.RFC3339)
+	if err := archive.Set(fmt.Sprintf("%v_deliveredat", taskname), []byte(deliveredAt)); err != nil {
+		return fmt.Errorf("failed to archive delivery time of %v: %w", taskname, err)
+	}
+	golog.Infof("Task %v archived into %v", taskname, n.ArchiveDir)
+	return nil
+}
+
+/*
+Send a notification about an event to a recipient.
+
+Validate the undelying connection settings and send the notification. If vectors are omitted, they default to all available vectors for the user.
+If a non-empty correlationId is provided, it is passed on in the request to the Tattler server, else a new one is auto-generated.
+*/
+func (n *TattlerClientHTTP) SendNotification(recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error {
+	return n.SendNotificationContext(context.Background(), recipient, event_name, params, vectors, correlationId)
+}
+
+// SendNotificationContext is like SendNotification, but gives up waiting for a MaxConcurrent slot or for the server's response when ctx is done.
+func (n *TattlerClientHTTP) SendNotificationContext(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error {
+	_, err := n.SendNotificationOptions(ctx, recipient, event_name, params, vectors, correlationId, SendOptions{})
+	return err
+}
+
+// SendOptions holds settings applying to a single notification, as opposed to the whole client.
+type SendOptions struct {
+	// Ask Tattler server to deliver to this address instead of its configured debug address. Only allowed in mode "debug".
+	DebugOverrideAddress string
+	// Do not journal this notification, even if PersistencyDir is set.
+	SkipPersistency bool
+	// How Tattler server should interpret the recipient; defaults to RecipientUserID.
+	RecipientType RecipientType
+	// Send no correlationId, instead of generating one when none is given, so Tattler server assigns it. The assigned id is
+	// reported in NotificationResult.CorrelationId, if the server's response carries it. Cannot be combined with a correlationId.
+	ServerCorrelationId bool
+	// Name of a template variant for Tattler server to render the event with instead of its default, e.g. a seasonal
+	// design; nil for the default. Must match [A-Za-z0-9_-]+, so an explicitly empty name is rejected.
+	TemplateOverride *string
+	// Whether the notification is held back during QuietHours; defaults to PriorityNormal, which is.
+	Priority Priority
+	// Timezone of the recipient, to apply QuietHours in instead of QuietHours.Location.
+	RecipientLocation *time.Location
+	// Sender to deliver as, e.g. "Brand <noreply@brand.example>", instead of the one configured on Tattler server; sent as
+	// param FromParam. Must be a valid email address if the notification may go by email, i.e. for vectors including
+	// email, or none.
+	From string
+	// Address for replies to go to, sent as param ReplyToParam; validated like From.
+	ReplyTo string
+	// Mark the notification unsafe to send more than once, e.g. because its event has side effects on the server. It is
+	// attempted once, without failing over to FailoverEndpoints. If persistency is enabled, its task is journaled as
+	// usual, but ReplayOutstandingTasks leaves it alone, so failures remain for inspection with LoadTask and for explicit
+	// ReplayTask. Tasks deferred by QuietHours were not attempted yet, so ReplayOutstandingTasks sends them once.
+	NotIdempotent bool
+	// Labels for Tattler server to account the notification under, e.g. {"campaign": "spring24"} for analytics. They are
+	// sent as query params prefixed with LabelParamPrefix, apart from params, so templates do not see them. Keys must
+	// match [A-Za-z][A-Za-z0-9_]*. Labels do not tell notifications apart for DedupWindow.
+	Labels map[string]string
+
+	// ask Tattler server to render and deliver synchronously; set by SendSync
+	sync bool
+	// succeed if delivery fails but the task remains journaled; set by SendNotificationAndWait
+	durable bool
+}
+
+// SendNotificationOptions is like SendNotificationContext, but applies per-notification options and returns the server's result.
+func (n *TattlerClientHTTP) SendNotificationOptions(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions) (NotificationResult, error) {
+	return n.sendNotification(ctx, recipient, event_name, params, vectors, correlationId, opts)
+}
+
+/*
+SendNotificationAndWait is like SendNotificationContext, but returns nil only once the notification is either delivered,
+or durably journaled for replay: its task was written to PersistencyDir and flushed to disk along with its directory
+entry, before delivery was attempted, and remains there after delivery failed. Other sends do not flush their tasks. It returns error if neither is the case, e.g. if delivery and persisting both failed.
+
+Failed deliveries which leave the task journaled are logged, and left for ReplayOutstandingTasks. Notifications skipped
+by deduplication, pause or QuietHours return ErrDeduplicated, ErrNotificationsPaused and ErrSuppressedQuietHours as usual.
+*/
+func (n *TattlerClientHTTP) SendNotificationAndWait(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string) error {
+	_, err := n.sendNotification(ctx, recipient, event_name, params, vectors, correlationId, SendOptions{durable: true})
+	return err
+}
+
+// SendOutcome tells what a send did, beyond whether it succeeded.
+type SendOutcome int
+
+const (
+	// No request was issued, e.g. because the notification was invalid
+	OutcomeNotSent SendOutcome = iota
+	// A request was issued to Tattler server; see StatusCode and Err for how it went
+	OutcomeSent
+	// No request was issued, because an identical notification was delivered within DedupWindow
+	OutcomeDeduplicated
+	// The request failed without a response, and the notification was journalled for replay
+	OutcomePersisted
+)
+
+func (o SendOutcome) String() string {
+	switch o {
+	case OutcomeNotSent:
+		return "not-sent"
+	case OutcomeSent:
+		return "sent"
+	case OutcomeDeduplicated:
+		return "deduplicated"
+	case OutcomePersisted:
+		return "persisted-only"
+	}
+	return fmt.Sprintf("SendOutcome(%d)", int(o))
+}
+
+// NotificationResult describes the outcome of delivering one notification.
+type NotificationResult struct {
+	// What the send did
+	Outcome SendOutcome
+	// HTTP status code returned by Tattler server; 0 if no response was received
+	StatusCode int
+	// Raw body of Tattler server's response
+	Body []byte
+	// Correlation id of the notification, as given, generated by the client, or assigned by Tattler server with
+	// SendOptions.ServerCorrelationId; also set if delivery failed or was deferred, unless the server was to assign it
+	CorrelationId string
+	// Vectors requested but dropped as invalid, per VectorPolicyDrop; see CheckVectors
+	DroppedVectors []string
+	// Ids Tattler server assigned to the delivery on each vector, by vector, if its response carries them; see GetDeliveryStatus
+	DeliveryIds map[string]string
+	// Phases of the last request issued to deliver the notification, if TraceTiming is set; nil otherwise or if no request
+	// was issued
+	Timing *RequestTiming
+	// Reason why delivery failed; nil upon success
+	Err error
+}
+
+func (n *TattlerClientHTTP) sendNotification(ctx context.Context, recipient string, event_name string, params map[string]string, vectors []string, correlationId string, opts SendOptions) (NotificationResult, error) {
+	dedupKey := n.dedupKey(recipient, event_name, params)
+	if n.isDuplicate(dedupKey) {
+		golog.Infof("Notification %v to %v already delivered within %v; skipping", event_name, recipient, n.DedupWindow)
+		return NotificationResult{Outcome: OutcomeDeduplicated}, ErrDeduplicated
+	}
+	if n.IsPaused() {
+		return n.suppressPaused(recipient, event_name, params, vectors, correlationId, opts)
+	}
+	if n.QuietHours != nil && opts.Priority == PriorityNormal {
+		if until, quiet := n.QuietHours.until(n.now(), opts.RecipientLocation); quiet {
+			return n.suppressQuiet(recipient, event_name, params, vectors, correlationId, opts, until)
+		}
+	}
+	if n.DrainBeforeSend {
+		n.drainJournal()
+	}
+	urlstr, body, taskname, berr := n.prepareNotification(recipient, event_name, params, vectors, correlationId, opts, true)
+	if berr != nil {
+		return NotificationResult{}, fmt.Errorf("failed to prepare tattler request: %w", berr)
+	}
+	// flush the task before attempting delivery, so it survives whatever happens next
+	durable := false
+	if opts.durable && taskname != "" {
+		if err := n.syncTask(taskname); err != nil {
+			golog.Errorf("Error flushing task %v to disk: '%v' (delivery failures will not be covered by it)", taskname, err)
+		} else {
+			durable = true
+		}
+	}
+	result, err := n.deliver(ctx, urlstr, body, taskname, !opts.NotIdempotent)
+	n.releaseClaim(taskname)
+	_, result.DroppedVectors = n.CheckVectors(vectors)
+	if result.CorrelationId == "" {
+		// not delivered, but traceable by the id it was sent with
+		result.CorrelationId = n.sentCorrelationId(urlstr, body)
+	}
+	if err == nil {
+		n.markDelivered(dedupKey)
+	} else if durable && n.isJournaled(taskname) {
+		golog.Warnf("Notification %v to %v failed, but is journaled as task %v for replay: %v", event_name, recipient, taskname, err)
+		result.Err = err
+		return result, nil
+	}
+	return result, err
+}
+
+// replay the oldest journaled tasks ahead of a send, per DrainBeforeSend
+func (n *TattlerClientHTTP) drainJournal() {
+	if err := n.ensureValid(); err != nil {
+		// the send reports it
+		return
+	}
+	res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTasks: n.DrainMaxTasks, StopAtFailure: true})
+	if err != nil {
+		golog.Warnf("Failed to drain journal before send: %v", err)
+	} else if res.Failed > 0 {
+		golog.Warnf("Draining journal before send left %v tasks after a failure", res.Skipped)
+	}
+}
+
+// flush the parts of a journaled task to stable storage
+func (n *TattlerClientHTTP) syncTask(taskname string) error {
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return err
+	}
+	for _, part := range []string{"url", "body", "meta"} {
+		if err := cache.Sync(fmt.Sprintf("%v_%v", taskname, part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// whether a task is in the journal
+func (n *TattlerClientHTTP) isJournaled(taskname string) bool {
+	if taskname == "" {
+		return false
+	}
+	cache, err := n.persistencyCache()
+	return err == nil && cache.Exists(fmt.Sprintf("%v_url", taskname))
+}
+
+// cache key marking delivery of a notification, or "" if deduplication is disabled
+func (n *TattlerClientHTTP) dedupKey(recipient string, event_name string, params map[string]string) string {
+	if n.DedupWindow <= 0 || !n.persists() {
+		return ""
+	}
+	// json.Marshal sorts map keys, so equal params hash equally
+	jparams, _ := json.Marshal(params)
+	jkey, _ := json.Marshal([]string{n.Scope, strings.TrimSpace(event_name), strings.TrimSpace(recipient), string(jparams)})
+	return fmt.Sprintf("%v%x", dedupKeyPrefix, sha256.Sum256(jkey))
+}
+
+// prefix of the cache keys marking delivery of notifications
+const dedupKeyPrefix = "dedup_"
+
+// whether dedupKey was marked delivered within DedupWindow
+func (n *TattlerClientHTTP) isDuplicate(dedupKey string) bool {
+	if dedupKey == "" {
+		return false
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		golog.Warnf("Failed to load cache to check for duplicates (sending anyway): %v", err)
+		return false
+	}
+	return cache.GetExpiry(dedupKey, n.DedupWindow) != nil
+}
+
+func (n *TattlerClientHTTP) markDelivered(dedupKey string) {
+	if dedupKey == "" {
+		return
+	}
+	cache, err := n.persistencyCache()
+	if err == nil {
+		err = cache.Set(dedupKey, []byte(n.now().UTC().Format(time.RFC3339)))
+	}
+	if err != nil {
+		golog.Warnf("Failed to mark notification delivered for deduplication: %v", err)
+	}
+}
+
+// remove the marks of deliveries older than DedupWindow, which no longer deduplicate anything
+func (n *TattlerClientHTTP) clearExpiredMarks(cache *fscache.FSCache) {
+	if n.DedupWindow <= 0 {
+		return
+	}
+	cleared, err := cache.ClearExpiredPrefix(dedupKeyPrefix, n.DedupWindow)
+	if err != nil {
+		golog.Warnf("Failed to clear expired deduplication marks: %v", err)
+	}
+	golog.Debugf("Cleared %v deduplication marks older than %v", len(cleared), n.DedupWindow)
+}
+
+// send a prepared request, failing over to FailoverEndpoints in turn if needed and failover is set, and complete taskname
+// upon success unless empty. The task is kept only if all endpoints fail.
+func (n *TattlerClientHTTP) deliver(ctx context.Context, urlstr string, body []byte, taskname string, failover bool) (NotificationResult, error) {
+	var result NotificationResult
+	var err error
+	targets := []string{urlstr}
+	if failover {
+		targets = n.failoverURLs(urlstr)
+	}
+	for i, target := range targets {
+		if i > 0 {
+			golog.Warnf("Failing over to %v after: %v", target, err)
+		}
+		result, err = n.deliverTo(ctx, target, body, taskname, i > 0)
+		if err == nil || ctx.Err() != nil || !failsOver(err) {
+			break
+		}
+	}
+	n.recordHealth(err)
+	return result, err
+}
+
+// URLs to attempt a request at: urlstr itself, then urlstr moved onto each of FailoverEndpoints if it targets Endpoint
+func (n *TattlerClientHTTP) failoverURLs(urlstr string) []string {
+	urls := []string{urlstr}
+	relurl, ok := strings.CutPrefix(urlstr, n.Endpoint+"/")
+	if !ok {
+		return urls
+	}
+	for _, endpoint := range n.FailoverEndpoints {
+		urls = append(urls, normalizeEndpoint(endpoint)+"/"+relurl)
+	}
+	return urls
+}
+
+// send a prepared request to one URL, and complete taskname upon success unless empty. failover tells urlstr is on one
+// of FailoverEndpoints.
+func (n *TattlerClientHTTP) deliverTo(ctx context.Context, urlstr string, body []byte, taskname string, failover bool) (NotificationResult, error) {
+	request, client := n.prepareHTTPRequest(urlstr, body)
+	var tracer *timingTracer
+	if n.TraceTiming {
+		tracer = &timingTracer{}
+		ctx = tracer.withTrace(ctx)
+	}
+	resp, respbody, elapsed, resperr := n.roundTrip(ctx, request, client)
+	var timing *RequestTiming
+	if tracer != nil {
+		timing = tracer.result(elapsed)
+	}
+	if resperr != nil {
+		result := NotificationResult{Outcome: OutcomeNotSent, Timing: timing}
+		if taskname != "" {
+			result.Outcome = OutcomePersisted
+		}
+		return result, requestError(urlstr, resperr)
+	}
+	result := NotificationResult{Outcome: OutcomeSent, StatusCode: resp.StatusCode, Body: respbody, Timing: timing}
+	if err := n.processResponse(resp.StatusCode, resp.Status, resp.Header, urlstr, respbody, taskname, failover); err != nil {
+		return result, err
+	}
+	logDelivered(urlstr, len(body), elapsed, resp.StatusCode, respbody)
+	result.CorrelationId = deliveredCorrelationId(n.sentCorrelationId(urlstr, body), respbody)
+	result.DeliveryIds = deliveryIds(respbody)
+	n.archiveSent(urlstr, body, result.CorrelationId)
+	return result, nil
+}
+
+// returns a *CorrelationMismatchError if VerifyCorrelationEcho and the response echoes a correlationId other than the
+// one sent to urlstr, else nil
+func (n *TattlerClientHTTP) checkCorrelationEcho(urlstr string, header http.Header) error {
+	echoed := header.Get(CorrelationIdHeader)
+	if !n.VerifyCorrelationEcho || echoed == "" {
+		return nil
+	}
+	var sent string
+	if requrl, err := url.Parse(urlstr); err == nil {
+		sent = requrl.Query().Get("correlationId")
+	}
+	if sent == "" {
+		// the server assigns it, so there is nothing to compare against
+		return nil
+	}
+	if echoed != sent {
+		return &CorrelationMismatchError{URL: urlstr, Sent: sent, Echoed: echoed}
+	}
+	return nil
+}
+
+// correlation id a request to urlstr with body was sent with, in its query or else in its body per CorrelationIdPlacement;
+// empty if none
+func (n *TattlerClientHTTP) sentCorrelationId(urlstr string, body []byte) string {
+	if requrl, err := url.Parse(urlstr); err == nil && requrl.Query().Get("correlationId") != "" {
+		return requrl.Query().Get("correlationId")
+	}
+	if n.CorrelationIdPlacement == CorrelationIdInBody {
+		var params map[string]string
+		if json.Unmarshal(body, &params) == nil {
+			return params[n.CorrelationIdBodyKey]
+		}
+	}
+	return ""
+}
+
+// correlation id of a delivered request: sent, if it was sent with one, or else the first one found in the server's
+// response, which is assumed to be a JSON object or list of objects with a "correlationId" attribute
+func deliveredCorrelationId(sent string, respbody []byte) string {
+	if sent != "" {
+		return sent
+	}
+	type assignment struct {
+		CorrelationId string `json:"correlationId"`
+	}
+	var single assignment
+	if json.Unmarshal(respbody, &single) == nil && single.CorrelationId != "" {
+		return single.CorrelationId
+	}
+	var multi []assignment
+	if json.Unmarshal(respbody, &multi) == nil {
+		for _, a := range multi {
+			if a.CorrelationId != "" {
+				return a.CorrelationId
+			}
+		}
+	}
+	golog.Debugf("Tattler response carries no correlationId: '%v'", string(respbody))
+	return ""
+}
+
+// perform a request and read its response body, holding a MaxConcurrent slot throughout.
+// Also return how long the request took, excluding any wait for a slot.
+func (n *TattlerClientHTTP) roundTrip(ctx context.Context, request *http.Request, client *http.Client) (*http.Response, []byte, time.Duration, error) {
+	request = request.WithContext(ctx)
+	if n.RequestInterceptor != nil {
+		if err := n.RequestInterceptor(request); err != nil {
+			return nil, nil, 0, fmt.Errorf("request interceptor failed: %w", err)
+		}
+	}
+	if err := n.awaitRateLimit(ctx); err != nil {
+		return nil, nil, 0, err
+	}
+	if sem := n.runtimeState().sem; sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return nil, nil, 0, fmt.Errorf("gave up waiting for a free request slot: %w", ctx.Err())
+		}
+	}
+	tstart := time.Now()
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, nil, time.Since(tstart), err
+	}
+	defer resp.Body.Close()
+
+	state := n.runtimeState()
+	state.mux.Lock()
+	state.lastProto = resp.Proto
+	state.mux.Unlock()
+	n.recordRateLimit(resp.Header)
+
+	respbody, _ := io.ReadAll(resp.Body)
+	return resp, respbody, time.Since(tstart), nil
+}
+
+// number of hex digits naming PersistencyDir subdirectories if ShardPersistency is set, i.e. 256 subdirectories
+const persistencyShardLen = 2
+
+// whether tasks are persisted, into either PersistencyDir or PersistencyStorage
+func (n *TattlerClientHTTP) persists() bool {
+	return n.PersistencyDir != "" || n.PersistencyStorage != nil
+}
+
+// cache of PersistencyDir or PersistencyStorage, sharded if so configured. Kept on the client once resolved, until its settings change.
+func (n *TattlerClientHTTP) persistencyCache() (*fscache.FSCache, error) {
+	state := n.runtimeState()
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	if state.persistency != nil && state.persistencyDir == n.PersistencyDir && state.persistencySharded == n.ShardPersistency {
+		return state.persistency, nil
+	}
+	var cache *fscache.FSCache
+	var err error
+	storage := n.PersistencyStorage
+	if storage == nil && (n.Clock != nil || n.PersistencyFileMode != 0) {
+		// the instance shared by clients of PersistencyDir must not run on this client's Clock or file mode
+		storage = fscache.DirStorage(n.PersistencyDir)
+	}
+	if storage != nil && n.ShardPersistency {
+		cache, err = fscache.NewShardedWithStorage(storage, persistencyShardLen)
+	} else if storage != nil {
+		cache, err = fscache.NewWithStorage(storage)
+	} else if n.ShardPersistency {
+		cache, err = fscache.GetShardedInstance(n.PersistencyDir, persistencyShardLen)
+	} else {
+		cache, err = fscache.GetInstance(n.PersistencyDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n.PersistencyFileMode != 0 {
+		if err := cache.SetFileMode(n.PersistencyFileMode); err != nil {
+			return nil, fmt.Errorf("failed to apply PersistencyFileMode: %w", err)
+		}
+	}
+	if n.Clock != nil {
+		cache.SetClock(n.Clock)
+	}
+	state.persistency, state.persistencyDir, state.persistencySharded = cache, n.PersistencyDir, n.ShardPersistency
+	return cache, nil
+}
+
+// MigratePersistency moves all journaled tasks and delivery marks from oldDir into newDir, e.g. upon relocating a volume.
+//
+// Clients should be pointed at newDir afterwards, and must not send or replay notifications on either directory meanwhile.
+// Directories sharded by ShardPersistency are detected as such, and migrated into a directory to use with it alike.
+// MigratePersistency returns the number of files moved, and an error listing any file which could not be.
+func MigratePersistency(oldDir string, newDir string) (int, error) {
+	moved, err := fscache.Migrate(oldDir, newDir)
+	golog.Infof("Migrated %v files of persisted tasks from %v to %v", moved, oldDir, newDir)
+	if err != nil {
+		return moved, fmt.Errorf("failed to migrate persisted tasks from %v to %v: %w", oldDir, newDir, err)
+	}
+	return moved, nil
+}
+
+func (n *TattlerClientHTTP) PersistTask(requrl string, reqbody []byte) (string, error) {
+	return n.persistTask(requrl, reqbody, taskMeta{Method: notificationMethod, Header: n.requestHeader()}, false)
+}
+
+// journal a task, and return its name. If claim is set, the task is claimed before it shows, so replays leave it to the
+// caller, which must release it with releaseClaim.
+func (n *TattlerClientHTTP) persistTask(requrl string, reqbody []byte, meta taskMeta, claim bool) (string, error) {
+	if !n.persists() {
+		golog.Debug("Not persisting task because PersistencyDir empty.")
+		return "", nil
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return "", fmt.Errorf("failed to load cache to persist task: %w", err)
+	}
+	taskname := newTaskName(n.now())
+	if claim {
+		claimkname := fmt.Sprintf("%v_claim", taskname)
+		if claimed, err := cache.SetIfAbsent(claimkname, []byte(n.now().UTC().Format(time.RFC3339))); err != nil || !claimed {
+			return "", fmt.Errorf("failed to claim new task %v (err=%v)", taskname, err)
+		}
+	}
+	urlkname := fmt.Sprintf("%v_url", taskname)
+	urlerr := cache.Set(urlkname, []byte(requrl))
+	if urlerr != nil {
+		n.releaseClaim(taskname)
+		return "", fmt.Errorf("failed to persist request URL part into %v: %w", urlkname, urlerr)
+	}
+	bodykname := fmt.Sprintf("%v_body", taskname)
+	bodyerr := n.setTaskPart(cache, bodykname, reqbody)
+	if bodyerr != nil {
+		n.releaseClaim(taskname)
+		return "", fmt.Errorf("failed to persist request body part into %v: %w", bodykname, bodyerr)
+	}
+	// cannot fail, because taskMeta is always convertible
+	metadata, _ := json.Marshal(meta)
+	metakname := fmt.Sprintf("%v_meta", taskname)
+	metaerr := cache.Set(metakname, metadata)
+	if metaerr != nil {
+		n.releaseClaim(taskname)
+		return "", fmt.Errorf("failed to persist request meta part into %v: %w", metakname, metaerr)
+	}
+	golog.Infof("Task journalled successfully with keys=%v_{url, body, meta}", taskname)
+	return taskname, nil
+}
+
+// claims held for longer than this many Timeouts per endpoint are deemed left behind by a crashed process
+const staleClaimTimeouts = 10
+
+// claim taskname for delivery, taking over a stale claim; false if another send or replay holds it
+func (n *TattlerClientHTTP) claimTask(cache *fscache.FSCache, taskname string) (bool, error) {
+	claimkname := fmt.Sprintf("%v_claim", taskname)
+	now := n.now()
+	claimed, err := cache.SetIfAbsent(claimkname, []byte(now.UTC().Format(time.RFC3339)))
+	if err != nil || claimed {
+		return claimed, err
+	}
+	timeout := cmp.Or(n.Timeout, DefaultTimeout)
+	ttl := staleClaimTimeouts * timeout * time.Duration(1+len(n.FailoverEndpoints))
+	claimdata := cache.Get(claimkname)
+	if claimdata == nil {
+		// released meanwhile
+		return cache.SetIfAbsent(claimkname, []byte(now.UTC().Format(time.RFC3339)))
+	}
+	fresh := cache.GetExpiry(claimkname, ttl) != nil
+	if since, err := time.Parse(time.RFC3339, string(claimdata)); err == nil {
+		fresh = now.Sub(since) <= ttl
+	}
+	if fresh {
+		return false, nil
+	}
+	golog.Warnf("Taking over claim of task %v held since '%v', longer than any delivery takes (%v)", taskname, string(claimdata), ttl)
+	cache.Unset(claimkname)
+	return cache.SetIfAbsent(claimkname, []byte(now.UTC().Format(time.RFC3339)))
+}
+
+// release the claim a send took on its task by persistTask, once done delivering it
+func (n *TattlerClientHTTP) releaseClaim(taskname string) {
+	if taskname == "" {
+		return
+	}
+	if cache, err := n.persistencyCache(); err == nil {
+		cache.Unset(fmt.Sprintf("%v_claim", taskname))
+	}
+}
+
+// metadata of task parts stored compressed by CompressPersisted, and its value for gzip
+const (
+	taskPartEncoding = "encoding"
+	taskPartGzip     = "gzip"
+)
+
+// store a task part, gzip-compressed if CompressPersisted is set
+func (n *TattlerClientHTTP) setTaskPart(cache *fscache.FSCache, kname string, data []byte) error {
+	if !n.CompressPersisted {
+		return cache.Set(kname, data)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	// writes to a bytes.Buffer cannot fail
+	zw.Write(data)
+	zw.Close()
+	return cache.SetWithMetadata(kname, buf.Bytes(), map[string]string{taskPartEncoding: taskPartGzip})
+}
+
+// read a task part, decompressing it if stored compressed; nil if missing or undecodable
+func readTaskPart(cache *fscache.FSCache, kname string) []byte {
+	data, meta, ok := cache.GetWithMetadata(kname)
+	if !ok || meta[taskPartEncoding] != taskPartGzip {
+		return data
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		golog.Warnf("Task part %v is not valid gzip: %v", kname, err)
+		return nil
+	}
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		golog.Warnf("Task part %v is not valid gzip: %v", kname, err)
+		return nil
+	}
+	return data
+}
+
+// copy a task part into another cache as stored, compressed or not
+func copyTaskPart(from *fscache.FSCache, to *fscache.FSCache, kname string) error {
+	data, meta, ok := from.GetWithMetadata(kname)
+	if ok && meta != nil {
+		return to.SetWithMetadata(kname, data, meta)
+	}
+	return to.Set(kname, data)
+}
+
+// taskMeta describes the parts of a journalled request which are not its URL or body
+type taskMeta struct {
+	Method string      `json:"method"`
+	Header http.Header `json:"header"`
+	// time before which replays leave the task alone, e.g. deferred past QuietHours
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// whether replays leave the task alone once attempted; see SendOptions.NotIdempotent
+	NotIdempotent bool `json:"notIdempotent,omitempty"`
+	// correlationId the task is sent with, whether given or generated; empty if the server assigns it
+	CorrelationId string `json:"correlationId,omitempty"`
+}
+
+// load the meta part of a journalled task, defaulting to POST with default headers if missing or unreadable
+func (n *TattlerClientHTTP) loadTaskMeta(cache *fscache.FSCache, taskname string) taskMeta {
+	meta := taskMeta{}
+	data := cache.Get(fmt.Sprintf("%v_meta", taskname))
+	if data != nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			golog.Warnf("Task %v has unreadable meta part (using defaults): %v", taskname, err)
+		}
+	}
+	if meta.Method == "" {
+		meta.Method = notificationMethod
+	}
+	if meta.Header == nil {
+		meta.Header = n.requestHeader()
+	}
+	return meta
+}
+
+func (n *TattlerClientHTTP) ClearTask(taskname string) error {
+	if taskname == "" {
+		golog.Debugf("Omitting clearing empty taskname.")
+		return nil
+	}
+	if !n.persists() {
+		golog.Warnf("Requested to ClearTask() when PersistencyDir disabled")
+		return fmt.Errorf("cannot ClearTask(%v) because PersistencyDir is disabled", taskname)
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return fmt.Errorf("failed to load cache to clear task %v: %w", taskname, err)
+	}
+	// the URL part names the task, so remove it first, and keep the task whole for replay if it cannot be removed
+	urlkname := fmt.Sprintf("%v_url", taskname)
+	cache.Unset(urlkname)
+	// Unset does not tell missing parts from parts failing to be removed
+	if cache.Exists(urlkname) {
+		return fmt.Errorf("failed to clear task %v from journal", taskname)
+	}
+	cache.Unset(fmt.Sprintf("%v_body", taskname))
+	cache.Unset(fmt.Sprintf("%v_meta", taskname))
+	golog.Infof("Task %v successfully cleared from journal.", taskname)
+	return nil
+}
+
+// PendingNotification is the logical content of a persisted task.
+type PendingNotification struct {
+	Scope         string
+	EventName     string
+	Recipient     string
+	RecipientType RecipientType
+	Mode          string
+	Vectors       []string
+	CorrelationId string
+	Params        map[string]string
+	NotIdempotent bool
+	// Name of the task LoadTask loaded the notification from; empty if built by hand
+	Task string
+}
+
+// LoadTask reads a persisted task back into the notification it describes.
+//
+// LoadTask returns error if the task does not exist, or its parts cannot be parsed.
+func (n *TattlerClientHTTP) LoadTask(taskname string) (*PendingNotification, error) {
+	if !n.persists() {
+		return nil, fmt.Errorf("cannot LoadTask(%v) because PersistencyDir is disabled", taskname)
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache to load task %v: %w", taskname, err)
+	}
+	urldata := cache.Get(fmt.Sprintf("%v_url", taskname))
+	bodydata := readTaskPart(cache, fmt.Sprintf("%v_body", taskname))
+	if urldata == nil || bodydata == nil {
+		return nil, fmt.Errorf("task %v not found or incomplete", taskname)
+	}
+	requrl, urlerr := url.Parse(string(urldata))
+	if urlerr != nil {
+		return nil, fmt.Errorf("task %v has unparseable URL '%v': %w", taskname, string(urldata), urlerr)
+	}
+	// path ends with .../{scope}/{event_name}/, unless scope was sent in a header
+	pathParts := strings.Split(strings.Trim(requrl.Path, "/"), "/")
+	if len(pathParts) < 2 {
+		return nil, fmt.Errorf("task %v has URL path '%v' lacking scope and event", taskname, requrl.Path)
+	}
+	scope := pathParts[len(pathParts)-2]
+	meta := n.loadTaskMeta(cache, taskname)
+	if n.ScopeInHeader {
+		if headerScope := meta.Header.Get(n.scopeHeader()); headerScope != "" {
+			scope = headerScope
+		}
+	}
+	query := requrl.Query()
+	pn := &PendingNotification{
+		Scope:         scope,
+		EventName:     pathParts[len(pathParts)-1],
+		Mode:          query.Get("mode"),
+		CorrelationId: query.Get("correlationId"),
+		NotIdempotent: meta.NotIdempotent,
+		Task:          taskname,
+	}
+	if pn.CorrelationId == "" {
+		// e.g. sent in body per CorrelationIdPlacement
+		pn.CorrelationId = meta.CorrelationId
+	}
+	for rtype, param := range recipientQueryParams {
+		if query.Has(param) {
+			pn.Recipient, pn.RecipientType = query.Get(param), rtype
+		}
+	}
+	if query.Get("vector") != "" {
+		pn.Vectors = strings.Split(query.Get("vector"), ",")
+	}
+	if err := json.Unmarshal(bodydata, &pn.Params); err != nil {
+		return nil, fmt.Errorf("task %v has unparseable body: %w", taskname, err)
+	}
+	return pn, nil
+}
+
+/*
+SendPending sends the notification pn describes, e.g. as built by hand or loaded with LoadTask, like
+SendNotificationContext would: it is journaled and cleared per the client's persistency. Scope and Mode, if set,
+override the client's for this notification, and are validated like the client's configuration.
+
+If pn was loaded with LoadTask, its task is sent in place of a new one: it is claimed while sending, and completed
+upon success like ReplayTask does, so the notification is not delivered again by a later replay. SendPending returns
+error if the task is claimed by a replay or another send.
+*/
+func (n *TattlerClientHTTP) SendPending(ctx context.Context, pn PendingNotification) error {
+	client := n
+	scope, mode := strings.TrimSpace(pn.Scope), strings.TrimSpace(pn.Mode)
+	if (scope != "" && scope != n.Scope) || (mode != "" && mode != n.Mode) {
+		if mode != "" && !n.IsValidMode(mode) {
+			return fmt.Errorf("invalid mode '%v' requested out of supported '%v'", mode, n.allowedModes())
+		}
+		override := *n
+		if scope != "" {
+			override.Scope = scope
+		}
+		if mode != "" {
+			override.Mode = mode
+		}
+		// a sealed client skips validation upon send, so validate the override here
+		if err := override.Revalidate(); err != nil {
+			return fmt.Errorf("validating configuration failed: %w", err)
+		}
+		client = &override
+	}
+	opts := SendOptions{RecipientType: pn.RecipientType, NotIdempotent: pn.NotIdempotent}
+	if pn.Task == "" || !n.persists() {
+		_, err := client.sendNotification(ctx, pn.Recipient, pn.EventName, pn.Params, pn.Vectors, pn.CorrelationId, opts)
+		return err
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return fmt.Errorf("failed to load cache to send task %v: %w", pn.Task, err)
+	}
+	claimkname := fmt.Sprintf("%v_claim", pn.Task)
+	claimed, claimerr := n.claimTask(cache, pn.Task)
+	if claimerr != nil {
+		return fmt.Errorf("failed to claim task %v: %w", pn.Task, claimerr)
+	} else if !claimed {
+		return fmt.Errorf("cannot send task %v: claimed by another replay", pn.Task)
+	}
+	defer cache.Unset(claimkname)
+	// check after claiming, as a replay may have completed the task meanwhile
+	if !cache.Exists(fmt.Sprintf("%v_url", pn.Task)) {
+		return fmt.Errorf("cannot send task %v: %w", pn.Task, ErrTaskNotFound)
+	}
+	// the task journals the notification already
+	opts.SkipPersistency = true
+	result, err := client.sendNotification(ctx, pn.Recipient, pn.EventName, pn.Params, pn.Vectors, pn.CorrelationId, opts)
+	if err != nil {
+		return err
+	}
+	n.completeTask(pn.Task, result.Body)
+	return nil
+}
+
+// TaskProblem describes why a persisted task is malformed, as reported by VerifyPersisted.
+type TaskProblem struct {
+	// Name of the task, as for LoadTask
+	Task string
+	// What is wrong with it
+	Problem string
+}
+
+/*
+VerifyPersisted scans persisted tasks for problems which would make their replay fail, e.g. after a crash, without
+modifying anything. It checks that each task has both URL and body parts, that its URL is absolute and carries mode
+and recipient, that its body is valid JSON, and that its meta part, if any, is readable.
+
+VerifyPersisted returns problems sorted by task, several per task if so; or error if the tasks cannot be scanned.
+*/
+func (n *TattlerClientHTTP) VerifyPersisted() ([]TaskProblem, error) {
+	if !n.persists() {
+		return nil, fmt.Errorf("cannot verify tasks because PersistencyDir is disabled")
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache to verify tasks: %w", err)
+	}
+	parts := map[string]map[string]bool{}
+	err = cache.ListStream(func(key string) error {
+		sep := strings.LastIndex(key, "_")
+		if sep == -1 || strings.HasPrefix(key, dedupKeyPrefix) {
+			return nil
+		}
+		taskname, part := key[:sep], key[sep+1:]
+		if part != "url" && part != "body" && part != "meta" {
+			return nil
+		}
+		if parts[taskname] == nil {
+			parts[taskname] = map[string]bool{}
+		}
+		parts[taskname][part] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persisted tasks: %w", err)
+	}
+	tasknames := make([]string, 0, len(parts))
+	for taskname := range parts {
+		tasknames = append(tasknames, taskname)
+	}
+	slices.Sort(tasknames)
+
+	var problems []TaskProblem
+	for _, taskname := range tasknames {
+		report := func(format string, args ...any) {
+			problems = append(problems, TaskProblem{Task: taskname, Problem: fmt.Sprintf(format, args...)})
+		}
+		if !parts[taskname]["url"] {
+			report("URL part missing")
+		} else if problem := verifyTaskURL(string(cache.Get(fmt.Sprintf("%v_url", taskname)))); problem != "" {
+			report("%v", problem)
+		}
+		if !parts[taskname]["body"] {
+			report("body part missing")
+		} else if body := readTaskPart(cache, fmt.Sprintf("%v_body", taskname)); body == nil {
+			report("body part unreadable")
+		} else if !json.Valid(body) {
+			report("body is not valid JSON")
+		}
+		if parts[taskname]["meta"] {
+			var meta taskMeta
+			if err := json.Unmarshal(cache.Get(fmt.Sprintf("%v_meta", taskname)), &meta); err != nil {
+				report("meta part unparseable: %v", err)
+			}
+		}
+	}
+	golog.Infof("Verified %v persisted tasks: %v problems", len(tasknames), len(problems))
+	return problems, nil
+}
+
+// problem with the URL of a persisted task, or "" if it can be replayed
+func verifyTaskURL(urlstr string) string {
+	requrl, err := url.ParseRequestURI(urlstr)
+	if err != nil {
+		return fmt.Sprintf("URL '%v' unparseable: %v", urlstr, err)
+	} else if requrl.Scheme == "" || requrl.Host == "" {
+		return fmt.Sprintf("URL '%v' is not absolute", urlstr)
+	} else if len(strings.Split(strings.Trim(requrl.Path, "/"), "/")) < 2 {
+		return fmt.Sprintf("URL path '%v' lacks scope and event", requrl.Path)
+	}
+	query := requrl.Query()
+	if query.Get("mode") == "" {
+		return fmt.Sprintf("URL '%v' lacks mode", urlstr)
+	}
+	for _, param := range recipientQueryParams {
+		if query.Get(param) != "" {
+			return ""
+		}
+	}
+	return fmt.Sprintf("URL '%v' lacks recipient", urlstr)
+}
+
+// iterate over persisted tasks and request delivery to tattler.
+// Tasks older than maxAge are ignored.
+// Tasks are replayed oldest first.
+// Tasks claimed by another replay running on the same PersistencyDir are ignored too; see replay claims in the package doc.
+// So are tasks deferred past QuietHours, until these end.
+// Tasks that could be successfully delivered are discarded unless removeDone is set to false.
+// Returns the number of tasks found, sent, ignored. Or non-nil error upon failure
+func (n *TattlerClientHTTP) ReplayOutstandingTasks(maxAge time.Duration, removeDone bool) (uint, uint, uint, error) {
+	res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: maxAge, KeepDone: !removeDone})
+	return res.Found, res.Replayed, res.TooOld + res.Skipped, err
+}
+
+// ExpiredTaskAction tells what replay does with tasks older than ReplayOptions.MaxTaskAge.
+type ExpiredTaskAction int
+
+const (
+	// Leave expired tasks in PersistencyDir
+	ExpiredTaskKeep ExpiredTaskAction = iota
+	// Delete expired tasks
+	ExpiredTaskDelete
+	// Move expired tasks into ReplayOptions.DeadLetterDir
+	ExpiredTaskDeadLetter
+)
+
+// ReplayOptions controls ReplayOutstandingTasksOptions.
+type ReplayOptions struct {
+	// Do not replay tasks persisted longer ago than this; 0 means no limit.
+	MaxTaskAge time.Duration
+	// What to do with tasks older than MaxTaskAge; defaults to ExpiredTaskKeep.
+	ExpiredAction ExpiredTaskAction
+	// Folder to move expired tasks into, if ExpiredAction is ExpiredTaskDeadLetter; must differ from PersistencyDir.
+	DeadLetterDir string
+	// Keep tasks in PersistencyDir after delivering them.
+	KeepDone bool
+	// Stop after attempting to deliver this many tasks, oldest first; 0 means no limit. Tasks left count as Skipped.
+	MaxTasks int
+	// Stop after the first task failing to deliver, e.g. as the server is likely down. Tasks left count as Skipped.
+	StopAtFailure bool
+}
+
+// ReplayResult counts what ReplayOutstandingTasksOptions did with persisted tasks.
+type ReplayResult struct {
+	// Tasks found in PersistencyDir
+	Found uint
+	// Tasks delivered
+	Replayed uint
+	// Tasks not replayed because older than MaxTaskAge
+	TooOld uint
+	// Tasks not replayed because incomplete, claimed by another replay, or deferred past QuietHours not yet over
+	Skipped uint
+	// Tasks whose delivery failed
+	Failed uint
+}
+
+/*
+ReplayOutstandingTasksOptions is like ReplayOutstandingTasks, but can dispose of tasks too old to replay, and counts each outcome apart.
+
+The age of a task is taken from the modification time of its URL part. Expired tasks are handled per opts.ExpiredAction;
+failing to do so is logged, and leaves the task in place.
+*/
+func (n *TattlerClientHTTP) ReplayOutstandingTasksOptions(opts ReplayOptions) (ReplayResult, error) {
+	var res ReplayResult
+	if !n.persists() {
+		return res, fmt.Errorf("cannot replay tasks because PersistencyDir is disabled")
+	}
+	if err := n.ensureValid(); err != nil {
+		return res, fmt.Errorf("validating configuration failed: %w", err)
+	}
+	if n.IsPaused() {
+		return res, fmt.Errorf("cannot replay tasks: %w", ErrNotificationsPaused)
+	}
+	if opts.ExpiredAction < ExpiredTaskKeep || opts.ExpiredAction > ExpiredTaskDeadLetter {
+		return res, fmt.Errorf("invalid ExpiredAction=%v", opts.ExpiredAction)
+	} else if opts.MaxTasks < 0 {
+		return res, fmt.Errorf("invalid MaxTasks=%v < 0", opts.MaxTasks)
+	}
+	var deadLetters *fscache.FSCache
+	if opts.ExpiredAction == ExpiredTaskDeadLetter {
+		if opts.DeadLetterDir == "" || path.Clean(opts.DeadLetterDir) == path.Clean(n.PersistencyDir) {
+			return res, fmt.Errorf("ExpiredTaskDeadLetter requires a DeadLetterDir other than PersistencyDir, have '%v'", opts.DeadLetterDir)
+		}
+		var err error
+		if deadLetters, err = fscache.GetInstance(opts.DeadLetterDir); err != nil {
+			return res, fmt.Errorf("failed to load dead letter cache: %w", err)
+		}
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return res, fmt.Errorf("failed to load cache to replay tasks: %w", err)
+	}
+	n.clearExpiredMarks(cache)
+	// only URL parts name tasks, so keep just those in memory
+	var keys []string
+	err = cache.ListStream(func(key string) error {
+		if strings.HasSuffix(key, "_url") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to list persisted tasks: %w", err)
+	}
+	tasks := tasksByCreation(cache, keys)
+	for i, taskname := range tasks {
+		if (opts.MaxTasks > 0 && res.Replayed+res.Failed >= uint(opts.MaxTasks)) || (opts.StopAtFailure && res.Failed > 0) {
+			golog.Debugf("Leaving %v tasks alone: replay stopped after %v sent and %v failed", len(tasks)-i, res.Replayed, res.Failed)
+			res.Found += uint(len(tasks) - i)
+			res.Skipped += uint(len(tasks) - i)
+			break
+		}
+		key := fmt.Sprintf("%v_url", taskname)
+		res.Found++
+		expired := cache.GetExpiry(key, opts.MaxTaskAge) == nil
+		if expired && opts.ExpiredAction == ExpiredTaskKeep {
+			golog.Debugf("Ignoring task %v: older than %v", taskname, opts.MaxTaskAge)
+			res.TooOld++
+			continue
+		}
+		meta := n.loadTaskMeta(cache, taskname)
+		if !expired && meta.NotBefore != nil && n.now().Before(*meta.NotBefore) {
+			golog.Debugf("Ignoring task %v: deferred until %v", taskname, *meta.NotBefore)
+			res.Skipped++
+			continue
+		} else if !expired && meta.NotIdempotent && meta.NotBefore == nil {
+			golog.Debugf("Ignoring task %v: not idempotent, and attempted already", taskname)
+			res.Skipped++
+			continue
+		}
+		claimkname := fmt.Sprintf("%v_claim", taskname)
+		claimed, claimerr := n.claimTask(cache, taskname)
+		if claimerr != nil || !claimed {
+			golog.Debugf("Ignoring task %v: claimed by another replay (err=%v)", taskname, claimerr)
+			res.Skipped++
+			continue
+		}
+		if expired {
+			golog.Debugf("Disposing of task %v: older than %v", taskname, opts.MaxTaskAge)
+			res.TooOld++
+			if err := n.disposeExpiredTask(cache, deadLetters, taskname, opts.ExpiredAction); err != nil {
+				golog.Errorf("Error disposing of expired task %v: '%v' (keeping it)", taskname, err)
+			}
+			cache.Unset(claimkname)
+			continue
+		}
+		// read after claiming, as another replay may have completed the task meanwhile
+		urlstr := cache.Get(key)
+		body := readTaskPart(cache, fmt.Sprintf("%v_body", taskname))
+		if urlstr == nil || body == nil {
+			golog.Debugf("Ignoring task %v: incomplete", taskname)
+			cache.Unset(claimkname)
+			res.Skipped++
+			continue
+		}
+		donetask := taskname
+		if opts.KeepDone {
+			donetask = ""
+		}
+		meta = n.loadTaskMeta(cache, taskname)
+		if meta.NotIdempotent && meta.NotBefore != nil {
+			// deferred, hence never attempted; record the attempt first, so it is not repeated should it fail
+			attempted := meta
+			attempted.NotBefore = nil
+			// cannot fail, because taskMeta is always convertible
+			metadata, _ := json.Marshal(attempted)
+			if err := cache.Set(fmt.Sprintf("%v_meta", taskname), metadata); err != nil {
+				golog.Errorf("Error recording attempt of task %v: '%v' (keeping it)", taskname, err)
+				cache.Unset(claimkname)
+				res.Failed++
+				continue
+			}
+		}
+		err := n.replayTask(string(urlstr), body, meta, donetask)
+		cache.Unset(claimkname)
+		if err != nil {
+			golog.Warnf("Replaying task %v failed: %v", taskname, err)
+			res.Failed++
+			continue
+		}
+		res.Replayed++
+	}
+	golog.Infof("Replayed tasks: %v found, %v sent, %v too old, %v skipped, %v failed", res.Found, res.Replayed, res.TooOld, res.Skipped, res.Failed)
+	return res, nil
+}
+
+// delete an expired task, or move it into deadLetters, as requested by action
+func (n *TattlerClientHTTP) disposeExpiredTask(cache *fscache.FSCache, deadLetters *fscache.FSCache, taskname string, action ExpiredTaskAction) error {
+	switch action {
+	case ExpiredTaskKeep:
+		return nil
+	case ExpiredTaskDeadLetter:
+		for _, part := range []string{"url", "body", "meta"} {
+			kname := fmt.Sprintf("%v_%v", taskname, part)
+			if err := copyTaskPart(cache, deadLetters, kname); err != nil {
+				return fmt.Errorf("failed to dead-letter %v: %w", kname, err)
+			}
+		}
+		golog.Infof("Task %v moved to dead letters", taskname)
+	}
+	return n.ClearTask(taskname)
+}
+
+/*
+ReplayTask sends one persisted task by name, e.g. to retry a stuck notification once its cause is fixed, and clears it
+upon success. The age of the task is disregarded.
+
+The task is claimed like by ReplayOutstandingTasks, so concurrent replays do not deliver it twice.
+ReplayTask returns an error wrapping ErrTaskNotFound if the task does not exist, and the error of delivery if it fails,
+e.g. a ServerError; the task is then kept as delivery would keep it.
+*/
+func (n *TattlerClientHTTP) ReplayTask(taskname string) error {
+	if !n.persists() {
+		return fmt.Errorf("cannot ReplayTask(%v) because PersistencyDir is disabled", taskname)
+	}
+	if err := n.ensureValid(); err != nil {
+		return fmt.Errorf("validating configuration failed: %w", err)
+	}
+	if n.IsPaused() {
+		return fmt.Errorf("cannot replay task %v: %w", taskname, ErrNotificationsPaused)
+	}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		return fmt.Errorf("failed to load cache to replay task %v: %w", taskname, err)
+	}
+	if !cache.Exists(fmt.Sprintf("%v_url", taskname)) {
+		return fmt.Errorf("cannot replay task %v: %w", taskname, ErrTaskNotFound)
+	}
+	claimkname := fmt.Sprintf("%v_claim", taskname)
+	claimed, claimerr := n.claimTask(cache, taskname)
+	if claimerr != nil {
+		return fmt.Errorf("failed to claim task %v: %w", taskname, claimerr)
+	} else if !claimed {
+		return fmt.Errorf("cannot replay task %v: claimed by another replay", taskname)
+	}
+	defer cache.Unset(claimkname)
+	// read after claiming, as another replay may have completed the task meanwhile
+	urlstr := cache.Get(fmt.Sprintf("%v_url", taskname))
+	body := readTaskPart(cache, fmt.Sprintf("%v_body", taskname))
+	if urlstr == nil || body == nil {
+		return fmt.Errorf("cannot replay task %v: %w", taskname, ErrTaskNotFound)
+	}
+	if err := n.replayTask(string(urlstr), body, n.loadTaskMeta(cache, taskname), taskname); err != nil {
+		return err
+	}
+	golog.Infof("Task %v replayed on request", taskname)
+	return nil
+}
+
+// names of the tasks among cache keys, oldest first: by the unix time their name starts with, or for names lacking one
+// by the modification time of their URL part, then by that modification time, then by name. URL parts are only stat'ed
+// where the name falls short, and never read.
+func tasksByCreation(cache *fscache.FSCache, keys []string) []string {
+	type task struct {
+		name    string
+		created int64
+		mtime   time.Time
+	}
+	var tasks []task
+	perSecond := map[int64]int{}
+	for _, key := range keys {
+		taskname, isurl := strings.CutSuffix(key, "_url")
+		if !isurl {
+			continue
+		}
+		t := task{name: taskname}
+		var err error
+		if t.created, err = strconv.ParseInt(strings.SplitN(taskname, "_", 2)[0], 10, 64); err != nil {
+			t.mtime, _ = cache.ModTime(key)
+			t.created = t.mtime.Unix()
+		}
+		tasks = append(tasks, t)
+		perSecond[t.created]++
+	}
+	for i, t := range tasks {
+		// tasks created within the same second are told apart by modification time
+		if perSecond[t.created] > 1 && t.mtime.IsZero() {
+			tasks[i].mtime, _ = cache.ModTime(t.name + "_url")
+		}
+	}
+	slices.SortFunc(tasks, func(a, b task) int {
+		if a.created != b.created {
+			return cmp.Compare(a.created, b.created)
+		} else if c := a.mtime.Compare(b.mtime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	tasknames := make([]string, len(tasks))
+	for i, t := range tasks {
+		tasknames[i] = t.name
+	}
+	return tasknames
+}
+
+// deliver a journalled request as it was originally attempted, and complete taskname upon success unless empty
+func (n *TattlerClientHTTP) replayTask(urlstr string, body []byte, meta taskMeta, taskname string) error {
+	request, client := n.prepareHTTPRequestMeta(urlstr, body, meta)
+	resp, respbody, elapsed, resperr := n.roundTrip(context.Background(), request, client)
+	if resperr != nil {
+		err := requestError(urlstr, resperr)
+		n.recordHealth(err)
+		return err
+	}
+	err := n.processResponse(resp.StatusCode, resp.Status, resp.Header, urlstr, respbody, taskname, false)
+	n.recordHealth(err)
+	if err != nil {
+		return err
+	}
+	logDelivered(urlstr, len(body), elapsed, resp.StatusCode, respbody)
+	n.archiveSent(urlstr, body, deliveredCorrelationId(n.sentCorrelationId(urlstr, body), respbody))
+	return nil
 }
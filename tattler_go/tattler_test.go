@@ -1,18 +1,31 @@
 package tattler_go
 
 import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/kataras/golog"
+	"github.com/tattler-community/tattler-client-go/fscache"
 )
 
 // Common API base to use in tests
@@ -221,11 +234,11 @@ func TestFailedResponseProducesError(t *testing.T) {
 		Scope:    "testScope",
 	}
 
-	if n.processResponse(200, "200 OK", api_base_test, []byte{}, "") != nil {
+	if n.processResponse(200, "200 OK", nil, api_base_test, []byte{}, "", false) != nil {
 		t.Fatalf("processResponse() returns failure upon successful run")
 	}
 
-	if n.processResponse(400, "200 OK", api_base_test, []byte{}, "") == nil {
+	if n.processResponse(400, "200 OK", nil, api_base_test, []byte{}, "", false) == nil {
 		t.Fatalf("processResponse() returns no error upon failed run, if status description is '200' but status code is not")
 	}
 }
@@ -259,7 +272,7 @@ func TestPersist(t *testing.T) {
 		}
 	}
 
-	n.processResponse(400, "200 OK", urlstr, []byte{}, taskname)
+	n.processResponse(400, "200 OK", nil, urlstr, []byte{}, taskname, false)
 	for _, exppart := range []string{"url", "body"} {
 		fname := fmt.Sprintf("%v_%v", taskname, exppart)
 		expfname := path.Join(n.PersistencyDir, fname)
@@ -269,7 +282,7 @@ func TestPersist(t *testing.T) {
 		}
 	}
 
-	n.processResponse(200, "200 OK", urlstr, []byte{}, taskname)
+	n.processResponse(200, "200 OK", nil, urlstr, []byte{}, taskname, false)
 	for _, exppart := range []string{"url", "body"} {
 		fname := fmt.Sprintf("%v_%v", taskname, exppart)
 		expfname := path.Join(n.PersistencyDir, fname)
@@ -306,6 +319,84 @@ func TestPersistErrorDoesNotPreventDelivery(t *testing.T) {
 	}
 }
 
+func TestPersistErrorPreventsDeliveryAtLeastOnce(t *testing.T) {
+	req_called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req_called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// cache requested on invalid path
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "testScope",
+		PersistencyDir: path.Join("var", "empty"),
+		Delivery:       DeliveryAtLeastOnce,
+	}
+	result, err := n.SendNotificationOptions(context.Background(), "456", "my_important_event", map[string]string{}, []string{"email"}, "corrid123", SendOptions{})
+	if err == nil {
+		t.Fatalf("SendNotificationOptions unexpectedly succeeded after failing to journal task under DeliveryAtLeastOnce")
+	}
+	if req_called {
+		t.Fatalf("SendNotificationOptions called server after failing to journal task under DeliveryAtLeastOnce")
+	}
+	if result.Outcome != OutcomeNotSent {
+		t.Fatalf("SendNotificationOptions returned outcome %v after failing to journal task; want %v", result.Outcome, OutcomeNotSent)
+	}
+
+	// explicit per-send opt-out of journaling is honored
+	if _, err := n.SendNotificationOptions(context.Background(), "456", "my_important_event", map[string]string{}, []string{"email"}, "corrid123", SendOptions{SkipPersistency: true}); err != nil {
+		t.Fatalf("SendNotificationOptions with SkipPersistency unexpectedly failed under DeliveryAtLeastOnce: %v", err)
+	}
+	if !req_called {
+		t.Fatalf("SendNotificationOptions with SkipPersistency did not call server under DeliveryAtLeastOnce")
+	}
+
+	n = TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", Delivery: DeliveryAtLeastOnce}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration unexpectedly accepted DeliveryAtLeastOnce without PersistencyDir")
+	}
+}
+
+func TestDeliveryAtLeastOnce(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+		Delivery:       DeliveryAtLeastOnce,
+	}
+	if err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotification unexpectedly succeeded against failing server")
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) == 0 {
+		t.Fatalf("SendNotification under DeliveryAtLeastOnce left no task after failed delivery")
+	}
+	fail = false
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 1 || sent != 1 {
+		t.Fatalf("ReplayOutstandingTasks() = found %v, sent %v, err %v; want 1, 1, nil", found, sent, err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+		t.Fatalf("ReplayOutstandingTasks() left %v files after delivering task", len(entries))
+	}
+}
+
 func TestSendNotificationWithBody(t *testing.T) {
 	// prepare server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -475,3 +566,3145 @@ func TestSendNotificationServerError(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateConfigurationLive(t *testing.T) {
+	// any response counts as reachable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+	}
+	if err := n.ValidateConfigurationLive(context.Background()); err != nil {
+		t.Fatalf("ValidateConfigurationLive() unexpectedly failed on reachable server: %v", err)
+	}
+
+	// unreachable server
+	server.Close()
+	if err := n.ValidateConfigurationLive(context.Background()); err == nil {
+		t.Fatalf("ValidateConfigurationLive() unexpectedly succeeded on unreachable server %v", n.Endpoint)
+	}
+
+	// refused client
+	for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(statusCode)
+		}))
+		n.Endpoint = server.URL
+		err := n.ValidateConfigurationLive(context.Background())
+		server.Close()
+		if err == nil {
+			t.Fatalf("ValidateConfigurationLive() unexpectedly succeeded when server responds %v", statusCode)
+		}
+	}
+
+	// probes pass through RequestInterceptor and carry the scope header, like notifications
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get(DefaultScopeHeader) != "myscope" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	n.Endpoint = server.URL
+	n.ScopeInHeader = true
+	if err := n.ValidateConfigurationLive(context.Background()); err == nil {
+		t.Fatalf("ValidateConfigurationLive() unexpectedly succeeded without the credentials required by server")
+	}
+	n.RequestInterceptor = func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return nil
+	}
+	if err := n.ValidateConfigurationLive(context.Background()); err != nil {
+		t.Fatalf("ValidateConfigurationLive() failed despite RequestInterceptor setting the credentials required by server: %v", err)
+	}
+	n.ScopeInHeader = false
+	n.ScopeHeader = ""
+
+	// static validation comes first
+	n.Scope = " "
+	if err := n.ValidateConfigurationLive(context.Background()); err == nil {
+		t.Fatalf("ValidateConfigurationLive() unexpectedly accepted invalid Scope config")
+	}
+}
+
+func TestPersistMeta(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{
+		Endpoint:       api_base_test,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+	}
+	_, _, taskname, _ := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, "correlId")
+	metadata, err := os.ReadFile(path.Join(fpath, taskname+"_meta"))
+	if err != nil {
+		t.Fatalf("PrepareNotification() failed to persist meta part of task %v: %v", taskname, err)
+	}
+	var meta taskMeta
+	if err := json.Unmarshal(metadata, &meta); err != nil {
+		t.Fatalf("PrepareNotification() persisted unparseable meta part '%v': %v", string(metadata), err)
+	}
+	if meta.Method != "POST" || meta.Header.Get("Accept") != "application/json" {
+		t.Fatalf("PrepareNotification() persisted unexpected meta part %v", meta)
+	}
+
+	n.ClearTask(taskname)
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) != 0 {
+		t.Fatalf("ClearTask() left %v files behind", len(entries))
+	}
+}
+
+func TestReplayOutstandingTasks(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var methods_have []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods_have = append(methods_have, r.Method+" "+r.Header.Get("X-Custom"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+	}
+
+	// task with custom meta
+	_, _, taskname, _ := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, "")
+	header := n.requestHeader()
+	header.Set("X-Custom", "yes")
+	metadata, _ := json.Marshal(taskMeta{Method: "PUT", Header: header})
+	os.WriteFile(path.Join(fpath, taskname+"_meta"), metadata, 0600)
+
+	// legacy task lacking meta
+	_, _, legacytask, _ := n.PrepareNotification("637", "ev", map[string]string{}, []string{}, "")
+	os.Remove(path.Join(fpath, legacytask+"_meta"))
+
+	found, sent, ignored, err := n.ReplayOutstandingTasks(time.Duration(0), true)
+	if err != nil {
+		t.Fatalf("ReplayOutstandingTasks() unexpectedly failed: %v", err)
+	}
+	if found != 2 || sent != 2 || ignored != 0 {
+		t.Fatalf("ReplayOutstandingTasks() returned found=%v sent=%v ignored=%v, expected 2, 2, 0", found, sent, ignored)
+	}
+	slices.Sort(methods_have)
+	if !slices.Equal(methods_have, []string{"POST ", "PUT yes"}) {
+		t.Fatalf("ReplayOutstandingTasks() replayed requests as %v instead of persisted method and headers", methods_have)
+	}
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) != 0 {
+		t.Fatalf("ReplayOutstandingTasks() left %v files behind after successful replay with removeDone", len(entries))
+	}
+}
+
+func TestBuildRequestIsPure(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{
+		Endpoint:       api_base_test,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+	}
+	params := map[string]string{"foo": "bar", "baz": "1"}
+	url1, body1, err := n.BuildRequest("636", "ev", params, []string{"email", "sms"}, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed with valid input: %v", err)
+	}
+	url2, body2, _ := n.BuildRequest("636", "ev", params, []string{"email", "sms"}, "correlId")
+	if url1 != url2 || string(body1) != string(body2) {
+		t.Fatalf("BuildRequest() is not deterministic: '%v' '%v' != '%v' '%v'", url1, string(body1), url2, string(body2))
+	}
+	url_want := strings.TrimRight(api_base_test, "/") + "/notification/testScope/ev/?correlationId=correlId&mode=debug&user=636&vector=email%2Csms"
+	if url1 != url_want {
+		t.Fatalf("BuildRequest() returned URL '%v' instead of '%v'", url1, url_want)
+	}
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) != 0 {
+		t.Fatalf("BuildRequest() persisted %v files despite being pure", len(entries))
+	}
+
+	if _, _, err := n.BuildRequest(" ", "ev", params, []string{}, ""); err == nil {
+		t.Fatalf("BuildRequest() failed to return error when provided with empty recipient")
+	}
+}
+
+func TestVectorPolicy(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint: api_base_test,
+		Scope:    "testScope",
+	}
+	vectors := []string{"eMail", "in valid", " "}
+
+	// drop, by default
+	urlstr, _, err := n.BuildRequest("636", "ev", map[string]string{}, vectors, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed with VectorPolicyDrop: %v", err)
+	}
+	if requrl, err := url.Parse(urlstr); err != nil || requrl.Query().Get("vector") != "email" {
+		t.Fatalf("BuildRequest() with VectorPolicyDrop expected to only request 'email', got '%v'", urlstr)
+	}
+
+	// strict
+	n.VectorPolicy = VectorPolicyStrict
+	_, _, err = n.BuildRequest("636", "ev", map[string]string{}, vectors, "correlId")
+	if err == nil || !strings.Contains(err.Error(), "in valid") {
+		t.Fatalf("BuildRequest() with VectorPolicyStrict failed to return error naming invalid vector (err=%v)", err)
+	}
+	if _, _, err = n.BuildRequest("636", "ev", map[string]string{}, []string{"email", "sms"}, "correlId"); err != nil {
+		t.Fatalf("BuildRequest() with VectorPolicyStrict unexpectedly rejected valid vectors: %v", err)
+	}
+
+	// pass-through
+	n.VectorPolicy = VectorPolicyPassThrough
+	urlstr, _, err = n.BuildRequest("636", "ev", map[string]string{}, vectors, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed with VectorPolicyPassThrough: %v", err)
+	}
+	parsed, _ := url.Parse(urlstr)
+	if vector_have := parsed.Query().Get("vector"); vector_have != "email,in valid" {
+		t.Fatalf("BuildRequest() with VectorPolicyPassThrough requested vectors '%v' instead of 'email,in valid'", vector_have)
+	}
+
+	// unknown policy
+	n.VectorPolicy = VectorPolicyPassThrough + 1
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted invalid VectorPolicy=%v", n.VectorPolicy)
+	}
+}
+
+func TestLoadTask(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{
+		Endpoint:       api_base_test,
+		Scope:          "testScope",
+		Mode:           "staging",
+		PersistencyDir: fpath,
+	}
+	params := map[string]string{"foo": "bar & baz"}
+	_, _, taskname, _ := n.PrepareNotification("636", "ev", params, []string{"email", "sms"}, "correlId")
+
+	pn, err := n.LoadTask(taskname)
+	if err != nil {
+		t.Fatalf("LoadTask() unexpectedly failed loading task %v: %v", taskname, err)
+	}
+	pn_want := PendingNotification{
+		Scope:         "testScope",
+		EventName:     "ev",
+		Recipient:     "636",
+		Mode:          "staging",
+		Vectors:       []string{"email", "sms"},
+		CorrelationId: "correlId",
+		Params:        params,
+		Task:          taskname,
+	}
+	if fmt.Sprint(*pn) != fmt.Sprint(pn_want) {
+		t.Fatalf("LoadTask() returned %v instead of %v", *pn, pn_want)
+	}
+
+	if _, err := n.LoadTask("nonexisting"); err == nil {
+		t.Fatalf("LoadTask() failed to return error for non-existing task")
+	}
+	os.WriteFile(path.Join(fpath, taskname+"_body"), []byte("{"), 0600)
+	if _, err := n.LoadTask(taskname); err == nil {
+		t.Fatalf("LoadTask() failed to return error for task with corrupted body")
+	}
+}
+
+func TestRuntimeStateConcurrent(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "testScope", MaxConcurrent: 2}
+	states := make([]*clientState, 32)
+	var wg sync.WaitGroup
+	for i := range states {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			states[i] = n.runtimeState()
+		}()
+	}
+	wg.Wait()
+	for _, state := range states {
+		if state != states[0] {
+			t.Fatalf("runtimeState() upon concurrent first use returned distinct states")
+		}
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	var inflight, maxInflight atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cur := inflight.Add(1)
+		for {
+			prev := maxInflight.Load()
+			if cur <= prev || maxInflight.CompareAndSwap(prev, cur) {
+				break
+			}
+		}
+		<-release
+		inflight.Add(-1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:      server.URL,
+		Scope:         "myscope",
+		MaxConcurrent: 2,
+	}
+	n.ValidateConfiguration()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, "")
+		}()
+	}
+	// let requests pile up, then check that a waiting caller honors its context
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.SendNotificationContext(ctx, "456", "my_important_event", map[string]string{}, []string{}, ""); err == nil {
+		t.Errorf("SendNotificationContext() unexpectedly succeeded while all slots were busy and context expired")
+	}
+	close(release)
+	wg.Wait()
+
+	if maxInflight.Load() != 2 {
+		t.Fatalf("MaxConcurrent=2 allowed %v requests in flight at once", maxInflight.Load())
+	}
+}
+
+func TestSendNotificationAuthError(t *testing.T) {
+	n := TattlerClientHTTP{
+		Scope: "myscope",
+	}
+
+	for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tattler"`)
+			w.WriteHeader(statusCode)
+		}))
+		n.Endpoint = server.URL
+
+		err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, "corrid123")
+		server.Close()
+		var autherr *AuthError
+		if !errors.As(err, &autherr) {
+			t.Fatalf("SendNotification() upon status %v returned %v instead of *AuthError", statusCode, err)
+		}
+		if autherr.StatusCode != statusCode || autherr.WWWAuthenticate != `Bearer realm="tattler"` {
+			t.Fatalf("SendNotification() upon status %v returned AuthError with StatusCode=%v WWWAuthenticate='%v'", statusCode, autherr.StatusCode, autherr.WWWAuthenticate)
+		}
+	}
+
+	// other failures are no AuthError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	n.Endpoint = server.URL
+	err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, "corrid123")
+	var autherr *AuthError
+	if err == nil || errors.As(err, &autherr) {
+		t.Fatalf("SendNotification() upon status 502 returned %v instead of generic error", err)
+	}
+}
+
+func TestExtraQueryParams(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint:         api_base_test,
+		Scope:            "testScope",
+		ExtraQueryParams: map[string]string{"priority": "high", "channel_hint": "a&b"},
+	}
+	urlstr, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed with ExtraQueryParams: %v", err)
+	}
+	url_want := strings.TrimRight(api_base_test, "/") + "/notification/testScope/ev/?channel_hint=a%26b&correlationId=correlId&mode=debug&priority=high&user=636"
+	if urlstr != url_want {
+		t.Fatalf("BuildRequest() with ExtraQueryParams returned '%v' instead of '%v'", urlstr, url_want)
+	}
+
+	for _, reserved := range ReservedQueryParams {
+		n.ExtraQueryParams = map[string]string{reserved: "x"}
+		if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId"); err == nil {
+			t.Fatalf("BuildRequest() unexpectedly accepted ExtraQueryParams overriding reserved '%v'", reserved)
+		}
+	}
+}
+
+func TestForceHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:   server.URL,
+		Scope:      "myscope",
+		ForceHTTP2: true,
+	}
+	if n.LastProtocol() != "" {
+		t.Fatalf("LastProtocol() returns '%v' before any request", n.LastProtocol())
+	}
+	// trust the test server's certificate
+	n.runtimeState().transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	if err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed over HTTP/2: %v", err)
+	}
+	if n.LastProtocol() != "HTTP/2.0" {
+		t.Fatalf("LastProtocol() returns '%v' instead of HTTP/2.0 with ForceHTTP2", n.LastProtocol())
+	}
+
+	// cleartext server speaking HTTP/2 only to clients which know it in advance
+	cleartext := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	cleartext.Config.Protocols = new(http.Protocols)
+	cleartext.Config.Protocols.SetHTTP1(true)
+	cleartext.Config.Protocols.SetUnencryptedHTTP2(true)
+	cleartext.Start()
+	defer cleartext.Close()
+	for _, force := range []bool{false, true} {
+		n := TattlerClientHTTP{Endpoint: cleartext.URL, Scope: "myscope", ForceHTTP2: force}
+		if err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, ""); err != nil {
+			t.Fatalf("SendNotification() with ForceHTTP2=%v unexpectedly failed over cleartext: %v", force, err)
+		}
+		if want := map[bool]string{false: "HTTP/1.1", true: "HTTP/2.0"}[force]; n.LastProtocol() != want {
+			t.Fatalf("LastProtocol() with ForceHTTP2=%v over cleartext returns '%v'; want %v", force, n.LastProtocol(), want)
+		}
+	}
+}
+
+func TestSensitiveParamKeysRedactedInLogs(t *testing.T) {
+	var logbuf bytes.Buffer
+	golog.SetOutput(&logbuf)
+	golog.SetLevel("debug")
+	defer golog.SetLevel("info")
+	defer golog.SetOutput(os.Stdout)
+
+	n := TattlerClientHTTP{
+		Endpoint:           api_base_test,
+		Scope:              "testScope",
+		SensitiveParamKeys: []string{"email"},
+	}
+	params := map[string]string{"email": "someone@example.com", "amount": "10.20"}
+	_, body, err := n.BuildRequest("636", "ev", params, []string{}, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed: %v", err)
+	}
+	if !strings.Contains(string(body), "someone@example.com") {
+		t.Fatalf("BuildRequest() masked sensitive value in body sent to server: '%v'", string(body))
+	}
+	logged := logbuf.String()
+	if strings.Contains(logged, "someone@example.com") {
+		t.Fatalf("BuildRequest() logged value of sensitive param: '%v'", logged)
+	}
+	if !strings.Contains(logged, "10.20") {
+		t.Fatalf("BuildRequest() failed to log value of non-sensitive param: '%v'", logged)
+	}
+}
+
+func TestArchiveOnSuccess(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	apath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(apath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"result":"success"}`))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:         server.URL,
+		Scope:            "myscope",
+		PersistencyDir:   fpath,
+		ArchiveOnSuccess: true,
+	}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ArchiveOnSuccess without ArchiveDir")
+	}
+	n.ArchiveDir = fpath + "/"
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ArchiveDir equal to PersistencyDir")
+	}
+	n.ArchiveDir = apath
+
+	if err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed: %v", err)
+	}
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) != 0 {
+		t.Fatalf("SendNotification() left %v files in PersistencyDir after archiving", len(entries))
+	}
+	archived, _ := os.ReadDir(apath)
+	var suffixes []string
+	for _, entry := range archived {
+		suffixes = append(suffixes, entry.Name()[strings.LastIndex(entry.Name(), "_"):])
+	}
+	slices.Sort(suffixes)
+	suffixes_want := []string{"_body", "_deliveredat", "_meta", "_result", "_url"}
+	if !slices.Equal(suffixes, suffixes_want) {
+		t.Fatalf("SendNotification() archived parts %v instead of %v", suffixes, suffixes_want)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var nreqs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreqs.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:    server.URL,
+		Scope:       "myscope",
+		DedupWindow: 200 * time.Millisecond,
+	}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted DedupWindow without PersistencyDir")
+	}
+	n.PersistencyDir = fpath
+
+	params := map[string]string{"foo": "bar"}
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed: %v", err)
+	}
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); !errors.Is(err, ErrDeduplicated) {
+		t.Fatalf("SendNotification() of repeated notification within DedupWindow returned %v instead of ErrDeduplicated", err)
+	}
+	if err := n.SendNotification("456", "ev", map[string]string{"foo": "baz"}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() with different params unexpectedly failed: %v", err)
+	}
+	time.Sleep(n.DedupWindow)
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() of repeated notification after DedupWindow unexpectedly failed: %v", err)
+	}
+	if nreqs.Load() != 3 {
+		t.Fatalf("Server received %v requests instead of 3", nreqs.Load())
+	}
+	if _, err := n.ReplayOutstandingTasksOptions(ReplayOptions{}); err != nil {
+		t.Fatalf("ReplayOutstandingTasksOptions() unexpectedly failed: %v", err)
+	}
+	if keys, _ := filepath.Glob(path.Join(fpath, "dedup_*")); len(keys) != 1 {
+		t.Fatalf("Replay left %v deduplication marks; want only the one within DedupWindow", len(keys))
+	}
+}
+
+func TestClock(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var nreqs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nreqs.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	clock := fscache.NewFakeClock(time.Now())
+	// window from one hour ago to two hours ahead
+	sinceMidnight := time.Duration(clock.Now().UTC().Hour()) * time.Hour
+	quiet := &QuietHours{Start: (sinceMidnight + 23*time.Hour) % (24 * time.Hour), End: (sinceMidnight + 2*time.Hour) % (24 * time.Hour)}
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "myscope",
+		PersistencyDir: fpath,
+		DedupWindow:    time.Hour,
+		QuietHours:     quiet,
+		Clock:          clock,
+	}
+	params := map[string]string{"foo": "bar"}
+	if _, err := n.SendNotificationOptions(context.Background(), "456", "ev", params, []string{}, "", SendOptions{}); !errors.Is(err, ErrSuppressedQuietHours) {
+		t.Fatalf("SendNotificationOptions() during quiet hours per Clock returned '%v' instead of ErrSuppressedQuietHours", err)
+	}
+
+	clock.Advance(3 * time.Hour)
+	if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: 24 * time.Hour}); err != nil || res.Replayed != 1 || nreqs.Load() != 1 {
+		t.Fatalf("ReplayOutstandingTasksOptions() after quiet hours per Clock = %+v, %v with %v requests; want deferred task delivered", res, err, nreqs.Load())
+	}
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() after quiet hours per Clock unexpectedly failed: %v", err)
+	}
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); !errors.Is(err, ErrDeduplicated) {
+		t.Fatalf("SendNotification() of repeated notification within DedupWindow returned %v instead of ErrDeduplicated", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() of repeated notification after DedupWindow per Clock unexpectedly failed: %v", err)
+	}
+	if nreqs.Load() != 3 {
+		t.Fatalf("Server received %v requests instead of 3", nreqs.Load())
+	}
+}
+
+func TestClockNotShared(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	shared, err := fscache.GetInstance(fpath)
+	if err != nil {
+		t.Fatalf("GetInstance() unexpectedly failed: %v", err)
+	}
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "testScope", PersistencyDir: fpath, Clock: fscache.NewFakeClock(time.Now().Add(2 * time.Hour))}
+	cache, err := n.persistencyCache()
+	if err != nil {
+		t.Fatalf("persistencyCache() with Clock unexpectedly failed: %v", err)
+	}
+	if cache == shared {
+		t.Fatalf("persistencyCache() with Clock returned the instance shared by all users of PersistencyDir")
+	}
+	os.WriteFile(path.Join(fpath, "key"), []byte("value"), 0600)
+	if shared.GetExpiry("key", time.Hour) == nil {
+		t.Fatalf("Clock of a client changed the age of items in the shared instance")
+	}
+	if cache.GetExpiry("key", time.Hour) != nil {
+		t.Fatalf("persistencyCache() with Clock does not measure age by it")
+	}
+}
+
+func TestNotificationPathSegment(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint: api_base_test,
+		Scope:    "testScope",
+	}
+	if n.ValidateConfiguration() != nil || n.NotificationPathSegment != DefaultNotificationPathSegment {
+		t.Fatalf("ValidateConfiguration() sets NotificationPathSegment='%v' instead of default '%v'", n.NotificationPathSegment, DefaultNotificationPathSegment)
+	}
+
+	n.NotificationPathSegment = "notify"
+	urlstr, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed with custom NotificationPathSegment: %v", err)
+	}
+	if !strings.HasPrefix(urlstr, strings.TrimRight(api_base_test, "/")+"/notify/testScope/ev/?") {
+		t.Fatalf("BuildRequest() ignored custom NotificationPathSegment: '%v'", urlstr)
+	}
+
+	for _, invalid := range []string{"a/b", "/notify", ".."} {
+		n.NotificationPathSegment = invalid
+		if err := n.ValidateConfiguration(); err == nil {
+			t.Fatalf("ValidateConfiguration() unexpectedly accepted NotificationPathSegment='%v'", invalid)
+		}
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "myscope",
+		ConnectTimeout: 2 * DefaultTimeout,
+	}
+	if err := n.ValidateConfiguration(); err == nil || !strings.Contains(err.Error(), "ConnectTimeout") {
+		t.Fatalf("ValidateConfiguration() fails to reject ConnectTimeout=%v > Timeout=%v, or error fails to mention it (err=%v)", n.ConnectTimeout, n.Timeout, err)
+	}
+	n.ConnectTimeout = -time.Second
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ConnectTimeout=%v", n.ConnectTimeout)
+	}
+
+	n.ConnectTimeout = time.Second
+	if err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed with ConnectTimeout set: %v", err)
+	}
+	if n.runtimeState().transport == nil || n.runtimeState().transport.DialContext == nil {
+		t.Fatalf("ConnectTimeout fails to configure a dedicated transport dialer")
+	}
+}
+
+func TestDebugOverrideAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qrparams, _ := url.ParseQuery(r.URL.RawQuery)
+		if qrparams.Get("debugAddress") != "qa@example.com" {
+			t.Errorf("Expected debugAddress=qa@example.com, got '%v'", qrparams.Get("debugAddress"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+	}
+	opts := SendOptions{DebugOverrideAddress: "qa@example.com"}
+	_, err := n.SendNotificationOptions(context.Background(), "456", "ev", map[string]string{}, []string{}, "", opts)
+	if err != nil {
+		t.Fatalf("SendNotificationOptions() with DebugOverrideAddress unexpectedly failed in debug mode: %v", err)
+	}
+
+	for _, mode := range []string{"staging", "production"} {
+		n.Mode = mode
+		_, err := n.SendNotificationOptions(context.Background(), "456", "ev", map[string]string{}, []string{}, "", opts)
+		if err == nil {
+			t.Fatalf("SendNotificationOptions() unexpectedly accepted DebugOverrideAddress in mode %v", mode)
+		}
+	}
+}
+
+func TestSendOutcome(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "myscope",
+		PersistencyDir: fpath,
+		DedupWindow:    time.Minute,
+	}
+	ctx := context.Background()
+	params := map[string]string{}
+
+	if result, _ := n.SendNotificationOptions(ctx, "", "ev", params, []string{}, "", SendOptions{}); result.Outcome != OutcomeNotSent {
+		t.Fatalf("SendNotificationOptions() of invalid notification reports outcome %v", result.Outcome)
+	}
+	if result, _ := n.SendNotificationOptions(ctx, "456", "ev", params, []string{}, "", SendOptions{}); result.Outcome != OutcomeSent {
+		t.Fatalf("SendNotificationOptions() of delivered notification reports outcome %v", result.Outcome)
+	}
+	if result, _ := n.SendNotificationOptions(ctx, "456", "ev", params, []string{}, "", SendOptions{}); result.Outcome != OutcomeDeduplicated {
+		t.Fatalf("SendNotificationOptions() of duplicate notification reports outcome %v", result.Outcome)
+	}
+	server.Close()
+	if result, _ := n.SendNotificationOptions(ctx, "789", "ev", params, []string{}, "", SendOptions{}); result.Outcome != OutcomePersisted {
+		t.Fatalf("SendNotificationOptions() to unreachable server with persistency reports outcome %v", result.Outcome)
+	}
+}
+
+func TestParamsNotHTMLEscaped(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint: api_base_test,
+		Scope:    "testScope",
+	}
+	params := map[string]string{"link": "a&b=<x>"}
+	_, body, err := n.BuildRequest("636", "ev", params, []string{}, "correlId")
+	if err != nil {
+		t.Fatalf("BuildRequest() unexpectedly failed: %v", err)
+	}
+	if string(body) != `{"link":"a&b=<x>"}` {
+		t.Fatalf("BuildRequest() escapes param values in body: '%v'", string(body))
+	}
+	var jbody map[string]string
+	if err := json.Unmarshal(body, &jbody); err != nil || jbody["link"] != params["link"] {
+		t.Fatalf("BuildRequest() body fails to round-trip param 'link'='%v': got %v (err=%v)", params["link"], jbody, err)
+	}
+}
+
+func TestReplaySkipsClaimedTasks(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+	}
+	_, _, claimedtask, _ := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, "")
+	_, _, freetask, _ := n.PrepareNotification("637", "ev", map[string]string{}, []string{}, "")
+	os.WriteFile(path.Join(fpath, claimedtask+"_claim"), []byte{}, 0600)
+
+	found, sent, ignored, err := n.ReplayOutstandingTasks(time.Duration(0), true)
+	if err != nil {
+		t.Fatalf("ReplayOutstandingTasks() unexpectedly failed: %v", err)
+	}
+	if found != 2 || sent != 1 || ignored != 1 {
+		t.Fatalf("ReplayOutstandingTasks() returned found=%v sent=%v ignored=%v, expected 2, 1, 1", found, sent, ignored)
+	}
+	if _, err := os.Stat(path.Join(fpath, claimedtask+"_url")); err != nil {
+		t.Fatalf("ReplayOutstandingTasks() delivered task %v claimed by another replay", claimedtask)
+	}
+	if _, err := os.Stat(path.Join(fpath, freetask+"_claim")); err == nil {
+		t.Fatalf("ReplayOutstandingTasks() left its claim on task %v behind", freetask)
+	}
+}
+
+func TestReplayTakesOverStaleClaims(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	clock := fscache.NewFakeClock(time.Now())
+	n := TattlerClientHTTP{
+		Endpoint:       server.URL,
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+		Timeout:        time.Second,
+		Clock:          clock,
+	}
+	_, _, taskname, _ := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, "")
+	// left behind by a process crashing while delivering
+	os.WriteFile(path.Join(fpath, taskname+"_claim"), []byte(clock.Now().UTC().Format(time.RFC3339)), 0600)
+
+	clock.Advance(staleClaimTimeouts*n.Timeout - time.Second)
+	if _, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || sent != 0 {
+		t.Fatalf("ReplayOutstandingTasks() within claim TTL sent %v (err=%v); want the claimed task left alone", sent, err)
+	}
+	clock.Advance(2 * time.Second)
+	if _, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || sent != 1 {
+		t.Fatalf("ReplayOutstandingTasks() past claim TTL sent %v (err=%v); want the stale claim taken over", sent, err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+		t.Fatalf("ReplayOutstandingTasks() taking over stale claim left %v files behind", len(entries))
+	}
+}
+
+func TestSkipPersistency(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{
+		Endpoint:       "http://127.0.0.1:1",
+		Scope:          "testScope",
+		PersistencyDir: fpath,
+		Timeout:        time.Second,
+	}
+	// delivery fails, so a journalled task would stay
+	_, err = n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{SkipPersistency: true})
+	if err == nil {
+		t.Fatalf("SendNotificationOptions() unexpectedly succeeded against closed port")
+	}
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) != 0 {
+		t.Fatalf("SendNotificationOptions() with SkipPersistency journalled %v files", len(entries))
+	}
+
+	n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	entries, _ = os.ReadDir(fpath)
+	if len(entries) == 0 {
+		t.Fatalf("SendNotificationOptions() without SkipPersistency failed to journal task")
+	}
+}
+
+func TestErrorsCarryStatusCode(t *testing.T) {
+	n := TattlerClientHTTP{
+		Scope: "myscope",
+	}
+	for _, statusCode := range []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusUnauthorized} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(statusCode)
+		}))
+		n.Endpoint = server.URL
+		err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, "")
+		server.Close()
+		if StatusCode(err) != statusCode {
+			t.Fatalf("StatusCode() of error upon server response %v returns %v (err=%v)", statusCode, StatusCode(err), err)
+		}
+		var srverr *ServerError
+		if statusCode != http.StatusUnauthorized && (!errors.As(err, &srverr) || srverr.StatusCode != statusCode) {
+			t.Fatalf("SendNotification() upon server response %v returned %v instead of *ServerError", statusCode, err)
+		}
+	}
+
+	// dial failure
+	n.Endpoint = "http://127.0.0.1:1"
+	err := n.SendNotification("456", "my_important_event", map[string]string{}, []string{}, "")
+	if err == nil {
+		t.Fatalf("SendNotification() unexpectedly succeeded against closed port")
+	}
+	if StatusCode(err) != 0 {
+		t.Fatalf("StatusCode() of dial failure returns %v instead of 0", StatusCode(err))
+	}
+}
+
+func TestNoTrailingSlash(t *testing.T) {
+	for _, noTrailingSlash := range []bool{false, true} {
+		wantPath := "/notification/myscope/ev/"
+		if noTrailingSlash {
+			wantPath = "/notification/myscope/ev"
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != wantPath {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		n := TattlerClientHTTP{
+			Endpoint:        server.URL,
+			Scope:           "myscope",
+			NoTrailingSlash: noTrailingSlash,
+		}
+		err := n.SendNotification("456", "ev", map[string]string{}, []string{}, "")
+		server.Close()
+		if err != nil {
+			t.Fatalf("SendNotification() with NoTrailingSlash=%v failed against server accepting only '%v': %v", noTrailingSlash, wantPath, err)
+		}
+	}
+}
+
+func TestFetchSupportedModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/capabilities" {
+			t.Errorf("FetchSupportedModes() requested unexpected path '%v'", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"modes": ["production", "canary"]}`))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+		Mode:     "canary",
+	}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted mode 'canary' not in NotificationModes")
+	}
+	n.Mode = ""
+	modes, err := n.FetchSupportedModes(context.Background())
+	if err != nil {
+		t.Fatalf("FetchSupportedModes() unexpectedly failed: %v", err)
+	}
+	if !slices.Equal(modes, []string{"production", "canary"}) {
+		t.Fatalf("FetchSupportedModes() returned %v instead of server's modes", modes)
+	}
+	n.AllowedModes = modes
+	n.Mode = "canary"
+	if err := n.ValidateConfiguration(); err != nil {
+		t.Fatalf("ValidateConfiguration() rejected mode 'canary' listed in AllowedModes: %v", err)
+	}
+
+	// server lacking capabilities endpoint
+	server404 := httptest.NewServer(http.NotFoundHandler())
+	defer server404.Close()
+	n = TattlerClientHTTP{
+		Endpoint: server404.URL,
+		Scope:    "myscope",
+	}
+	modes, err = n.FetchSupportedModes(context.Background())
+	if err != nil || !slices.Equal(modes, NotificationModes) {
+		t.Fatalf("FetchSupportedModes() without capabilities endpoint returned %v, %v instead of NotificationModes", modes, err)
+	}
+}
+
+func TestSuccessLogHasSizeAndTiming(t *testing.T) {
+	var logbuf bytes.Buffer
+	golog.SetOutput(&logbuf)
+	defer golog.SetOutput(os.Stdout)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint: server.URL,
+		Scope:    "myscope",
+	}
+	params := map[string]string{"foo": "bar"}
+	if err := n.SendNotification("456", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed: %v", err)
+	}
+	body, _ := mkJSONContext(params)
+	expre := regexp.MustCompile(fmt.Sprintf(`sent \(%d bytes in [0-9.]+[mµn]?s\)`, len(body)))
+	if !expre.MatchString(logbuf.String()) {
+		t.Fatalf("SendNotification() success log lacks body size and timing: '%v'", logbuf.String())
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient(TattlerClientHTTP{Endpoint: " ", Scope: "myscope"}); err == nil {
+		t.Fatalf("NewClient() unexpectedly accepted invalid configuration")
+	}
+	n, err := NewClient(TattlerClientHTTP{Endpoint: api_base_test, Scope: " myscope "})
+	if err != nil {
+		t.Fatalf("NewClient() unexpectedly rejected valid configuration: %v", err)
+	}
+	if n.Scope != "myscope" || n.Mode != DefaultMode || n.Timeout != DefaultTimeout {
+		t.Fatalf("NewClient() fails to normalize configuration: %v", n)
+	}
+
+	// changes are not revalidated upon send, until Revalidate()
+	n.Mode = "invalid_mode"
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId"); err != nil {
+		t.Fatalf("BuildRequest() revalidated sealed configuration: %v", err)
+	}
+	if err := n.Revalidate(); err == nil {
+		t.Fatalf("Revalidate() unexpectedly accepted invalid Mode")
+	}
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId"); err == nil {
+		t.Fatalf("BuildRequest() unexpectedly accepted invalid Mode after failed Revalidate()")
+	}
+}
+
+func BenchmarkBuildRequest(b *testing.B) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"}
+	params := map[string]string{"amount": "10.20"}
+	for i := 0; i < b.N; i++ {
+		n.BuildRequest("636", "ev", params, []string{}, "correlId")
+	}
+}
+
+func BenchmarkBuildRequestNewClient(b *testing.B) {
+	n, _ := NewClient(TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"})
+	params := map[string]string{"amount": "10.20"}
+	for i := 0; i < b.N; i++ {
+		n.BuildRequest("636", "ev", params, []string{}, "correlId")
+	}
+}
+
+func TestBuildRequestInvalidUTF8(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"}
+	// "café" encoded as Latin-1
+	params := map[string]string{"name": "ok", "place": "caf\xe9"}
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, "correlId"); err == nil {
+		t.Fatalf("BuildRequest() unexpectedly accepted param with invalid UTF-8 value")
+	} else if !strings.Contains(err.Error(), "'place'") {
+		t.Fatalf("BuildRequest() returned error not naming offending param key 'place': %v", err)
+	}
+	params["place"] = "café"
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, "correlId"); err != nil {
+		t.Fatalf("BuildRequest() unexpectedly rejected valid UTF-8 params: %v", err)
+	}
+}
+
+func TestMigratePersistency(t *testing.T) {
+	oldpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(oldpath)
+	newpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(newpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: oldpath}
+	if _, _, _, err := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("PrepareNotification() unexpectedly failed: %v", err)
+	}
+	moved, err := MigratePersistency(oldpath, newpath)
+	if err != nil || moved != 3 {
+		t.Fatalf("MigratePersistency() = %v, %v; want 3 files of one task, nil", moved, err)
+	}
+
+	n = TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: newpath}
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 1 || sent != 1 {
+		t.Fatalf("ReplayOutstandingTasks() after migration = found %v, sent %v, err %v; want 1, 1, nil", found, sent, err)
+	}
+}
+
+func TestRecipientType(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"}
+	for _, tc := range []struct {
+		recipient     string
+		recipientType RecipientType
+		param         string
+	}{
+		{"636", RecipientUserID, "user"},
+		{"foo@example.com", RecipientEmailAddress, "email"},
+		{"+41791234567", RecipientPhoneNumber, "sms"},
+	} {
+		urlstr, err := n.mkTattlerRequestURL(tc.recipient, "ev", []string{}, "correlId", SendOptions{RecipientType: tc.recipientType})
+		if err != nil {
+			t.Fatalf("mkTattlerRequestURL() unexpectedly rejected recipient '%v' of type %v: %v", tc.recipient, tc.recipientType, err)
+		}
+		requrl, _ := url.Parse(urlstr)
+		query := requrl.Query()
+		if query.Get(tc.param) != tc.recipient {
+			t.Fatalf("mkTattlerRequestURL() fails to pass recipient '%v' in param '%v': %v", tc.recipient, tc.param, urlstr)
+		}
+		for _, other := range []string{"user", "email", "sms"} {
+			if other != tc.param && query.Has(other) {
+				t.Fatalf("mkTattlerRequestURL() passes recipient of type %v in unexpected param '%v': %v", tc.recipientType, other, urlstr)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		recipient     string
+		recipientType RecipientType
+	}{
+		{"foo", RecipientEmailAddress},
+		{"Foo <foo@example.com>", RecipientEmailAddress},
+		{"0791234567", RecipientPhoneNumber},
+		{"+41 79 123 45 67", RecipientPhoneNumber},
+		{"636", RecipientType(99)},
+	} {
+		if _, err := n.mkTattlerRequestURL(tc.recipient, "ev", []string{}, "correlId", SendOptions{RecipientType: tc.recipientType}); err == nil {
+			t.Fatalf("mkTattlerRequestURL() unexpectedly accepted recipient '%v' of type %v", tc.recipient, tc.recipientType)
+		}
+	}
+}
+
+func TestLoadTaskRecipientType(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope", PersistencyDir: fpath}
+	_, _, taskname, err := n.prepareNotification("foo@example.com", "ev", map[string]string{}, []string{}, "", SendOptions{RecipientType: RecipientEmailAddress}, false)
+	if err != nil {
+		t.Fatalf("prepareNotification() unexpectedly failed: %v", err)
+	}
+	pn, err := n.LoadTask(taskname)
+	if err != nil {
+		t.Fatalf("LoadTask() unexpectedly failed: %v", err)
+	}
+	if pn.Recipient != "foo@example.com" || pn.RecipientType != RecipientEmailAddress {
+		t.Fatalf("LoadTask() = recipient '%v' of type %v; want 'foo@example.com' of type %v", pn.Recipient, pn.RecipientType, RecipientEmailAddress)
+	}
+}
+
+func TestShardPersistency(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath, ShardPersistency: true}
+	tasks := make([]string, 0, 32)
+	for i := 0; i < cap(tasks); i++ {
+		_, _, taskname, err := n.PrepareNotification("636", "ev", map[string]string{"i": fmt.Sprint(i)}, []string{}, "")
+		if err != nil || taskname == "" {
+			t.Fatalf("PrepareNotification() failed to persist task into sharded PersistencyDir: %v", err)
+		}
+		tasks = append(tasks, taskname)
+	}
+	entries, _ := os.ReadDir(fpath)
+	if len(entries) < 2 {
+		t.Fatalf("ShardPersistency stored %v tasks into %v subdirectories; want them distributed", len(tasks), len(entries))
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Fatalf("ShardPersistency stored file '%v' at top of PersistencyDir", entry.Name())
+		}
+	}
+	if pn, err := n.LoadTask(tasks[0]); err != nil || pn.Params["i"] != "0" {
+		t.Fatalf("LoadTask() failed to load task from sharded PersistencyDir: %v", err)
+	}
+	found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true)
+	if err != nil || found != uint(len(tasks)) || sent != uint(len(tasks)) {
+		t.Fatalf("ReplayOutstandingTasks() on sharded PersistencyDir = found %v, sent %v, err %v; want %v, %v, nil", found, sent, err, len(tasks), len(tasks))
+	}
+	cache, _ := n.persistencyCache()
+	if cache.Len() != 0 {
+		t.Fatalf("ReplayOutstandingTasks() left %v files in sharded PersistencyDir after delivering all tasks", cache.Len())
+	}
+}
+
+func TestRequireVectors(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope", RequireVectors: true}
+	for _, vectors := range [][]string{nil, {}, {" "}, {"in valid", "@sms"}} {
+		if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, vectors, "correlId"); err == nil {
+			t.Fatalf("BuildRequest() with RequireVectors unexpectedly accepted vectors %q", vectors)
+		}
+	}
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{"in valid", "email"}, "correlId"); err != nil {
+		t.Fatalf("BuildRequest() with RequireVectors unexpectedly rejected valid vector: %v", err)
+	}
+	n.RequireVectors = false
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "correlId"); err != nil {
+		t.Fatalf("BuildRequest() without RequireVectors unexpectedly rejected empty vectors: %v", err)
+	}
+}
+
+func TestOnlyKnownVectors(t *testing.T) {
+	if !IsKnownVector(" Email ") || IsKnownVector("emial") {
+		t.Fatalf("IsKnownVector() fails to tell known vectors from typos")
+	}
+
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope"}
+	query, err := n.QueryParams("636", "ev", []string{VectorEmail, "emial"}, "correlId")
+	if err != nil || query.Get("vector") != "email,emial" {
+		t.Fatalf("QueryParams() without OnlyKnownVectors unexpectedly dropped custom vector: %v (err=%v)", query, err)
+	}
+
+	n.OnlyKnownVectors = true
+	query, err = n.QueryParams("636", "ev", []string{VectorEmail, "emial"}, "correlId")
+	if err != nil || query.Get("vector") != "email" {
+		t.Fatalf("QueryParams() with OnlyKnownVectors fails to drop unknown vector: %v (err=%v)", query, err)
+	}
+	n.VectorPolicy = VectorPolicyStrict
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, []string{VectorEmail, "emial"}, "correlId"); err == nil {
+		t.Fatalf("BuildRequest() with OnlyKnownVectors and VectorPolicyStrict unexpectedly accepted unknown vector")
+	}
+	n.VectorPolicy = VectorPolicyPassThrough
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted OnlyKnownVectors with VectorPolicyPassThrough")
+	}
+}
+
+func TestServerCorrelationId(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusOK)
+		if !query.Has("correlationId") {
+			w.Write([]byte(`[{"id":"email:49b99061","vector":"email","resultCode":0,"correlationId":"srv123"}]`))
+		}
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "myscope"}
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{ServerCorrelationId: true})
+	if err != nil {
+		t.Fatalf("SendNotificationOptions() with ServerCorrelationId unexpectedly failed: %v", err)
+	}
+	if query.Has("correlationId") {
+		t.Fatalf("SendNotificationOptions() with ServerCorrelationId sent correlationId '%v'", query.Get("correlationId"))
+	}
+	if result.CorrelationId != "srv123" {
+		t.Fatalf("SendNotificationOptions() with ServerCorrelationId reports correlation id '%v'; want 'srv123' as assigned by server", result.CorrelationId)
+	}
+
+	result, err = n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if err != nil || result.CorrelationId == "" || result.CorrelationId != query.Get("correlationId") {
+		t.Fatalf("SendNotificationOptions() reports correlation id '%v'; want generated '%v' (err=%v)", result.CorrelationId, query.Get("correlationId"), err)
+	}
+
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "mine", SendOptions{ServerCorrelationId: true}); err == nil {
+		t.Fatalf("SendNotificationOptions() unexpectedly accepted correlationId along with ServerCorrelationId")
+	}
+}
+
+func TestGeneratedCorrelationId(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	for _, placement := range []CorrelationIdPlacement{CorrelationIdInQuery, CorrelationIdInBody} {
+		n := TattlerClientHTTP{Endpoint: server.URL, Scope: "myscope", PersistencyDir: fpath, CorrelationIdPlacement: placement}
+		result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+		if err == nil || result.CorrelationId == "" {
+			t.Fatalf("SendNotificationOptions() with placement %v to failing server reports correlation id '%v' (err=%v); want generated one", placement, result.CorrelationId, err)
+		}
+		var taskname string
+		entries, _ := os.ReadDir(fpath)
+		for _, entry := range entries {
+			var meta taskMeta
+			data, _ := os.ReadFile(path.Join(fpath, entry.Name()))
+			if strings.HasSuffix(entry.Name(), "_meta") && json.Unmarshal(data, &meta) == nil && meta.CorrelationId == result.CorrelationId {
+				taskname = strings.TrimSuffix(entry.Name(), "_meta")
+			}
+		}
+		if taskname == "" {
+			t.Fatalf("SendNotificationOptions() with placement %v did not persist correlation id '%v' in task meta", placement, result.CorrelationId)
+		}
+		if pn, err := n.LoadTask(taskname); err != nil || pn.CorrelationId != result.CorrelationId {
+			t.Fatalf("LoadTask() with placement %v = %+v, %v; want correlation id '%v'", placement, pn, err, result.CorrelationId)
+		}
+	}
+}
+
+func TestReplayMaxTaskAge(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+	deadpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(deadpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("user") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	_, _, oldtask, _ := n.PrepareNotification("636", "ev", map[string]string{}, []string{}, "")
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(path.Join(fpath, oldtask+"_url"), old, old)
+	n.PrepareNotification("637", "ev", map[string]string{}, []string{}, "")
+	n.PrepareNotification("fail", "ev", map[string]string{}, []string{}, "")
+
+	opts := ReplayOptions{MaxTaskAge: 24 * time.Hour, KeepDone: true}
+	res, err := n.ReplayOutstandingTasksOptions(opts)
+	if err != nil {
+		t.Fatalf("ReplayOutstandingTasksOptions() unexpectedly failed: %v", err)
+	}
+	if res != (ReplayResult{Found: 3, Replayed: 1, TooOld: 1, Failed: 1}) {
+		t.Fatalf("ReplayOutstandingTasksOptions() = %+v; want 3 found, 1 replayed, 1 too old, 1 failed", res)
+	}
+	if _, err := n.LoadTask(oldtask); err != nil {
+		t.Fatalf("ReplayOutstandingTasksOptions() with ExpiredTaskKeep removed expired task: %v", err)
+	}
+
+	opts.ExpiredAction = ExpiredTaskDeadLetter
+	if _, err := n.ReplayOutstandingTasksOptions(opts); err == nil {
+		t.Fatalf("ReplayOutstandingTasksOptions() unexpectedly accepted ExpiredTaskDeadLetter without DeadLetterDir")
+	}
+	opts.DeadLetterDir = deadpath
+	if res, err := n.ReplayOutstandingTasksOptions(opts); err != nil || res.TooOld != 1 {
+		t.Fatalf("ReplayOutstandingTasksOptions() = %+v, %v; want 1 too old", res, err)
+	}
+	if _, err := n.LoadTask(oldtask); err == nil {
+		t.Fatalf("ReplayOutstandingTasksOptions() with ExpiredTaskDeadLetter left expired task in PersistencyDir")
+	}
+	dead := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: deadpath}
+	if pn, err := dead.LoadTask(oldtask); err != nil || pn.Recipient != "636" {
+		t.Fatalf("ReplayOutstandingTasksOptions() with ExpiredTaskDeadLetter failed to move expired task into DeadLetterDir: %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("user") == "slow" {
+			time.Sleep(500 * time.Millisecond)
+		} else {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", Timeout: 100 * time.Millisecond}
+	err := n.SendNotification("slow", "ev", map[string]string{}, []string{}, "")
+	if !errors.Is(err, ErrClientTimeout) {
+		t.Fatalf("SendNotification() against slow server returned '%v'; want ErrClientTimeout", err)
+	}
+	if StatusCode(err) != 0 {
+		t.Fatalf("SendNotification() against slow server reports status code %v; want 0", StatusCode(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n.Timeout = time.Second
+	if err := n.SendNotificationContext(ctx, "slow", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, ErrClientTimeout) {
+		t.Fatalf("SendNotificationContext() past its deadline returned '%v'; want ErrClientTimeout", err)
+	}
+
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	var srverr *ServerError
+	if !errors.As(err, &srverr) || srverr.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("SendNotification() upon 504 returned '%v'; want ServerError", err)
+	}
+	if errors.Is(err, ErrClientTimeout) {
+		t.Fatalf("SendNotification() upon 504 returned ErrClientTimeout; want it only for client-side timeouts")
+	}
+
+	n.Endpoint = "http://127.0.0.1:1"
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err == nil || errors.Is(err, ErrClientTimeout) {
+		t.Fatalf("SendNotification() against closed port returned '%v'; want error other than ErrClientTimeout", err)
+	}
+}
+
+func TestNetErrorUnwrap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", Timeout: 100 * time.Millisecond}
+	err := n.SendNotification("slow", "ev", map[string]string{}, []string{}, "")
+	var neterr net.Error
+	if !errors.As(err, &neterr) || !neterr.Timeout() {
+		t.Fatalf("SendNotification() against slow server returned '%v'; want it to wrap a timeout net.Error", err)
+	}
+
+	n.Endpoint = "http://127.0.0.1:1"
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	var operr *net.OpError
+	if !errors.As(err, &operr) || operr.Op != "dial" {
+		t.Fatalf("SendNotification() against closed port returned '%v'; want it to wrap a dial *net.OpError", err)
+	}
+}
+
+func TestFailoverEndpoints(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var correlIds []string
+	primaryStatus := http.StatusServiceUnavailable
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlIds = append(correlIds, r.URL.Query().Get("correlationId"))
+		w.WriteHeader(primaryStatus)
+	}))
+	defer primary.Close()
+	secondaryStatus := http.StatusOK
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlIds = append(correlIds, r.URL.Query().Get("correlationId"))
+		if r.URL.Path != "/notification/testScope/ev/" {
+			t.Errorf("failover request has path '%v'; want same as primary", r.URL.Path)
+		}
+		w.WriteHeader(secondaryStatus)
+	}))
+	defer secondary.Close()
+
+	n := TattlerClientHTTP{
+		Endpoint:          primary.URL,
+		FailoverEndpoints: []string{"http://127.0.0.1:1", secondary.URL + "/"},
+		Scope:             "testScope",
+		PersistencyDir:    fpath,
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() failed despite failover endpoint succeeding: %v", err)
+	}
+	if len(correlIds) != 2 || correlIds[0] != correlIds[1] {
+		t.Fatalf("SendNotification() requested primary and secondary with correlation ids %q; want the same one once each", correlIds)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+		t.Fatalf("SendNotification() kept task after delivering to failover endpoint")
+	}
+
+	// no failover upon client errors
+	correlIds = nil
+	primaryStatus = http.StatusBadRequest
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); StatusCode(err) != http.StatusBadRequest || len(correlIds) != 1 {
+		t.Fatalf("SendNotification() upon 400 from primary returned '%v' after %v requests; want 400 without failover", err, len(correlIds))
+	}
+	os.RemoveAll(fpath)
+	os.Mkdir(fpath, 0700)
+
+	// task kept if all endpoints fail
+	primaryStatus, secondaryStatus = http.StatusServiceUnavailable, http.StatusBadGateway
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	if StatusCode(err) != http.StatusBadGateway {
+		t.Fatalf("SendNotification() with all endpoints failing returned '%v'; want error of last endpoint", err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) == 0 {
+		t.Fatalf("SendNotification() failed to keep task after all endpoints failed")
+	}
+	os.RemoveAll(fpath)
+	os.Mkdir(fpath, 0700)
+
+	// task kept if a failover endpoint does not serve the path, which tells nothing of Endpoint
+	correlIds = nil
+	secondaryStatus = http.StatusNotFound
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	var srverr *ServerError
+	if !errors.As(err, &srverr) || srverr.StatusCode != http.StatusNotFound || !srverr.TaskKept || len(correlIds) != 2 {
+		t.Fatalf("SendNotification() upon 404 from failover endpoint returned '%v' after %v requests; want 404 with task kept", err, len(correlIds))
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) == 0 {
+		t.Fatalf("SendNotification() cleared task upon 404 from failover endpoint")
+	}
+	os.RemoveAll(fpath)
+	os.Mkdir(fpath, 0700)
+
+	// no failover upon errors before sending, which would recur at any endpoint
+	correlIds = nil
+	var intercepted int
+	n.RequestInterceptor = func(r *http.Request) error {
+		intercepted++
+		return fmt.Errorf("signing key unavailable")
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err == nil || intercepted != 1 || len(correlIds) != 0 {
+		t.Fatalf("SendNotification() with failing RequestInterceptor returned '%v' after %v attempts; want its error without failover", err, intercepted)
+	}
+	n.RequestInterceptor = nil
+
+	n.FailoverEndpoints = []string{"not a url"}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted invalid failover endpoint")
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint:         api_base_test,
+		Scope:            "myscope",
+		Mode:             "staging",
+		ExtraQueryParams: map[string]string{"tenant": "acme"},
+	}
+	query, err := n.QueryParams(" 636 ", "ev", []string{"email", "SMS "}, "correlId")
+	if err != nil {
+		t.Fatalf("QueryParams() unexpectedly failed: %v", err)
+	}
+	want := url.Values{
+		"mode":          {"staging"},
+		"user":          {"636"},
+		"vector":        {"email,sms"},
+		"correlationId": {"correlId"},
+		"tenant":        {"acme"},
+	}
+	if query.Encode() != want.Encode() {
+		t.Fatalf("QueryParams() = %v; want %v", query, want)
+	}
+	urlstr, _, _ := n.BuildRequest("636", "ev", map[string]string{}, []string{"email", "SMS "}, "correlId")
+	if !strings.HasSuffix(urlstr, "?"+query.Encode()) {
+		t.Fatalf("QueryParams() returned %v, which differs from query of BuildRequest() '%v'", query, urlstr)
+	}
+	if query, _ := n.QueryParams("636", "ev", []string{}, ""); query.Get("correlationId") == "" {
+		t.Fatalf("QueryParams() fails to generate correlationId when none is given")
+	}
+	if _, err := n.QueryParams(" ", "ev", []string{}, ""); err == nil {
+		t.Fatalf("QueryParams() unexpectedly accepted empty recipient")
+	}
+}
+
+func TestPersistencyDirUnavailableMidRun(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var reqs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() unexpectedly failed with PersistencyDir available: %v", err)
+	}
+	cache, _ := n.persistencyCache()
+	if again, _ := n.persistencyCache(); again != cache {
+		t.Fatalf("persistencyCache() resolved cache anew instead of keeping it on the client")
+	}
+
+	// volume unmounted
+	os.RemoveAll(fpath)
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() under DeliveryBestEffort failed with PersistencyDir unavailable: %v", err)
+	}
+	if reqs.Load() != 2 {
+		t.Fatalf("SendNotification() under DeliveryBestEffort did not deliver with PersistencyDir unavailable")
+	}
+	n.Delivery = DeliveryAtLeastOnce
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotification() under DeliveryAtLeastOnce unexpectedly succeeded with PersistencyDir unavailable")
+	}
+	if reqs.Load() != 2 {
+		t.Fatalf("SendNotification() under DeliveryAtLeastOnce delivered without journaling")
+	}
+	if err := n.ClearTask("1_missing"); err != nil {
+		t.Fatalf("ClearTask() of task already gone failed with PersistencyDir unavailable: %v", err)
+	}
+
+	// volume back
+	os.Mkdir(fpath, 0700)
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() failed after PersistencyDir became available again: %v", err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+		t.Fatalf("SendNotification() left %v files after PersistencyDir became available again", len(entries))
+	}
+
+	other, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(other)
+	n.PersistencyDir = other
+	if moved, _ := n.persistencyCache(); moved == cache {
+		t.Fatalf("persistencyCache() kept cache of former PersistencyDir")
+	}
+}
+
+// fscache.Storage failing to remove the URL parts of tasks while failing is set
+type stuckStorage struct {
+	*fscache.MemStorage
+	failing atomic.Bool
+}
+
+func (s *stuckStorage) Remove(name string) error {
+	if s.failing.Load() && strings.HasSuffix(name, "_url") {
+		return fs.ErrPermission
+	}
+	return s.MemStorage.Remove(name)
+}
+
+func TestClearFailureAfterDelivery(t *testing.T) {
+	var reqs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := &stuckStorage{MemStorage: fscache.NewMemStorage()}
+	storage.failing.Store(true)
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyStorage: storage, Delivery: DeliveryAtLeastOnce}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() delivered but failing to clear its task returned '%v'; want success", err)
+	}
+	if entries, _ := storage.List("."); len(entries) != 3 {
+		t.Fatalf("SendNotification() failing to clear its task left %v; want the task's 3 parts whole", entries)
+	}
+
+	// delivered again once the journal recovers
+	storage.failing.Store(false)
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 1 || sent != 1 || reqs.Load() != 2 {
+		t.Fatalf("ReplayOutstandingTasks() of task not cleared = found %v, sent %v, err %v with %v requests; want it delivered again", found, sent, err, reqs.Load())
+	}
+	if entries, _ := storage.List("."); len(entries) != 0 {
+		t.Fatalf("ReplayOutstandingTasks() left %v after delivering again", entries)
+	}
+}
+
+func TestEndpointNotFound(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "wrongScope", PersistencyDir: fpath}
+	for _, status = range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+		if !errors.Is(err, ErrEndpointNotFound) || StatusCode(err) != status {
+			t.Fatalf("SendNotification() upon %v returned '%v'; want ErrEndpointNotFound", status, err)
+		}
+		var srverr *ServerError
+		if !errors.As(err, &srverr) || srverr.TaskKept {
+			t.Fatalf("SendNotification() upon %v reports task kept: '%v'", status, err)
+		}
+		if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+			t.Fatalf("SendNotification() upon %v left %v files in journal", status, len(entries))
+		}
+	}
+
+	status = http.StatusBadGateway
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err == nil || errors.Is(err, ErrEndpointNotFound) {
+		t.Fatalf("SendNotification() upon 502 returned '%v'; want error other than ErrEndpointNotFound", err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) == 0 {
+		t.Fatalf("SendNotification() upon 502 failed to keep task in journal")
+	}
+}
+
+func TestAcceptProblemDocuments(t *testing.T) {
+	var accept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"https://tattler.example/probs/unknown-event","title":"Unknown event","status":400,"detail":"no template for event 'ev'"}`))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	if accept != DefaultAccept {
+		t.Fatalf("SendNotification() sent Accept '%v'; want default '%v'", accept, DefaultAccept)
+	}
+	var problem *Problem
+	if errors.As(err, &problem) {
+		t.Fatalf("SendNotification() parsed problem document although client does not accept them: %v", err)
+	}
+
+	n.Accept = "application/json, application/problem+json"
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	if accept != n.Accept {
+		t.Fatalf("SendNotification() sent Accept '%v'; want configured '%v'", accept, n.Accept)
+	}
+	if !errors.As(err, &problem) || problem.Title != "Unknown event" || problem.Status != 400 || problem.Type != "https://tattler.example/probs/unknown-event" {
+		t.Fatalf("SendNotification() fails to map problem document to Problem: %v", err)
+	}
+	if StatusCode(err) != http.StatusBadRequest || !strings.Contains(err.Error(), "no template for event") {
+		t.Fatalf("SendNotification() returned error lacking status or problem detail: %v", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	if health := n.Health(); health != (HealthStatus{}) {
+		t.Fatalf("Health() of unused client = %+v; want zero", health)
+	}
+	tstart := time.Now()
+	n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	health := n.Health()
+	if health.ConsecutiveFailures != 2 || health.LastFailure.Before(tstart) || !strings.Contains(health.LastError, "503") || !health.LastSuccess.IsZero() {
+		t.Fatalf("Health() after 2 failures = %+v; want 2 consecutive failures and no success", health)
+	}
+
+	fail = false
+	n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	health = n.Health()
+	if health.ConsecutiveFailures != 0 || health.LastSuccess.Before(health.LastFailure) || health.LastError == "" {
+		t.Fatalf("Health() after success = %+v; want consecutive failures reset, and last failure kept", health)
+	}
+}
+
+func TestCanaryMode(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope", Mode: "production", CanaryFraction: 0.25, CanaryMode: "staging", CanarySeed: 42}
+	picks := func(n *TattlerClientHTTP) []string {
+		modes := make([]string, 0, 400)
+		for i := 0; i < cap(modes); i++ {
+			query, err := n.QueryParams("636", "ev", []string{}, "correlId")
+			if err != nil {
+				t.Fatalf("QueryParams() unexpectedly failed with canary configuration: %v", err)
+			}
+			modes = append(modes, query.Get("mode"))
+		}
+		return modes
+	}
+	modes := picks(&n)
+	canaries := 0
+	for _, mode := range modes {
+		if mode == "staging" {
+			canaries++
+		} else if mode != "production" {
+			t.Fatalf("QueryParams() with canary configuration used unexpected mode '%v'", mode)
+		}
+	}
+	if canaries < 60 || canaries > 140 {
+		t.Fatalf("QueryParams() with CanaryFraction=0.25 used canary mode %v times out of %v", canaries, len(modes))
+	}
+	same := TattlerClientHTTP{Endpoint: api_base_test, Scope: "myscope", Mode: "production", CanaryFraction: 0.25, CanaryMode: "staging", CanarySeed: 42}
+	if !slices.Equal(modes, picks(&same)) {
+		t.Fatalf("QueryParams() picked canaries differently with the same CanarySeed")
+	}
+
+	for _, invalid := range []TattlerClientHTTP{
+		{Endpoint: api_base_test, Scope: "myscope", CanaryFraction: 1.5, CanaryMode: "staging"},
+		{Endpoint: api_base_test, Scope: "myscope", CanaryFraction: 0.5},
+		{Endpoint: api_base_test, Scope: "myscope", CanaryFraction: 0.5, CanaryMode: "unknown"},
+	} {
+		if err := invalid.ValidateConfiguration(); err == nil {
+			t.Fatalf("ValidateConfiguration() unexpectedly accepted canary configuration %v / '%v'", invalid.CanaryFraction, invalid.CanaryMode)
+		}
+	}
+}
+
+func TestSuccessFunc(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	status, body = http.StatusAccepted, `{"resultCode":0}`
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); StatusCode(err) != http.StatusAccepted {
+		t.Fatalf("SendNotification() with default SuccessFunc upon 202 returned '%v'; want ServerError", err)
+	}
+	os.RemoveAll(fpath)
+	os.Mkdir(fpath, 0700)
+
+	n.SuccessFunc = func(statusCode int, body []byte) bool {
+		var result struct {
+			ResultCode int `json:"resultCode"`
+		}
+		return statusCode >= 200 && statusCode < 300 && json.Unmarshal(body, &result) == nil && result.ResultCode == 0
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() with custom SuccessFunc upon 202 unexpectedly failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) != 0 {
+		t.Fatalf("SendNotification() with custom SuccessFunc left task after success")
+	}
+
+	status, body = http.StatusOK, `{"resultCode":3}`
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	var srverr *ServerError
+	if !errors.As(err, &srverr) || !srverr.TaskKept {
+		t.Fatalf("SendNotification() with custom SuccessFunc upon rejected 200 returned '%v'; want ServerError keeping task", err)
+	}
+	if entries, _ := os.ReadDir(fpath); len(entries) == 0 {
+		t.Fatalf("SendNotification() with custom SuccessFunc cleared task after failure")
+	}
+}
+
+func TestSchemaHeaders(t *testing.T) {
+	var clientSchema string
+	serverSchema := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientSchema = r.Header.Get(ClientSchemaHeader)
+		w.Header().Set(ServerSchemaHeader, serverSchema)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	if err := n.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() unexpectedly failed with matching schemas: %v", err)
+	}
+	if clientSchema != DefaultClientSchema {
+		t.Fatalf("Ping() announced schema '%v'; want '%v'", clientSchema, DefaultClientSchema)
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil || clientSchema != DefaultClientSchema {
+		t.Fatalf("SendNotification() announced schema '%v' and returned '%v'; want '%v' and success", clientSchema, err, DefaultClientSchema)
+	}
+
+	serverSchema = "2"
+	var schemaerr *SchemaMismatchError
+	if err := n.Ping(context.Background()); !errors.As(err, &schemaerr) || schemaerr.ServerSchema != "2" || schemaerr.ClientSchema != DefaultClientSchema {
+		t.Fatalf("Ping() against server with other schema returned '%v'; want SchemaMismatchError", err)
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() failed upon schema mismatch instead of warning: %v", err)
+	}
+
+	n.ClientSchema = "2"
+	if err := n.Ping(context.Background()); err != nil || clientSchema != "2" {
+		t.Fatalf("Ping() with ClientSchema announced '%v' and returned '%v'; want '2' and success", clientSchema, err)
+	}
+}
+
+func TestPersistencyStorage(t *testing.T) {
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := fscache.NewMemStorage()
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyStorage: storage}
+	err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	var srverr *ServerError
+	if !errors.As(err, &srverr) || !srverr.TaskKept {
+		t.Fatalf("SendNotification() with PersistencyStorage upon failure returned '%v'; want ServerError keeping task", err)
+	}
+	if entries, _ := storage.List("."); len(entries) == 0 {
+		t.Fatalf("SendNotification() with PersistencyStorage failed to persist task into it")
+	}
+
+	failing = false
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 1 || sent != 1 {
+		t.Fatalf("ReplayOutstandingTasks() from PersistencyStorage = found %v, sent %v, err %v; want 1, 1, nil", found, sent, err)
+	}
+	if entries, _ := storage.List("."); len(entries) != 0 {
+		t.Fatalf("ReplayOutstandingTasks() left %v in PersistencyStorage after delivering", entries)
+	}
+
+	n.PersistencyDir = "/tmp"
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted both PersistencyDir and PersistencyStorage")
+	}
+}
+
+func TestReplayTask(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	failing := true
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("user"))
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	n.SendNotification("first", "ev", map[string]string{}, []string{}, "")
+	n.SendNotification("second", "ev", map[string]string{}, []string{}, "")
+	keys, _ := os.ReadDir(fpath)
+	var tasknames []string
+	for _, key := range keys {
+		if taskname, isurl := strings.CutSuffix(key.Name(), "_url"); isurl {
+			if pn, _ := n.LoadTask(taskname); pn != nil && pn.Recipient == "first" {
+				tasknames = append(tasknames, taskname)
+			}
+		}
+	}
+	if len(tasknames) != 1 {
+		t.Fatalf("SendNotification() failed to persist task for recipient 'first': %v", keys)
+	}
+	taskname := tasknames[0]
+
+	requested = nil
+	var srverr *ServerError
+	if err := n.ReplayTask(taskname); !errors.As(err, &srverr) || !srverr.TaskKept {
+		t.Fatalf("ReplayTask() upon failure returned '%v'; want ServerError keeping task", err)
+	}
+	failing = false
+	if err := n.ReplayTask(taskname); err != nil {
+		t.Fatalf("ReplayTask() unexpectedly failed: %v", err)
+	}
+	if strings.Join(requested, ",") != "first,first" {
+		t.Fatalf("ReplayTask() requested %v; want only the given task", requested)
+	}
+	if _, err := n.LoadTask(taskname); err == nil {
+		t.Fatalf("ReplayTask() left task in journal after delivering it")
+	}
+	if err := n.ReplayTask(taskname); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("ReplayTask() of cleared task returned '%v'; want ErrTaskNotFound", err)
+	}
+	if found, _, _, _ := n.ReplayOutstandingTasks(time.Hour, false); found != 1 {
+		t.Fatalf("ReplayTask() affected other tasks: %v left; want 1", found)
+	}
+}
+
+func TestRequestInterceptor(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	refuse := errors.New("signing key unavailable")
+	signing := false
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	n.RequestInterceptor = func(r *http.Request) error {
+		if !signing {
+			return refuse
+		}
+		r.Header.Set("X-Signature", "signed:"+r.URL.Query().Get("user"))
+		return nil
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, refuse) || len(signatures) != 0 {
+		t.Fatalf("SendNotification() with failing RequestInterceptor returned '%v' after %v requests; want its error and no request", err, len(signatures))
+	}
+
+	signing = true
+	if err := n.SendNotification("637", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() with RequestInterceptor unexpectedly failed: %v", err)
+	}
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 1 || sent != 1 {
+		t.Fatalf("ReplayOutstandingTasks() with RequestInterceptor = found %v, sent %v, err %v; want 1, 1, nil", found, sent, err)
+	}
+	if strings.Join(signatures, ",") != "signed:637,signed:636" {
+		t.Fatalf("RequestInterceptor changes reached server as %v; want each request signed", signatures)
+	}
+}
+
+func TestValidateParamKeys(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: api_base_test, Scope: "testScope"}
+	params := map[string]string{"amount": "10.2", "invoice.number": "1", "_ok": "x"}
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, ""); err != nil {
+		t.Fatalf("BuildRequest() without ValidateParamKeys unexpectedly failed on exotic key: %v", err)
+	}
+	n.ValidateParamKeys = true
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, ""); err == nil || !strings.Contains(err.Error(), "invoice.number") {
+		t.Fatalf("BuildRequest() with ValidateParamKeys returned '%v'; want error naming key 'invoice.number'", err)
+	}
+	for _, key := range []string{"my key", "1st", ""} {
+		if _, _, err := n.BuildRequest("636", "ev", map[string]string{key: "x"}, []string{}, ""); err == nil {
+			t.Fatalf("BuildRequest() with ValidateParamKeys unexpectedly accepted key '%v'", key)
+		}
+	}
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{"amount": "1", "_ok": "x", "Var_2": "y"}, []string{}, ""); err != nil {
+		t.Fatalf("BuildRequest() with ValidateParamKeys unexpectedly failed on valid keys: %v", err)
+	}
+}
+
+func TestCompressPersisted(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	failing := true
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	params := map[string]string{"report": strings.Repeat("all work and no play makes jack a dull boy ", 500)}
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	// uncompressed task, journalled before enabling CompressPersisted
+	n.SendNotification("plain", "ev", params, []string{}, "")
+	n.CompressPersisted = true
+	n.SendNotification("compressed", "ev", params, []string{}, "")
+
+	entries, _ := os.ReadDir(fpath)
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		if taskname, isbody := strings.CutSuffix(entry.Name(), "_body"); isbody {
+			pn, err := n.LoadTask(taskname)
+			if err != nil || pn.Params["report"] != params["report"] {
+				t.Fatalf("LoadTask(%v) failed to read back params of task (err=%v)", taskname, err)
+			}
+			info, _ := entry.Info()
+			sizes[pn.Recipient] = info.Size()
+		}
+	}
+	if sizes["plain"] == 0 || sizes["compressed"] == 0 || sizes["compressed"] >= sizes["plain"]/10 {
+		t.Fatalf("CompressPersisted stored body of %v bytes, vs %v uncompressed; want it to shrink", sizes["compressed"], sizes["plain"])
+	}
+
+	failing = false
+	bodies = nil
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 2 || sent != 2 {
+		t.Fatalf("ReplayOutstandingTasks() with compressed tasks = found %v, sent %v, err %v; want 2, 2, nil", found, sent, err)
+	}
+	for _, body := range bodies {
+		if !bytes.Equal(body, bodies[0]) || !strings.Contains(string(body), "dull boy") {
+			t.Fatalf("ReplayOutstandingTasks() sent body '%.40v...' differing from original notification", string(body))
+		}
+	}
+}
+
+func TestSendNotificationAndWait(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	status := http.StatusBadGateway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	if err := n.SendNotificationAndWait(context.Background(), "636", "ev", map[string]string{}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotificationAndWait() without persistency upon failed delivery unexpectedly succeeded")
+	}
+
+	n.PersistencyDir = fpath
+	if err := n.SendNotificationAndWait(context.Background(), "636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotificationAndWait() upon failed delivery of journaled task returned '%v'; want nil", err)
+	}
+	if found, _, _, _ := n.ReplayOutstandingTasks(time.Hour, false); found != 1 {
+		t.Fatalf("SendNotificationAndWait() succeeded upon failed delivery, but journaled %v tasks; want 1", found)
+	}
+
+	// task cleared upon unknown endpoint, so neither delivered nor journaled
+	status = http.StatusNotFound
+	if err := n.SendNotificationAndWait(context.Background(), "636", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, ErrEndpointNotFound) {
+		t.Fatalf("SendNotificationAndWait() upon cleared task returned '%v'; want ErrEndpointNotFound", err)
+	}
+
+	// journal unavailable
+	status = http.StatusBadGateway
+	os.RemoveAll(fpath)
+	if err := n.SendNotificationAndWait(context.Background(), "636", "ev", map[string]string{}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotificationAndWait() unexpectedly succeeded when neither delivering nor journaling")
+	}
+	status = http.StatusOK
+	if err := n.SendNotificationAndWait(context.Background(), "636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotificationAndWait() upon delivery without journal returned '%v'; want nil", err)
+	}
+}
+
+// storage counting the accesses to task URL parts
+type countingStorage struct {
+	*fscache.MemStorage
+	opens, stats atomic.Int32
+}
+
+func (s *countingStorage) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, "_url") {
+		s.opens.Add(1)
+	}
+	return s.MemStorage.Open(name)
+}
+
+func (s *countingStorage) Stat(name string) (fs.FileInfo, error) {
+	if strings.HasSuffix(name, "_url") {
+		s.stats.Add(1)
+	}
+	return s.MemStorage.Stat(name)
+}
+
+func TestTasksByCreationAccess(t *testing.T) {
+	storage := &countingStorage{MemStorage: fscache.NewMemStorage()}
+	cache, err := fscache.NewWithStorage(storage)
+	if err != nil {
+		t.Fatalf("NewWithStorage() failed: %v", err)
+	}
+	keys := []string{"1700000002_aa_url", "1700000001_bb_url", "1700000001_aa_url", "legacy_url", "1700000001_aa_body"}
+	for _, key := range keys {
+		cache.Set(key, []byte("x"))
+	}
+	storage.opens.Store(0)
+	storage.stats.Store(0)
+	tasks := tasksByCreation(cache, keys)
+	if len(tasks) != 4 || tasks[2] != "1700000002_aa" || tasks[3] != "legacy" {
+		t.Fatalf("tasksByCreation() = %v; want 4 tasks, the one created just now by modification time last", tasks)
+	}
+	// only the task lacking a timestamp and those sharing their second are stat'ed
+	if opens, stats := storage.opens.Load(), storage.stats.Load(); opens != 0 || stats != 3 {
+		t.Fatalf("tasksByCreation() opened %v and stat'ed %v task URLs; want 0 and 3", opens, stats)
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query().Get("user"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	now := time.Now()
+	for _, task := range []struct {
+		name      string
+		recipient string
+		mtime     time.Time
+	}{
+		// lexical order differs from creation order across digit rollovers, and within the same second
+		{"1700000000_zz", "third", now.Add(-2 * time.Second)},
+		{"999999999_aa", "first", now},
+		{"1700000000_aa", "fourth", now.Add(-time.Second)},
+		{"1000000000_cc", "second", now},
+	} {
+		urlstr, body, _ := n.BuildRequest(task.recipient, "ev", map[string]string{}, []string{}, "")
+		os.WriteFile(path.Join(fpath, task.name+"_url"), []byte(urlstr), 0600)
+		os.WriteFile(path.Join(fpath, task.name+"_body"), body, 0600)
+		os.Chtimes(path.Join(fpath, task.name+"_url"), task.mtime, task.mtime)
+	}
+
+	if found, sent, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 4 || sent != 4 {
+		t.Fatalf("ReplayOutstandingTasks() = found %v, sent %v, err %v; want 4, 4, nil", found, sent, err)
+	}
+	if strings.Join(requested, ",") != "first,second,third,fourth" {
+		t.Fatalf("ReplayOutstandingTasks() replayed in order %v; want oldest first", requested)
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	var template []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template = r.URL.Query()["template"]
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{}); err != nil || template != nil {
+		t.Fatalf("SendNotificationOptions() without TemplateOverride sent template %v (err=%v); want none", template, err)
+	}
+	seasonal := "winter-2024"
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{TemplateOverride: &seasonal}); err != nil || len(template) != 1 || template[0] != seasonal {
+		t.Fatalf("SendNotificationOptions() with TemplateOverride sent template %v (err=%v); want [%v]", template, err, seasonal)
+	}
+	for _, invalid := range []string{"", "winter 2024", "../default"} {
+		template = nil
+		if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{TemplateOverride: &invalid}); err == nil || template != nil {
+			t.Fatalf("SendNotificationOptions() unexpectedly sent invalid TemplateOverride '%v'", invalid)
+		}
+	}
+	n.ExtraQueryParams = map[string]string{"template": "x"}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ExtraQueryParams overriding template")
+	}
+}
+
+func TestArchiveSent(t *testing.T) {
+	archpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(archpath)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", ArchiveSent: true}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ArchiveSent without ArchiveDir")
+	}
+	n.ArchiveDir = archpath
+
+	tstart := time.Now()
+	params := map[string]string{"amount": "10.20"}
+	if err := n.SendNotification("636", "ev", params, []string{}, "audit/1"); err != nil {
+		t.Fatalf("SendNotification() with ArchiveSent unexpectedly failed: %v", err)
+	}
+	urlstr, body, sentAt, err := n.LookupSent("audit/1")
+	if err != nil {
+		t.Fatalf("LookupSent() unexpectedly failed for delivered correlationId: %v", err)
+	}
+	wantURL, wantBody, _ := n.BuildRequest("636", "ev", params, []string{}, "audit/1")
+	if urlstr != wantURL || !bytes.Equal(body, wantBody) {
+		t.Fatalf("LookupSent() = '%v', '%v'; want '%v', '%v'", urlstr, string(body), wantURL, string(wantBody))
+	}
+	if sentAt.Before(tstart.Add(-time.Second)) || sentAt.After(time.Now()) {
+		t.Fatalf("LookupSent() reports delivery at %v; want between %v and now", sentAt, tstart)
+	}
+	if _, _, _, err := n.LookupSent("unknown"); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("LookupSent() of unknown correlationId returned '%v'; want ErrNotArchived", err)
+	}
+
+	// reusing the correlationId for another recipient keeps both deliveries
+	if err := n.SendNotification("637", "ev", params, []string{}, "audit/1"); err != nil {
+		t.Fatalf("SendNotification() with ArchiveSent unexpectedly failed: %v", err)
+	}
+	otherURL, _, _ := n.BuildRequest("637", "ev", params, []string{}, "audit/1")
+	records, err := n.LookupSentAll("audit/1")
+	if err != nil || len(records) != 2 || records[0].URL != wantURL || records[1].URL != otherURL {
+		t.Fatalf("LookupSentAll() of correlationId delivered twice = %+v, %v; want both deliveries, oldest first", records, err)
+	}
+	if urlstr, _, _, err := n.LookupSent("audit/1"); err != nil || urlstr != otherURL {
+		t.Fatalf("LookupSent() of correlationId delivered twice = '%v', %v; want the last delivery '%v'", urlstr, err, otherURL)
+	}
+}
+
+func TestExtraQueryValues(t *testing.T) {
+	n := TattlerClientHTTP{
+		Endpoint:         api_base_test,
+		Scope:            "testScope",
+		ExtraQueryParams: map[string]string{"tag": "first"},
+		ExtraQueryValues: url.Values{"tag": {"second", "third"}, "region": {"eu west", "us&east"}},
+	}
+	qparams, err := n.QueryParams("636", "ev", []string{"email", "sms"}, "corr1")
+	if err != nil {
+		t.Fatalf("QueryParams() with ExtraQueryValues unexpectedly failed: %v", err)
+	}
+	if !slices.Equal(qparams["tag"], []string{"first", "second", "third"}) || !slices.Equal(qparams["region"], []string{"eu west", "us&east"}) {
+		t.Fatalf("QueryParams() with ExtraQueryValues = %v; want values merged in order", qparams)
+	}
+	urlstr, _, _ := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "corr1")
+	if !strings.Contains(urlstr, "region=eu+west&region=us%26east") {
+		t.Fatalf("BuildRequest() with ExtraQueryValues = '%v'; want repeated keys, escaped", urlstr)
+	}
+	if again, _, _ := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "corr1"); again != urlstr {
+		t.Fatalf("BuildRequest() with ExtraQueryValues is not deterministic: '%v' then '%v'", urlstr, again)
+	}
+
+	n.ExtraQueryValues = url.Values{"vector": {"sms"}}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ExtraQueryValues for reserved key without AllowReservedExtraQuery")
+	}
+	n.AllowReservedExtraQuery = true
+	qparams, err = n.QueryParams("636", "ev", []string{"email"}, "corr1")
+	if err != nil || !slices.Equal(qparams["vector"], []string{"email", "sms"}) {
+		t.Fatalf("QueryParams() with AllowReservedExtraQuery = %v (err=%v); want vector repeated", qparams["vector"], err)
+	}
+}
+
+func TestVerifyPersisted(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope", PersistencyDir: fpath}
+	if problems, err := n.VerifyPersisted(); err != nil || len(problems) != 0 {
+		t.Fatalf("VerifyPersisted() on empty journal = %v, %v; want no problems", problems, err)
+	}
+	n.SendNotification("636", "ev", map[string]string{"amount": "10"}, []string{}, "")
+	n.CompressPersisted = true
+	n.SendNotification("637", "ev", map[string]string{"amount": "10"}, []string{}, "")
+
+	urlstr, _, _ := n.BuildRequest("636", "ev", map[string]string{}, []string{}, "")
+	for name, content := range map[string]string{
+		"1_aa_url":  urlstr,
+		"2_bb_url":  "/notification/testScope/ev/?mode=debug&user=636",
+		"2_bb_body": `{"amount": "10"`,
+		"3_cc_body": `{}`,
+		"4_dd_url":  strings.Replace(urlstr, "user=636", "other=636", 1),
+		"4_dd_body": `{}`,
+		"4_dd_meta": `not json`,
+	} {
+		os.WriteFile(path.Join(fpath, name), []byte(content), 0600)
+	}
+	before, _ := os.ReadDir(fpath)
+
+	problems, err := n.VerifyPersisted()
+	if err != nil {
+		t.Fatalf("VerifyPersisted() unexpectedly failed: %v", err)
+	}
+	got := map[string]int{}
+	for _, problem := range problems {
+		got[problem.Task]++
+	}
+	want := map[string]int{"1_aa": 1, "2_bb": 2, "3_cc": 1, "4_dd": 2}
+	if len(got) != len(want) {
+		t.Fatalf("VerifyPersisted() reported problems %v; want them for tasks %v only", problems, want)
+	}
+	for task, count := range want {
+		if got[task] != count {
+			t.Fatalf("VerifyPersisted() reported %v problems for task %v; want %v (all: %v)", got[task], task, count, problems)
+		}
+	}
+	if after, _ := os.ReadDir(fpath); len(after) != len(before) {
+		t.Fatalf("VerifyPersisted() modified the journal: %v entries before, %v after", len(before), len(after))
+	}
+}
+
+func TestScopeInHeader(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var reqpath, scope string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqpath, scope = r.URL.Path, r.Header.Get("X-Gateway-Scope")
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", ScopeHeader: "X-Gateway-Scope", PersistencyDir: fpath}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted ScopeHeader without ScopeInHeader")
+	}
+	n.ScopeInHeader = true
+	n.SendNotification("636", "ev", map[string]string{}, []string{}, "")
+	if reqpath != "/notification/ev/" || scope != "testScope" {
+		t.Fatalf("SendNotification() with ScopeInHeader requested path '%v' with scope header '%v'; want '/notification/ev/' and 'testScope'", reqpath, scope)
+	}
+
+	keys, _ := os.ReadDir(fpath)
+	for _, key := range keys {
+		if taskname, isurl := strings.CutSuffix(key.Name(), "_url"); isurl {
+			if pn, err := n.LoadTask(taskname); err != nil || pn.Scope != "testScope" || pn.EventName != "ev" {
+				t.Fatalf("LoadTask() of task sent with ScopeInHeader = %+v, %v; want scope from header", pn, err)
+			}
+		}
+	}
+	failing = false
+	reqpath, scope = "", ""
+	if _, sent, _, _ := n.ReplayOutstandingTasks(time.Hour, true); sent != 1 || scope != "testScope" || reqpath != "/notification/ev/" {
+		t.Fatalf("ReplayOutstandingTasks() with ScopeInHeader sent %v tasks to path '%v' with scope header '%v'", sent, reqpath, scope)
+	}
+
+	n.ScopeHeader = "Bad Header"
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted invalid ScopeHeader")
+	}
+	n.ScopeHeader = ""
+	if err := n.ValidateConfiguration(); err != nil || n.ScopeHeader != DefaultScopeHeader {
+		t.Fatalf("ValidateConfiguration() with ScopeInHeader set ScopeHeader '%v' (err=%v); want default", n.ScopeHeader, err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy unavailable")
+}
+
+func TestRandFailure(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	randReader = failingReader{}
+	defer func() { randReader = crand.Reader }()
+
+	if id, err := newCorrelationId(); err == nil || id != "" {
+		t.Fatalf("newCorrelationId() with failing rand = '%v', %v; want error", id, err)
+	}
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope", PersistencyDir: fpath}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err == nil || !strings.Contains(err.Error(), "correlation id") {
+		t.Fatalf("SendNotification() with failing rand returned err=%v; want correlation id error", err)
+	}
+
+	names := map[string]bool{}
+	for range 3 {
+		taskname, err := n.PersistTask("http://127.0.0.1:1/notification/testScope/ev/?user=636", []byte("{}"))
+		if err != nil || taskname == "" || names[taskname] {
+			t.Fatalf("PersistTask() with failing rand = '%v', %v; want a new task name", taskname, err)
+		}
+		names[taskname] = true
+		if _, err := n.LoadTask(taskname); err != nil {
+			t.Fatalf("LoadTask(%v) of task persisted with failing rand failed: %v", taskname, err)
+		}
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test socket: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	listener, err := net.Listen("unix", path.Join(fpath, "tattler.sock"))
+	if err != nil {
+		t.Fatalf("Could not listen on unix socket: %v", err)
+	}
+	var reqpath string
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqpath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	n := TattlerClientHTTP{SocketPath: path.Join(fpath, "tattler.sock"), Scope: "testScope"}
+	if err := n.ValidateConfiguration(); err != nil || n.Endpoint != DefaultSocketEndpoint {
+		t.Fatalf("ValidateConfiguration() with SocketPath set Endpoint '%v' (err=%v); want default", n.Endpoint, err)
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() over unix socket failed: %v", err)
+	}
+	if reqpath != "/notification/testScope/ev/" {
+		t.Fatalf("SendNotification() over unix socket requested path '%v'; want '/notification/testScope/ev/'", reqpath)
+	}
+	if err := n.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() over unix socket failed: %v", err)
+	}
+
+	n.FailoverEndpoints = []string{"http://127.0.0.1:1"}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted FailoverEndpoints along with SocketPath")
+	}
+}
+
+func TestVerifyCorrelationEcho(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var echo string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if echo != "" {
+			w.Header().Set(CorrelationIdHeader, echo)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath, VerifyCorrelationEcho: true}
+	echo = "other"
+	err = n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc")
+	var mismatch *CorrelationMismatchError
+	if !errors.Is(err, ErrCorrelationMismatch) || !errors.As(err, &mismatch) || mismatch.Sent != "abc" || mismatch.Echoed != "other" {
+		t.Fatalf("SendNotification() with mismatched echo returned err=%v; want CorrelationMismatchError", err)
+	}
+	if keys, _ := os.ReadDir(fpath); len(keys) == 0 {
+		t.Fatalf("SendNotification() with mismatched echo did not keep its task")
+	}
+	for _, echo = range []string{"abc", ""} {
+		if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc"); err != nil {
+			t.Fatalf("SendNotification() with echo '%v' failed: %v", echo, err)
+		}
+	}
+	echo = "other"
+	n.VerifyCorrelationEcho = false
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc"); err != nil {
+		t.Fatalf("SendNotification() without VerifyCorrelationEcho failed: %v", err)
+	}
+}
+
+func TestStrictResponseValidation(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var contentType, respbody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(respbody))
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath, StrictResponseValidation: true}
+	for _, tc := range []struct{ contentType, body string }{
+		{"text/html; charset=utf-8", "<html><body>Welcome to nginx!</body></html>"},
+		{"text/html", `{"correlationId":"abc"}`},
+		{"application/json", "not json"},
+		{"application/json", `"a string"`},
+		{"application/json", ""},
+	} {
+		contentType, respbody = tc.contentType, tc.body
+		err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc")
+		var invalid *InvalidResponseError
+		if !errors.Is(err, ErrInvalidResponse) || !errors.As(err, &invalid) || invalid.ContentType != tc.contentType {
+			t.Fatalf("SendNotification() with 200 response of %v '%v' returned err=%v; want InvalidResponseError", tc.contentType, tc.body, err)
+		}
+	}
+	if res, _ := n.ReplayOutstandingTasksOptions(ReplayOptions{}); res.Found != 5 || res.Failed != 5 {
+		t.Fatalf("SendNotification() with invalid responses kept tasks %+v; want all 5 kept", res)
+	}
+
+	for _, respbody = range []string{`{"correlationId":"abc"}`, `[{"id":"email:49b99061","vector":"email","resultCode":0}]`} {
+		contentType = "application/json; charset=utf-8"
+		if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc"); err != nil {
+			t.Fatalf("SendNotification() with JSON response '%v' failed: %v", respbody, err)
+		}
+	}
+	contentType, respbody = "text/html", "<html></html>"
+	n.StrictResponseValidation = false
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, "abc"); err != nil {
+		t.Fatalf("SendNotification() without StrictResponseValidation failed on 200 response: %v", err)
+	}
+}
+
+func TestPreRenderParams(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope"}
+	params := map[string]string{"name": "Ann", "greeting": "Hello {{.name}} <3"}
+	_, body, err := n.BuildRequest("636", "ev", params, []string{}, "abc")
+	if err != nil || !strings.Contains(string(body), `"Hello {{.name}} <3"`) {
+		t.Fatalf("BuildRequest() without PreRenderParams = '%s', %v; want templates sent as given", body, err)
+	}
+
+	n.PreRenderParams = true
+	_, body, err = n.BuildRequest("636", "ev", params, []string{}, "abc")
+	if err != nil || !strings.Contains(string(body), `"greeting":"Hello Ann <3"`) {
+		t.Fatalf("BuildRequest() with PreRenderParams = '%s', %v; want greeting expanded", body, err)
+	}
+	if params["greeting"] != "Hello {{.name}} <3" {
+		t.Fatalf("BuildRequest() with PreRenderParams altered the caller's params: %v", params)
+	}
+
+	params["farewell"] = "Bye {{.nickname}}"
+	if _, _, err = n.BuildRequest("636", "ev", params, []string{}, "abc"); err == nil || !strings.Contains(err.Error(), "farewell") || !strings.Contains(err.Error(), "nickname") {
+		t.Fatalf("BuildRequest() with PreRenderParams referencing undefined param returned err=%v; want error naming it", err)
+	}
+	params["farewell"] = "Bye {{.name"
+	if _, _, err = n.BuildRequest("636", "ev", params, []string{}, "abc"); err == nil || !strings.Contains(err.Error(), "farewell") {
+		t.Fatalf("BuildRequest() with PreRenderParams of invalid template returned err=%v; want error", err)
+	}
+}
+
+func TestIsValidMode(t *testing.T) {
+	n := TattlerClientHTTP{}
+	if !slices.Equal(n.AllowedModeNames(), NotificationModes) {
+		t.Fatalf("AllowedModeNames() = %v; want NotificationModes %v", n.AllowedModeNames(), NotificationModes)
+	}
+	if !n.IsValidMode("production") || !n.IsValidMode(" debug ") || n.IsValidMode("canary") || n.IsValidMode("") {
+		t.Fatalf("IsValidMode() disagrees with NotificationModes %v", NotificationModes)
+	}
+
+	n.AllowedModes = []string{"debug", "canary"}
+	if !slices.Equal(n.AllowedModeNames(), n.AllowedModes) {
+		t.Fatalf("AllowedModeNames() = %v; want AllowedModes %v", n.AllowedModeNames(), n.AllowedModes)
+	}
+	if !n.IsValidMode("canary") || n.IsValidMode("production") {
+		t.Fatalf("IsValidMode() disagrees with AllowedModes %v", n.AllowedModes)
+	}
+	n.AllowedModeNames()[0] = "altered"
+	if n.AllowedModes[0] != "debug" {
+		t.Fatalf("altering AllowedModeNames() result altered AllowedModes: %v", n.AllowedModes)
+	}
+}
+
+func TestNotIdempotent(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var hits, failoverHits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	failover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failoverHits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer failover.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, FailoverEndpoints: []string{failover.URL}, Scope: "testScope", PersistencyDir: fpath}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{NotIdempotent: true}); err == nil {
+		t.Fatalf("SendNotificationOptions() with NotIdempotent unexpectedly failed over")
+	}
+	if hits.Load() != 1 || failoverHits.Load() != 0 {
+		t.Fatalf("SendNotificationOptions() with NotIdempotent hit endpoint %v times and failover %v times; want once and never", hits.Load(), failoverHits.Load())
+	}
+	persistedTasks := func() []string {
+		var tasks []string
+		keys, _ := os.ReadDir(fpath)
+		for _, key := range keys {
+			if taskname, isurl := strings.CutSuffix(key.Name(), "_url"); isurl {
+				tasks = append(tasks, taskname)
+			}
+		}
+		return tasks
+	}
+	tasks := persistedTasks()
+	if len(tasks) != 1 {
+		t.Fatalf("SendNotificationOptions() with NotIdempotent journaled %v tasks; want 1", len(tasks))
+	}
+	if pn, err := n.LoadTask(tasks[0]); err != nil || !pn.NotIdempotent {
+		t.Fatalf("LoadTask() of task sent with NotIdempotent = %+v, %v; want NotIdempotent", pn, err)
+	}
+	if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: time.Hour}); err != nil || res.Skipped != 1 || hits.Load() != 1 {
+		t.Fatalf("ReplayOutstandingTasksOptions() with NotIdempotent task = %+v, %v after %v hits; want it skipped", res, err, hits.Load())
+	}
+	if err := n.ReplayTask(tasks[0]); err == nil || hits.Load() != 2 {
+		t.Fatalf("ReplayTask() of NotIdempotent task returned err=%v after %v hits; want it attempted", err, hits.Load())
+	}
+	n.ClearTask(tasks[0])
+
+	// deferred tasks were never attempted, so replays attempt them once
+	now := time.Now().UTC()
+	sinceMidnight := now.Sub(now.Truncate(24 * time.Hour))
+	n.QuietHours = &QuietHours{Start: (sinceMidnight - time.Hour + 24*time.Hour) % (24 * time.Hour), End: (sinceMidnight + time.Hour) % (24 * time.Hour)}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{NotIdempotent: true}); !errors.Is(err, ErrSuppressedQuietHours) {
+		t.Fatalf("SendNotificationOptions() with NotIdempotent during QuietHours returned err=%v; want it deferred", err)
+	}
+	tasks = persistedTasks()
+	metakname := path.Join(fpath, tasks[0]+"_meta")
+	metadata, _ := os.ReadFile(metakname)
+	var meta taskMeta
+	json.Unmarshal(metadata, &meta)
+	past := now.Add(-time.Minute)
+	meta.NotBefore = &past
+	metadata, _ = json.Marshal(meta)
+	os.WriteFile(metakname, metadata, 0600)
+	for i, want := range []ReplayResult{{Found: 1, Failed: 1}, {Found: 1, Skipped: 1}} {
+		if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{MaxTaskAge: time.Hour}); err != nil || res != want {
+			t.Fatalf("ReplayOutstandingTasksOptions() #%v of deferred NotIdempotent task = %+v, %v; want %+v", i, res, err, want)
+		}
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("replays of deferred NotIdempotent task hit endpoint %v times; want once", hits.Load()-2)
+	}
+}
+
+func TestCheckVectors(t *testing.T) {
+	vectors := []string{" Email ", "in valid", "fax", ""}
+	valid, invalid := NormalizeVectors(vectors)
+	if !slices.Equal(valid, []string{"email", "fax"}) || !slices.Equal(invalid, []string{"in valid", ""}) {
+		t.Fatalf("NormalizeVectors(%q) = %q, %q", vectors, valid, invalid)
+	}
+
+	n := TattlerClientHTTP{OnlyKnownVectors: true}
+	valid, invalid = n.CheckVectors(vectors)
+	if !slices.Equal(valid, []string{"email"}) || !slices.Equal(invalid, []string{"in valid", "fax", ""}) {
+		t.Fatalf("CheckVectors(%q) with OnlyKnownVectors = %q, %q", vectors, valid, invalid)
+	}
+	n = TattlerClientHTTP{VectorPolicy: VectorPolicyPassThrough}
+	valid, invalid = n.CheckVectors(vectors)
+	if !slices.Equal(valid, []string{"email", "in valid", "fax"}) || len(invalid) != 0 {
+		t.Fatalf("CheckVectors(%q) with VectorPolicyPassThrough = %q, %q", vectors, valid, invalid)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	n = TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{"email", "in valid"}, "", SendOptions{})
+	if err != nil || !slices.Equal(result.DroppedVectors, []string{"in valid"}) {
+		t.Fatalf("SendNotificationOptions() with invalid vector = %+v, %v; want it reported dropped", result, err)
+	}
+}
+
+func TestPersistencyFileMode(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope", PersistencyDir: fpath, PersistencyFileMode: 0640}
+	taskname, err := n.PersistTask("http://127.0.0.1:1/notification/testScope/ev/?user=636", []byte("{}"))
+	if err != nil {
+		t.Fatalf("PersistTask() with PersistencyFileMode failed: %v", err)
+	}
+	if fstat, err := os.Stat(path.Join(fpath, taskname+"_url")); err != nil {
+		t.Fatalf("Could not stat persisted task: %v", err)
+	} else if fstat.Mode().Perm() != 0640 {
+		t.Fatalf("PersistTask() with PersistencyFileMode=0640 created file with mode %v", fstat.Mode().Perm())
+	}
+
+	// a client on the same directory without the option keeps the default mode
+	plain := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope", PersistencyDir: fpath}
+	taskname, err = plain.PersistTask("http://127.0.0.1:1/notification/testScope/ev/?user=637", []byte("{}"))
+	if err != nil {
+		t.Fatalf("PersistTask() without PersistencyFileMode failed: %v", err)
+	}
+	if fstat, err := os.Stat(path.Join(fpath, taskname+"_url")); err != nil {
+		t.Fatalf("Could not stat persisted task: %v", err)
+	} else if fstat.Mode().Perm() != 0600 {
+		t.Fatalf("PersistTask() without PersistencyFileMode created file with mode %v, inherited from another client", fstat.Mode().Perm())
+	}
+}
+
+func TestDebugRecipientAllowlist(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n, err := NewClient(TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", DebugRecipientAllowlist: []string{"636"}})
+	if err != nil {
+		t.Fatalf("NewClient() unexpectedly failed: %v", err)
+	}
+	if err := n.SendNotification("636", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() to allowed recipient failed: %v", err)
+	}
+	if err := n.SendNotification("737", "ev", map[string]string{}, []string{}, ""); !errors.Is(err, ErrRecipientNotAllowed) {
+		t.Fatalf("SendNotification() to recipient outside allowlist returned err=%v; want ErrRecipientNotAllowed", err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("SendNotification() to recipient outside allowlist reached the server")
+	}
+
+	n.Mode = "production"
+	if err := n.SendNotification("737", "ev", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() in mode production to recipient outside allowlist failed: %v", err)
+	}
+}
+
+func TestCorrelationIdPlacement(t *testing.T) {
+	var queryId string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queryId = r.URL.Query().Get("correlationId")
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", CorrelationIdBodyKey: "corrId"}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted CorrelationIdBodyKey with CorrelationIdInQuery")
+	}
+
+	n.CorrelationIdPlacement = CorrelationIdInQueryAndBody
+	for _, corrid := range []string{"abc", ""} {
+		result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{"name": "Ann"}, []string{}, corrid, SendOptions{})
+		if err != nil || queryId == "" || body["corrId"] != queryId || body["name"] != "Ann" || result.CorrelationId != queryId {
+			t.Fatalf("SendNotificationOptions(%q) with CorrelationIdInQueryAndBody = %+v, %v sent query id '%v' and body %v", corrid, result, err, queryId, body)
+		}
+		if corrid != "" && queryId != corrid {
+			t.Fatalf("SendNotificationOptions(%q) with CorrelationIdInQueryAndBody sent id '%v'", corrid, queryId)
+		}
+	}
+
+	n.CorrelationIdPlacement = CorrelationIdInBody
+	n.CorrelationIdBodyKey = ""
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if err != nil || queryId != "" || body[DefaultCorrelationIdBodyKey] == "" || result.CorrelationId != body[DefaultCorrelationIdBodyKey] {
+		t.Fatalf("SendNotificationOptions() with CorrelationIdInBody = %+v, %v sent query id '%v' and body %v", result, err, queryId, body)
+	}
+
+	if err := n.SendNotification("636", "ev", map[string]string{DefaultCorrelationIdBodyKey: "mine"}, []string{}, ""); err == nil {
+		t.Fatalf("SendNotification() unexpectedly overwrote param '%v' with correlationId", DefaultCorrelationIdBodyKey)
+	}
+}
+
+func TestRejectReservedParamKeys(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope"}
+	params := map[string]string{"name": "Ann", "mode": "production"}
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, "abc"); err != nil {
+		t.Fatalf("BuildRequest() without RejectReservedParamKeys failed for reserved key: %v", err)
+	}
+
+	n.RejectReservedParamKeys = true
+	if _, _, err := n.BuildRequest("636", "ev", params, []string{}, "abc"); err == nil || !strings.Contains(err.Error(), "'mode'") {
+		t.Fatalf("BuildRequest() with RejectReservedParamKeys returned err=%v; want error naming 'mode'", err)
+	}
+	n.CorrelationIdPlacement, n.CorrelationIdBodyKey = CorrelationIdInQueryAndBody, "corrId"
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{"corrId": "x"}, []string{}, "abc"); err == nil || !strings.Contains(err.Error(), "'corrId'") {
+		t.Fatalf("BuildRequest() with RejectReservedParamKeys returned err=%v; want error naming CorrelationIdBodyKey", err)
+	}
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{"name": "Ann"}, []string{}, "abc"); err != nil {
+		t.Fatalf("BuildRequest() with RejectReservedParamKeys failed for unreserved keys: %v", err)
+	}
+}
+
+func TestDrainBeforeSend(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var mux sync.Mutex
+	var received []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		received = append(received, path.Base(r.URL.Path))
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", DrainBeforeSend: true}
+	if err := n.ValidateConfiguration(); err == nil {
+		t.Fatalf("ValidateConfiguration() unexpectedly accepted DrainBeforeSend without persistency")
+	}
+	n.PersistencyDir = fpath
+	n.DrainMaxTasks = 2
+	for _, ev := range []string{"old1", "old2", "old3"} {
+		n.SendNotification("636", ev, map[string]string{}, []string{}, "")
+	}
+	// draining stops at the first failure, so each failing send tries only the oldest task before its own
+	if want := []string{"old1", "old1", "old2", "old1", "old3"}; !slices.Equal(received, want) {
+		t.Fatalf("SendNotification() with DrainBeforeSend against failing server sent %v; want %v", received, want)
+	}
+
+	received, failing = nil, false
+	if err := n.SendNotification("636", "new", map[string]string{}, []string{}, ""); err != nil {
+		t.Fatalf("SendNotification() with DrainBeforeSend failed: %v", err)
+	}
+	if want := []string{"old1", "old2", "new"}; !slices.Equal(received, want) {
+		t.Fatalf("SendNotification() with DrainBeforeSend sent %v; want DrainMaxTasks oldest tasks first, then %v", received, want)
+	}
+	received = nil
+	if res, err := n.ReplayOutstandingTasksOptions(ReplayOptions{}); err != nil || res.Replayed != 1 || !slices.Equal(received, []string{"old3"}) {
+		t.Fatalf("ReplayOutstandingTasksOptions() after draining = %+v, %v and sent %v; want old3 left", res, err, received)
+	}
+}
+
+func TestDrainBeforeSendConcurrent(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var mux sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// keep sends in flight while others drain
+		time.Sleep(50 * time.Millisecond)
+		mux.Lock()
+		defer mux.Unlock()
+		received[path.Base(r.URL.Path)]++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath, DrainBeforeSend: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(ev string) {
+			defer wg.Done()
+			if err := n.SendNotification("636", ev, map[string]string{}, []string{}, ""); err != nil {
+				t.Errorf("SendNotification() of %v with DrainBeforeSend failed: %v", ev, err)
+			}
+		}(fmt.Sprintf("ev%v", i))
+		// let the previous send journal its task first
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	for ev, count := range received {
+		if count != 1 {
+			t.Fatalf("Concurrent SendNotification() with DrainBeforeSend delivered %v %v times; want once (received %v)", ev, count, received)
+		}
+	}
+	if len(received) != 8 {
+		t.Fatalf("Concurrent SendNotification() with DrainBeforeSend delivered %v; want 8 events", received)
+	}
+}
+
+func TestSenderOverride(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"}
+	params := map[string]string{"name": "Ann"}
+	opts := SendOptions{From: "Brand <noreply@brand.example>", ReplyTo: "support@brand.example"}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", params, []string{"email"}, "", opts); err != nil {
+		t.Fatalf("SendNotificationOptions() with sender override failed: %v", err)
+	}
+	if body[FromParam] != opts.From || body[ReplyToParam] != opts.ReplyTo || body["name"] != "Ann" {
+		t.Fatalf("SendNotificationOptions() with sender override sent body %v", body)
+	}
+	if len(params) != 1 {
+		t.Fatalf("SendNotificationOptions() with sender override altered the caller's params: %v", params)
+	}
+
+	opts = SendOptions{From: "Brand"}
+	for _, vectors := range [][]string{{"email"}, {}} {
+		if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", params, vectors, "", opts); err == nil {
+			t.Fatalf("SendNotificationOptions() to vectors %q unexpectedly accepted From '%v'", vectors, opts.From)
+		}
+	}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", params, []string{"sms"}, "", opts); err != nil || body[FromParam] != "Brand" {
+		t.Fatalf("SendNotificationOptions() by sms with From '%v' sent body %v (err=%v)", opts.From, body, err)
+	}
+	params[FromParam] = "mine"
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", params, []string{"sms"}, "", opts); err == nil {
+		t.Fatalf("SendNotificationOptions() unexpectedly overwrote param '%v' with From", FromParam)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	var query url.Values
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, body = r.URL.Query(), nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n, err := NewClient(TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope"})
+	if err != nil {
+		t.Fatalf("NewClient() unexpectedly failed: %v", err)
+	}
+	opts := SendOptions{Labels: map[string]string{"campaign": "spring24", "tenant": "acme"}}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{"name": "Ann"}, nil, "", opts); err != nil {
+		t.Fatalf("SendNotificationOptions() with Labels failed: %v", err)
+	}
+	if query.Get(LabelParamPrefix+"campaign") != "spring24" || query.Get(LabelParamPrefix+"tenant") != "acme" {
+		t.Fatalf("SendNotificationOptions() with Labels sent query %v", query)
+	}
+	if len(body) != 1 || body["name"] != "Ann" {
+		t.Fatalf("SendNotificationOptions() with Labels sent body %v; want params only", body)
+	}
+
+	for _, key := range []string{"", "1st", "with space", "a-b"} {
+		opts := SendOptions{Labels: map[string]string{key: "x"}}
+		if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, nil, "", opts); err == nil {
+			t.Fatalf("SendNotificationOptions() unexpectedly accepted label key %q", key)
+		}
+	}
+	opts = SendOptions{Labels: map[string]string{"arm": "\xff"}}
+	if _, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, nil, "", opts); err == nil {
+		t.Fatalf("SendNotificationOptions() unexpectedly accepted label value not in UTF-8")
+	}
+}
+
+func TestMaxVectors(t *testing.T) {
+	n := TattlerClientHTTP{Endpoint: "http://127.0.0.1:1", Scope: "testScope"}
+	vectors := make([]string, 1000)
+	for i := range vectors {
+		vectors[i] = "email"
+	}
+	urlstr, _, err := n.BuildRequest("636", "ev", map[string]string{}, vectors, "abc")
+	if err != nil || !strings.HasSuffix(urlstr, "&vector=email") {
+		t.Fatalf("BuildRequest() with duplicate vectors = '%v', %v; want them sent once", urlstr, err)
+	}
+
+	for i := range vectors {
+		vectors[i] = fmt.Sprintf("v%v", i%(DefaultMaxVectors+1))
+	}
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, vectors, "abc"); err == nil || !strings.Contains(err.Error(), "MaxVectors") {
+		t.Fatalf("BuildRequest() with %v distinct vectors returned err=%v; want MaxVectors exceeded", DefaultMaxVectors+1, err)
+	}
+	n.MaxVectors = DefaultMaxVectors + 1
+	if _, _, err := n.BuildRequest("636", "ev", map[string]string{}, vectors, "abc"); err != nil {
+		t.Fatalf("BuildRequest() with %v distinct vectors failed despite MaxVectors=%v: %v", DefaultMaxVectors+1, n.MaxVectors, err)
+	}
+}
+
+func TestSendPending(t *testing.T) {
+	fpath, err := os.MkdirTemp("", "test.*")
+	if err != nil {
+		t.Fatalf("Could not create tmpdir to test fscache: %v", err)
+	}
+	defer os.RemoveAll(fpath)
+
+	var requests []*url.URL
+	var bodies []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL)
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", PersistencyDir: fpath}
+	n.SendNotification("636", "ev", map[string]string{"name": "Ann"}, []string{"email"}, "abc")
+	keys, _ := os.ReadDir(fpath)
+	var taskname string
+	for _, key := range keys {
+		if name, isurl := strings.CutSuffix(key.Name(), "_url"); isurl {
+			taskname = name
+		}
+	}
+	pn, err := n.LoadTask(taskname)
+	if err != nil {
+		t.Fatalf("LoadTask() unexpectedly failed: %v", err)
+	}
+
+	failing = false
+	if err := n.SendPending(context.Background(), *pn); err != nil {
+		t.Fatalf("SendPending() of loaded task failed: %v", err)
+	}
+	if len(requests) != 2 || requests[1].String() != requests[0].String() || bodies[1] != bodies[0] {
+		t.Fatalf("SendPending() of loaded task requested %v %v; want as originally %v %v", requests[1], bodies[1], requests[0], bodies[0])
+	}
+	if keys, _ := os.ReadDir(fpath); len(keys) != 0 {
+		t.Fatalf("SendPending() left %v files in PersistencyDir; want the loaded task completed", len(keys))
+	}
+	if found, _, _, err := n.ReplayOutstandingTasks(time.Hour, true); err != nil || found != 0 || len(requests) != 2 {
+		t.Fatalf("ReplayOutstandingTasks() after SendPending() found %v tasks, '%v'; want none", found, err)
+	}
+	if err := n.SendPending(context.Background(), *pn); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("SendPending() of completed task = '%v'; want ErrTaskNotFound", err)
+	}
+
+	pn.Task = ""
+	pn.Scope, pn.Mode = "otherScope", "production"
+	if err := n.SendPending(context.Background(), *pn); err != nil {
+		t.Fatalf("SendPending() with Scope and Mode overrides failed: %v", err)
+	}
+	if requests[2].Path != "/notification/otherScope/ev/" || requests[2].Query().Get("mode") != "production" || n.Scope != "testScope" || n.Mode != "debug" {
+		t.Fatalf("SendPending() with Scope and Mode overrides requested %v, leaving client scope %v and mode %v", requests[2], n.Scope, n.Mode)
+	}
+	pn.Mode = "bogus"
+	if err := n.SendPending(context.Background(), *pn); err == nil {
+		t.Fatalf("SendPending() unexpectedly accepted invalid Mode")
+	}
+	sealed, err := NewClient(n)
+	if err != nil {
+		t.Fatalf("NewClient() unexpectedly failed: %v", err)
+	}
+	pn.Scope, pn.Mode = "other/scope", ""
+	if err := sealed.SendPending(context.Background(), *pn); err == nil || len(requests) != 3 {
+		t.Fatalf("SendPending() on sealed client unexpectedly accepted invalid Scope")
+	}
+}
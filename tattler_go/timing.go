@@ -0,0 +1,94 @@
+package tattler_go
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTiming breaks down how long a request to Tattler server took; see TattlerClientHTTP.TraceTiming. Phases which
+// did not happen, e.g. resolving and connecting when a kept-alive connection is reused, are 0.
+type RequestTiming struct {
+	// Resolving the server's name
+	DNS time.Duration
+	// Establishing the TCP connection, after resolving
+	Connect time.Duration
+	// TLS handshake, after connecting
+	TLSHandshake time.Duration
+	// From starting the request until the first byte of the response arrived, including the phases above; roughly
+	// connection setup plus the server's processing time
+	TimeToFirstByte time.Duration
+	// From starting the request until its response was read
+	Total time.Duration
+	// Whether the request went over a connection kept alive from an earlier request
+	ReusedConn bool
+}
+
+// collects the timing of one request from httptrace hooks, which may fire from other goroutines, e.g. racing dials
+type timingTracer struct {
+	mux                                     sync.Mutex
+	timing                                  RequestTiming
+	start, dnsStart, connectStart, tlsStart time.Time
+}
+
+// ctx with hooks recording the timing of the request issued with it
+func (tt *timingTracer) withTrace(ctx context.Context) context.Context {
+	// record the time under lock, then let each hook update the timing
+	at := func(update func(now time.Time)) {
+		now := time.Now()
+		tt.mux.Lock()
+		defer tt.mux.Unlock()
+		update(now)
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			at(func(now time.Time) { tt.start = now })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			at(func(time.Time) { tt.timing.ReusedConn = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			at(func(now time.Time) { tt.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			at(func(now time.Time) { tt.timing.DNS = now.Sub(tt.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			at(func(now time.Time) {
+				if tt.connectStart.IsZero() {
+					tt.connectStart = now
+				}
+			})
+		},
+		ConnectDone: func(_ string, _ string, err error) {
+			at(func(now time.Time) {
+				if err == nil && tt.timing.Connect == 0 {
+					tt.timing.Connect = now.Sub(tt.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			at(func(now time.Time) { tt.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			at(func(now time.Time) { tt.timing.TLSHandshake = now.Sub(tt.tlsStart) })
+		},
+		GotFirstResponseByte: func() {
+			at(func(now time.Time) { tt.timing.TimeToFirstByte = now.Sub(tt.start) })
+		},
+	})
+}
+
+// timing recorded so far, with the given total, or nil if the request was not issued
+func (tt *timingTracer) result(total time.Duration) *RequestTiming {
+	tt.mux.Lock()
+	defer tt.mux.Unlock()
+	if tt.start.IsZero() {
+		return nil
+	}
+	timing := tt.timing
+	timing.Total = total
+	return &timing
+}
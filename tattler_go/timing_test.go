@@ -0,0 +1,46 @@
+package tattler_go
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceTiming(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := TattlerClientHTTP{Endpoint: server.URL, Scope: "testScope", TraceTiming: true}
+	for i, reused := range []bool{false, true} {
+		result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+		if err != nil || result.Timing == nil {
+			t.Fatalf("SendNotificationOptions() #%v with TraceTiming reports no timing (err=%v)", i, err)
+		}
+		timing := result.Timing
+		if timing.TimeToFirstByte < delay || timing.Total < timing.TimeToFirstByte {
+			t.Fatalf("SendNotificationOptions() #%v to server taking %v reports timing %+v", i, delay, timing)
+		}
+		if timing.ReusedConn != reused || (reused && timing.Connect != 0) {
+			t.Fatalf("SendNotificationOptions() #%v reports timing %+v; want ReusedConn=%v", i, timing, reused)
+		}
+	}
+
+	n.TraceTiming = false
+	result, err := n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if err != nil || result.Timing != nil {
+		t.Fatalf("SendNotificationOptions() without TraceTiming reports timing %+v (err=%v); want none", result.Timing, err)
+	}
+
+	n.TraceTiming = true
+	server.Close()
+	result, err = n.SendNotificationOptions(context.Background(), "636", "ev", map[string]string{}, []string{}, "", SendOptions{})
+	if err == nil || result.Timing == nil || result.Timing.TimeToFirstByte != 0 {
+		t.Fatalf("SendNotificationOptions() with TraceTiming to closed server reports timing %+v (err=%v); want one without response", result.Timing, err)
+	}
+}